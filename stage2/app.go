@@ -1,14 +1,25 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/compress"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/mryan-3/hng11/stage2/billing"
 	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/jobs"
+	"github.com/mryan-3/hng11/stage2/mailer"
+	"github.com/mryan-3/hng11/stage2/payments"
+	"github.com/mryan-3/hng11/stage2/middleware"
+	"github.com/mryan-3/hng11/stage2/outbox"
 	"github.com/mryan-3/hng11/stage2/routes"
 )
 
@@ -22,6 +33,20 @@ func init() {
 func main (){
 
 	fmt.Println("Starting the server ...")
+
+	// The auth cookie can't be issued without a key
+	if os.Getenv("ENCRYPT_COOKIE_KEY") == "" {
+		log.Fatal("ENCRYPT_COOKIE_KEY must be set")
+	}
+
+	if err := mailer.Configure(); err != nil {
+		log.Fatal(err)
+	}
+	payments.Configure()
+	if err := billing.Configure(); err != nil {
+		log.Fatal(err)
+	}
+
     app := fiber.New()
 
 	// Middleware
@@ -29,13 +54,39 @@ func main (){
 	app.Use(compress.New())
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     os.Getenv("CLIENT_FRONTEND_URL") + "," + os.Getenv("ADMIN_FRONTEND_URL"),
-		AllowHeaders:     "Origin, Content-Type, Accept, Authorization",
+		AllowHeaders:     "Origin, Content-Type, Accept, Authorization, " + middleware.HeaderCSRFToken,
 		AllowMethods:     "GET, POST, PUT, DELETE, OPTIONS",
 		AllowCredentials: true,
 	}))
 
 
     routes.SetUpRoutes(app)
+	jobs.Start()
+
+	// Run outbox jobs in this process unless OUTBOX_WORKERS=0, e.g.
+	// because cmd/worker runs them
+	ctx, stop := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	if n := outbox.Workers(); n > 0 {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			outbox.NewPool(database.DB.Db, n).Run(ctx)
+		}()
+	}
+
+	// On SIGINT or SIGTERM stop taking requests, then let running jobs
+	// finish before exiting
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		fmt.Println("Shutting down ...")
+		if err := app.Shutdown(); err != nil {
+			log.Println("Failed to shut down the server: ", err)
+		}
+	}()
 
 	app.Get("/ping", func(c *fiber.Ctx) error {
 		return c.SendString("Server is online.")
@@ -48,5 +99,10 @@ func main (){
 
 
     port := os.Getenv("PORT")
-	app.Listen(":"+port)
+	if err := app.Listen(":"+port); err != nil {
+		log.Println(err)
+	}
+
+	stop()
+	workers.Wait()
 }
@@ -0,0 +1,199 @@
+// Package audit records security relevant changes.
+//
+// Events are written with the transaction that makes the change so the
+// log can't disagree with the data:
+//
+//	database.DB.Db.Transaction(func(tx *gorm.DB) error {
+//		...
+//		return audit.Record(tx, audit.FromRequest(c, audit.MemberAdded).Org(orgId).Target("user", userId))
+//	})
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/models"
+	"gorm.io/gorm"
+)
+
+// Audited actions
+const (
+	UserRegistered         = "user.registered"
+	UserLoggedIn           = "user.login"
+	UserLoginFailed        = "user.login_failed"
+	UserEmailChanged       = "user.email_changed"
+	UserDeletionRequested  = "user.deletion_requested"
+	UserDeleted            = "user.deleted"
+	UserRestored           = "user.restored"
+	TwoFactorEnabled       = "user.two_factor.enabled"
+	TwoFactorDisabled      = "user.two_factor.disabled"
+	ApiKeyCreated          = "api_key.created"
+	ApiKeyUpdated          = "api_key.updated"
+	ApiKeyRevoked          = "api_key.revoked"
+	SessionRevoked         = "session.revoked"
+	OrganisationCreated    = "organisation.created"
+	OrganisationUpdated    = "organisation.updated"
+	OrganisationDeleted    = "organisation.deleted"
+	OrganisationRestored   = "organisation.restored"
+	TwoFactorPolicyChanged = "organisation.two_factor_policy.changed"
+	MemberAdded            = "organisation.member.added"
+	MemberUpdated          = "organisation.member.updated"
+	MemberRemoved          = "organisation.member.removed"
+	RoleCreated            = "organisation.role.created"
+	RoleUpdated            = "organisation.role.updated"
+	RoleDeleted            = "organisation.role.deleted"
+	TeamCreated            = "organisation.team.created"
+	TeamUpdated            = "organisation.team.updated"
+	TeamDeleted            = "organisation.team.deleted"
+	TeamMemberSet          = "organisation.team.member_set"
+	TeamMemberRemoved      = "organisation.team.member_removed"
+
+	EmailTemplateCreated = "organisation.email_template.created"
+	EmailTemplateUpdated = "organisation.email_template.updated"
+	EmailTemplateDeleted = "organisation.email_template.deleted"
+
+	PaymentCreated       = "organisation.payment.created"
+	PaymentRefunded      = "organisation.payment.refunded"
+	PaymentStatusChanged = "organisation.payment.status_changed"
+
+	SubscriptionChanged = "organisation.subscription.changed"
+	InvoiceIssued       = "organisation.invoice.issued"
+
+	WebhookCreated       = "organisation.webhook.created"
+	WebhookUpdated       = "organisation.webhook.updated"
+	WebhookDeleted       = "organisation.webhook.deleted"
+	WebhookSecretRotated = "organisation.webhook.secret_rotated"
+
+	OwnershipTransferRequested = "organisation.ownership_transfer.requested"
+	OwnershipTransferAccepted  = "organisation.ownership_transfer.accepted"
+	OwnershipTransferDeclined  = "organisation.ownership_transfer.declined"
+	OwnershipTransferCancelled = "organisation.ownership_transfer.cancelled"
+
+	AuditReanchored = "audit.reanchored"
+)
+
+type Entry struct {
+	OrgID      *uuid.UUID
+	ActorID    *uuid.UUID
+	Action     string
+	TargetType string
+	TargetID   string
+	IP         string
+	UserAgent  string
+	Metadata   map[string]interface{}
+	// Metadata about a person, such as an email address, kept with the
+	// IP and user agent where it can be erased
+	Personal map[string]interface{}
+}
+
+// Start an entry for the request's user, IP and user agent
+func FromRequest(c *fiber.Ctx, action string) Entry {
+	entry := Entry{
+		Action:    action,
+		IP:        c.IP(),
+		UserAgent: truncate(c.Get(fiber.HeaderUserAgent), 512),
+	}
+
+	if userId, ok := c.Locals("userId").(string); ok {
+		if actor, err := uuid.Parse(userId); err == nil {
+			entry.ActorID = &actor
+		}
+	}
+
+	return entry
+}
+
+// Set the actor, for requests made before the user is logged in
+func (e Entry) Actor(actorId uuid.UUID) Entry {
+	e.ActorID = &actorId
+	return e
+}
+
+func (e Entry) Org(orgId uuid.UUID) Entry {
+	e.OrgID = &orgId
+	return e
+}
+
+func (e Entry) Target(targetType string, targetId interface{}) Entry {
+	e.TargetType = targetType
+	e.TargetID = fmt.Sprint(targetId)
+	return e
+}
+
+func (e Entry) With(metadata map[string]interface{}) Entry {
+	e.Metadata = metadata
+	return e
+}
+
+// Add metadata that identifies a person. It is shown with the event but
+// erased when the user it is about is purged.
+func (e Entry) WithPersonal(personal map[string]interface{}) Entry {
+	e.Personal = personal
+	return e
+}
+
+// Record an event using tx, so it is only kept if the change it
+// describes is committed
+func Record(tx *gorm.DB, entry Entry) error {
+	event, err := newEvent(entry)
+	if err != nil {
+		return err
+	}
+
+	// The detail is written separately; it isn't part of the chain
+	detail := event.Detail
+	event.Detail = nil
+
+	if err := appendEvent(tx, &event); err != nil {
+		return err
+	}
+
+	if detail == nil {
+		return nil
+	}
+	return tx.Create(detail).Error
+}
+
+func newEvent(entry Entry) (models.AuditEvent, error) {
+	metadata := []byte("{}")
+	if len(entry.Metadata) > 0 {
+		var err error
+		if metadata, err = json.Marshal(entry.Metadata); err != nil {
+			return models.AuditEvent{}, err
+		}
+	}
+
+	event := models.AuditEvent{
+		// Set here rather than by the database because they are hashed
+		EventID:   uuid.New(),
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+
+		Chain:          chainOf(entry.OrgID),
+		OrganisationID: entry.OrgID,
+		ActorID:        entry.ActorID,
+		Action:         entry.Action,
+		TargetType:     entry.TargetType,
+		TargetID:       entry.TargetID,
+		Metadata:       canonicalJson(string(metadata)),
+	}
+
+	detail, err := newDetail(event.EventID, entry)
+	if err != nil || detail == nil {
+		return event, err
+	}
+
+	event.Detail = detail
+	event.DetailsHash = detailsDigest(*detail)
+	return event, nil
+}
+
+func truncate(text string, max int) string {
+	if len(text) > max {
+		return text[:max]
+	}
+	return text
+}
@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewEventStoresMetadataAsJson(t *testing.T) {
+	actor := uuid.New()
+
+	event, err := newEvent(Entry{Action: OwnershipTransferRequested}.
+		Actor(actor).
+		With(map[string]interface{}{"toUserId": "abc"}))
+
+	assert.NoError(t, err)
+	assert.Equal(t, actor, *event.ActorID)
+	assert.Equal(t, OwnershipTransferRequested, event.Action)
+	assert.Equal(t, `{"toUserId":"abc"}`, event.Metadata)
+}
+
+func TestNewEventKeepsPersonalDataApart(t *testing.T) {
+	event, err := newEvent(Entry{Action: UserLoggedIn, IP: "10.0.0.1", UserAgent: "test-agent"}.
+		With(map[string]interface{}{"twoFactor": true}).
+		WithPersonal(map[string]interface{}{"email": "jill@example.com"}))
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"twoFactor":true}`, event.Metadata)
+	assert.NotContains(t, event.Metadata, "jill")
+
+	detail := event.Detail
+	assert.NotNil(t, detail)
+	assert.Equal(t, event.EventID, detail.EventID)
+	assert.Equal(t, "10.0.0.1", detail.IP)
+	assert.Equal(t, `{"email":"jill@example.com"}`, detail.Personal)
+	assert.Equal(t, detailsDigest(*detail), event.DetailsHash)
+
+	// A changed detail no longer matches the digest the event's hash covers
+	tampered := *detail
+	tampered.IP = "10.0.0.2"
+	assert.NotEqual(t, detailsDigest(tampered), event.DetailsHash)
+}
+
+func TestNewEventWithoutPersonalData(t *testing.T) {
+	event, err := newEvent(Entry{Action: InvoiceIssued})
+
+	assert.NoError(t, err)
+	assert.Nil(t, event.Detail)
+	assert.Empty(t, event.DetailsHash)
+}
+
+func TestNewEventDefaultsToEmptyMetadata(t *testing.T) {
+	event, err := newEvent(Entry{Action: OwnershipTransferCancelled})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", event.Metadata)
+	assert.Nil(t, event.OrganisationID)
+	assert.Nil(t, event.ActorID)
+}
+
+func TestFromRequest(t *testing.T) {
+	actor := uuid.New()
+	org := uuid.New()
+
+	var entry Entry
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		c.Locals("userId", actor.String())
+		entry = FromRequest(c, MemberAdded).Org(org).Target("user", 42)
+		return c.SendStatus(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("User-Agent", "test-agent")
+	_, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, MemberAdded, entry.Action)
+	assert.Equal(t, actor, *entry.ActorID)
+	assert.Equal(t, org, *entry.OrgID)
+	assert.Equal(t, "user", entry.TargetType)
+	assert.Equal(t, "42", entry.TargetID)
+	assert.Equal(t, "test-agent", entry.UserAgent)
+	assert.NotEmpty(t, entry.IP)
+}
@@ -0,0 +1,176 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/models"
+	"gorm.io/gorm"
+)
+
+// Chain of the events that belong to no organisation
+const GlobalChain = "global"
+
+// The chain an event belongs to: its organisation's, or the global one
+func chainOf(orgId *uuid.UUID) string {
+	if orgId == nil {
+		return GlobalChain
+	}
+	return orgId.String()
+}
+
+// Add an event to the end of its chain. Writers to the same chain take
+// turns on a transaction scoped advisory lock, so sequences never clash.
+func appendEvent(tx *gorm.DB, event *models.AuditEvent) error {
+	return tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "audit:"+event.Chain).Error; err != nil {
+			return err
+		}
+
+		var last models.AuditEvent
+		err := tx.Where("chain = ?", event.Chain).Order("sequence DESC").Limit(1).Find(&last).Error
+		if err != nil {
+			return err
+		}
+
+		link(event, last)
+
+		return tx.Create(event).Error
+	})
+}
+
+// Link an event to the one before it. last is empty for the first event.
+func link(event *models.AuditEvent, last models.AuditEvent) {
+	event.Sequence = last.Sequence + 1
+	event.PrevHash = last.Hash
+	event.Hash = hashEvent(*event)
+}
+
+// The hash of an event's contents and the previous event's hash
+func hashEvent(event models.AuditEvent) string {
+	return hashFields(event.PrevHash,
+		event.Chain,
+		strconv.FormatInt(event.Sequence, 10),
+		event.EventID.String(),
+		optionalId(event.OrganisationID),
+		optionalId(event.ActorID),
+		event.Action,
+		event.TargetType,
+		event.TargetID,
+		event.DetailsHash,
+		canonicalJson(event.Metadata),
+		event.CreatedAt.UTC().Format(time.RFC3339Nano),
+	)
+}
+
+// The hash of an event recorded while its IP address and user agent were
+// kept on the event itself
+func hashLegacyEvent(event models.AuditEvent, ip string, userAgent string) string {
+	return hashFields(event.PrevHash,
+		event.Chain,
+		strconv.FormatInt(event.Sequence, 10),
+		event.EventID.String(),
+		optionalId(event.OrganisationID),
+		optionalId(event.ActorID),
+		event.Action,
+		event.TargetType,
+		event.TargetID,
+		ip,
+		userAgent,
+		canonicalJson(event.Metadata),
+		event.CreatedAt.UTC().Format(time.RFC3339Nano),
+	)
+}
+
+func hashFields(prevHash string, fields ...string) string {
+	encoded, _ := json.Marshal(fields)
+
+	sum := sha256.Sum256(append([]byte(prevHash), encoded...))
+	return hex.EncodeToString(sum[:])
+}
+
+// Whether an event's hash matches its contents. detail is nil if the
+// event has none or it was erased. Legacy hashes cover the IP address and
+// user agent, so once those are erased only the event's place in the
+// chain can be checked.
+func hashMatches(event models.AuditEvent, detail *models.AuditEventDetail) bool {
+	switch {
+	case !event.LegacyHash:
+		return hashEvent(event) == event.Hash
+	case detail != nil:
+		return hashLegacyEvent(event, detail.IP, detail.UserAgent) == event.Hash
+	case event.DetailsHash == "":
+		return hashLegacyEvent(event, "", "") == event.Hash
+	default:
+		return true
+	}
+}
+
+// Re-encode JSON with sorted keys and no whitespace. Postgres stores
+// metadata as jsonb, which doesn't keep the text it was given.
+func canonicalJson(text string) string {
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return text
+	}
+
+	canonical, err := json.Marshal(value)
+	if err != nil {
+		return text
+	}
+	return string(canonical)
+}
+
+func optionalId(id *uuid.UUID) string {
+	if id == nil {
+		return ""
+	}
+	return id.String()
+}
+
+// Link events recorded before chaining existed onto the end of their
+// chains, oldest first. The append only trigger is paused while they're
+// updated.
+func ChainUnchained(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var events []models.AuditEvent
+		if err := tx.Where("hash = ''").Order("id").Find(&events).Error; err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		if err := tx.Exec("ALTER TABLE audit_events DISABLE TRIGGER audit_events_append_only").Error; err != nil {
+			return err
+		}
+
+		heads := map[string]models.AuditEvent{}
+		for _, event := range events {
+			event.Chain = chainOf(event.OrganisationID)
+
+			last, ok := heads[event.Chain]
+			if !ok {
+				err := tx.Where("chain = ? AND hash <> ''", event.Chain).Order("sequence DESC").Limit(1).Find(&last).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			link(&event, last)
+			heads[event.Chain] = event
+
+			err := tx.Exec("UPDATE audit_events SET chain = ?, sequence = ?, prev_hash = ?, hash = ? WHERE id = ?",
+				event.Chain, event.Sequence, event.PrevHash, event.Hash, event.ID).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Exec("ALTER TABLE audit_events ENABLE TRIGGER audit_events_append_only").Error
+	})
+}
@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// Build a linked chain of n events in memory
+func buildChain(t *testing.T, n int) []models.AuditEvent {
+	org := uuid.New()
+
+	var events []models.AuditEvent
+	var last models.AuditEvent
+	for i := 0; i < n; i++ {
+		event, err := newEvent(Entry{Action: MemberAdded, Metadata: map[string]interface{}{"i": i}}.Org(org))
+		assert.NoError(t, err)
+
+		link(&event, last)
+		events = append(events, event)
+		last = event
+	}
+	return events
+}
+
+func TestChainLinks(t *testing.T) {
+	events := buildChain(t, 3)
+
+	assert.Equal(t, int64(1), events[0].Sequence)
+	assert.Empty(t, events[0].PrevHash)
+	assert.Equal(t, events[0].Hash, events[1].PrevHash)
+	assert.Equal(t, events[1].Hash, events[2].PrevHash)
+
+	var last models.AuditEvent
+	for _, event := range events {
+		assert.Nil(t, checkLink(event, last, nil))
+		last = event
+	}
+}
+
+func TestCheckLinkFindsTampering(t *testing.T) {
+	events := buildChain(t, 3)
+
+	edited := events[1]
+	edited.Action = OwnershipTransferAccepted
+	brk := checkLink(edited, events[0], nil)
+	assert.Equal(t, int64(2), brk.Sequence)
+	assert.Equal(t, "hash doesn't match the event", brk.Reason)
+
+	// Removing an event leaves a gap
+	brk = checkLink(events[2], events[0], nil)
+	assert.Equal(t, int64(2), brk.Sequence)
+	assert.Equal(t, "event is missing", brk.Reason)
+
+	// Replacing an event with a rehashed one breaks the next link
+	forged := events[1]
+	forged.TargetID = "someone-else"
+	forged.Hash = hashEvent(forged)
+	assert.Nil(t, checkLink(forged, events[0], nil))
+	brk = checkLink(events[2], forged, nil)
+	assert.Equal(t, "previous hash doesn't match", brk.Reason)
+}
+
+func TestHashSurvivesJsonbRoundTrip(t *testing.T) {
+	event := buildChain(t, 1)[0]
+
+	// Postgres hands jsonb back with its own spacing and key order
+	event.Metadata = `{"i": 0}`
+	event.CreatedAt = event.CreatedAt.In(time.FixedZone("EAT", 3*60*60))
+
+	assert.Equal(t, event.Hash, hashEvent(event))
+}
+
+func TestChainOf(t *testing.T) {
+	org := uuid.New()
+
+	assert.Equal(t, GlobalChain, chainOf(nil))
+	assert.Equal(t, org.String(), chainOf(&org))
+}
+
+func TestCheckpointSignature(t *testing.T) {
+	t.Setenv("AUDIT_SIGNING_KEY", base64.StdEncoding.EncodeToString(make([]byte, ed25519.SeedSize)))
+
+	key, err := signingKey()
+	assert.NoError(t, err)
+	publicKey, err := PublicKey()
+	assert.NoError(t, err)
+
+	checkpoint := models.AuditCheckpoint{Chain: GlobalChain, Sequence: 7, Hash: "abc", CreatedAt: time.Now()}
+	signature := ed25519.Sign(key, checkpointMessage(checkpoint))
+	assert.True(t, ed25519.Verify(publicKey, checkpointMessage(checkpoint), signature))
+
+	checkpoint.Sequence = 6
+	assert.False(t, ed25519.Verify(publicKey, checkpointMessage(checkpoint), signature))
+}
+
+func TestSigningDisabledWithoutKey(t *testing.T) {
+	t.Setenv("AUDIT_SIGNING_KEY", "")
+
+	assert.False(t, SigningEnabled())
+	_, err := PublicKey()
+	assert.ErrorIs(t, err, ErrNoSigningKey)
+}
+
+func TestLegacyHashes(t *testing.T) {
+	event := buildChain(t, 1)[0]
+	event.LegacyHash = true
+	event.Hash = hashLegacyEvent(event, "10.0.0.1", "curl")
+
+	detail, err := newDetail(event.EventID, Entry{IP: "10.0.0.1", UserAgent: "curl"})
+	assert.NoError(t, err)
+	event.DetailsHash = detailsDigest(*detail)
+
+	assert.Nil(t, checkLink(event, models.AuditEvent{}, detail))
+
+	// Once the detail is erased only the link can be checked
+	assert.Nil(t, checkLink(event, models.AuditEvent{}, nil))
+
+	// A detail that was changed no longer matches the hash
+	detail.IP = "10.0.0.2"
+	brk := checkLink(event, models.AuditEvent{}, detail)
+	assert.Equal(t, "hash doesn't match the event", brk.Reason)
+
+	// Legacy events that never had a detail are still checked in full
+	bare := buildChain(t, 1)[0]
+	bare.LegacyHash = true
+	bare.Hash = hashLegacyEvent(bare, "", "")
+	assert.Nil(t, checkLink(bare, models.AuditEvent{}, nil))
+	bare.Action = OwnershipTransferAccepted
+	assert.NotNil(t, checkLink(bare, models.AuditEvent{}, nil))
+}
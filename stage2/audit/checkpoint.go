@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mryan-3/hng11/stage2/models"
+	"gorm.io/gorm"
+)
+
+var ErrNoSigningKey = errors.New("AUDIT_SIGNING_KEY must be a base64 encoded 32 byte seed")
+
+// Whether checkpoints can be signed
+func SigningEnabled() bool {
+	_, err := signingKey()
+	return err == nil
+}
+
+// The key checkpoint signatures can be checked with
+func PublicKey() (ed25519.PublicKey, error) {
+	key, err := signingKey()
+	if err != nil {
+		return nil, err
+	}
+	return key.Public().(ed25519.PublicKey), nil
+}
+
+// Sign the head of every chain that has moved since its last checkpoint
+func WriteCheckpoints(db *gorm.DB) error {
+	key, err := signingKey()
+	if err != nil {
+		return err
+	}
+
+	var heads []models.AuditEvent
+	err = db.Raw(`
+		SELECT e.chain, e.sequence, e.hash FROM audit_events e
+		JOIN (SELECT chain, max(sequence) AS sequence FROM audit_events GROUP BY chain) head
+			ON head.chain = e.chain AND head.sequence = e.sequence
+		WHERE NOT EXISTS (
+			SELECT 1 FROM audit_checkpoints c WHERE c.chain = e.chain AND c.sequence >= e.sequence
+		)`,
+	).Scan(&heads).Error
+	if err != nil {
+		return err
+	}
+
+	for _, head := range heads {
+		checkpoint := models.AuditCheckpoint{
+			Chain:     head.Chain,
+			Sequence:  head.Sequence,
+			Hash:      head.Hash,
+			CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+		}
+		checkpoint.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, checkpointMessage(checkpoint)))
+
+		if err := db.Create(&checkpoint).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// What a checkpoint's signature covers
+func checkpointMessage(checkpoint models.AuditCheckpoint) []byte {
+	return []byte(fmt.Sprintf("hng11-audit-checkpoint\n%s\n%d\n%s\n%s",
+		checkpoint.Chain,
+		checkpoint.Sequence,
+		checkpoint.Hash,
+		checkpoint.CreatedAt.UTC().Format(time.RFC3339Nano),
+	))
+}
+
+func decodeSignature(signature string) []byte {
+	decoded, _ := base64.StdEncoding.DecodeString(signature)
+	return decoded
+}
+
+func signingKey() (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(os.Getenv("AUDIT_SIGNING_KEY"))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, ErrNoSigningKey
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
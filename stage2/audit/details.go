@@ -0,0 +1,178 @@
+package audit
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/models"
+	"gorm.io/gorm"
+)
+
+// The personal data of an entry, or nil if it has none
+func newDetail(eventId uuid.UUID, entry Entry) (*models.AuditEventDetail, error) {
+	if entry.IP == "" && entry.UserAgent == "" && len(entry.Personal) == 0 {
+		return nil, nil
+	}
+
+	personal := []byte("{}")
+	if len(entry.Personal) > 0 {
+		var err error
+		if personal, err = json.Marshal(entry.Personal); err != nil {
+			return nil, err
+		}
+	}
+
+	salt, err := newSalt()
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AuditEventDetail{
+		EventID:   eventId,
+		IP:        entry.IP,
+		UserAgent: entry.UserAgent,
+		Personal:  canonicalJson(string(personal)),
+		Salt:      salt,
+	}, nil
+}
+
+func newSalt() (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(salt), nil
+}
+
+// The digest of a detail kept on its event. It covers the salt, so it
+// says nothing about the detail once the detail is deleted.
+func detailsDigest(detail models.AuditEventDetail) string {
+	fields, _ := json.Marshal([]string{
+		detail.Salt,
+		detail.EventID.String(),
+		detail.IP,
+		detail.UserAgent,
+		canonicalJson(detail.Personal),
+	})
+
+	sum := sha256.Sum256(fields)
+	return hex.EncodeToString(sum[:])
+}
+
+// Erase the personal data of the events a user made or that are about
+// them. The events themselves stay, and their chains still verify.
+func EraseUser(tx *gorm.DB, userId uuid.UUID) error {
+	events := tx.Model(&models.AuditEvent{}).
+		Select("event_id").
+		Where("actor_id = ? OR (target_type = ? AND target_id = ?)", userId, "user", userId.String())
+
+	return tx.Where("event_id IN (?)", events).Delete(&models.AuditEventDetail{}).Error
+}
+
+// Move the IP address and user agent of events recorded before they were
+// kept apart into details. Their hashes and the checkpoints signing them
+// are left as they are: the events are marked as legacy, and verifying
+// them checks their hash against their details for as long as those are
+// kept. Each chain then gets an event recording the re-anchoring, and the
+// new heads are checkpointed when a signing key is configured.
+func SeparateDetails(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&models.AuditEvent{}, "ip") {
+		return nil
+	}
+
+	var chains []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("ALTER TABLE audit_events DISABLE TRIGGER audit_events_append_only").Error; err != nil {
+			return err
+		}
+
+		var rows []struct {
+			ID        uint
+			EventID   uuid.UUID
+			IP        string
+			UserAgent string
+		}
+		err := tx.Raw("SELECT id, event_id, ip, user_agent FROM audit_events WHERE COALESCE(ip, '') <> '' OR COALESCE(user_agent, '') <> ''").
+			Scan(&rows).Error
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			detail, err := newDetail(row.EventID, Entry{IP: row.IP, UserAgent: row.UserAgent})
+			if err != nil {
+				return err
+			}
+			if err := tx.Create(detail).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("UPDATE audit_events SET details_hash = ? WHERE id = ?", detailsDigest(*detail), row.ID).Error; err != nil {
+				return err
+			}
+		}
+
+		// Events not chained yet are chained later in the current format
+		if err := tx.Exec("UPDATE audit_events SET legacy_hash = true WHERE hash <> ''").Error; err != nil {
+			return err
+		}
+
+		for _, column := range []string{"ip", "user_agent"} {
+			if err := tx.Migrator().DropColumn(&models.AuditEvent{}, column); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Exec("ALTER TABLE audit_events ENABLE TRIGGER audit_events_append_only").Error; err != nil {
+			return err
+		}
+
+		if chains, err = Chains(tx); err != nil {
+			return err
+		}
+		for _, chain := range chains {
+			if err := reanchor(tx, chain); err != nil {
+				return fmt.Errorf("re-anchoring chain %s: %w", chain, err)
+			}
+		}
+
+		log.Printf("Moved the personal data of %d audit events out of their chains and re-anchored %d chains", len(rows), len(chains))
+		return nil
+	})
+	if err != nil || !SigningEnabled() {
+		return err
+	}
+
+	return WriteCheckpoints(db)
+}
+
+// Record that a chain's earlier events are legacy. The event carries the
+// head it follows, so the point where hashing changed is itself part of
+// the chain.
+func reanchor(tx *gorm.DB, chain string) error {
+	var head models.AuditEvent
+	if err := tx.Where("chain = ? AND hash <> ''", chain).Order("sequence DESC").Limit(1).Find(&head).Error; err != nil {
+		return err
+	}
+	if head.Hash == "" {
+		return nil
+	}
+
+	entry := Entry{Action: AuditReanchored, Metadata: map[string]interface{}{
+		"legacyHeadSequence": head.Sequence,
+		"legacyHeadHash":     head.Hash,
+	}}
+	if chain != GlobalChain {
+		orgId, err := uuid.Parse(chain)
+		if err != nil {
+			return err
+		}
+		entry = entry.Org(orgId)
+	}
+
+	return Record(tx, entry)
+}
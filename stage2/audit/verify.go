@@ -0,0 +1,165 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/models"
+	"gorm.io/gorm"
+)
+
+// Events read at a time while verifying a chain
+const verifyBatchSize = 500
+
+// The first place a chain stops checking out
+type Break struct {
+	Sequence int64      `json:"sequence"`
+	EventID  *uuid.UUID `json:"eventId"`
+	Reason   string     `json:"reason"`
+}
+
+// The result of walking a chain
+type Verification struct {
+	Chain        string `json:"chain"`
+	Valid        bool   `json:"valid"`
+	Checked      int64  `json:"checked"`
+	HeadSequence int64  `json:"headSequence"`
+	HeadHash     string `json:"headHash"`
+	Checkpoints  int    `json:"checkpoints"`
+	Break        *Break `json:"break,omitempty"`
+}
+
+// Every chain with at least one event
+func Chains(db *gorm.DB) ([]string, error) {
+	var chains []string
+	err := db.Model(&models.AuditEvent{}).Distinct("chain").Order("chain").Pluck("chain", &chains).Error
+
+	return chains, err
+}
+
+// Walk a chain from its first event, checking every link, then check the
+// chain against its signed checkpoints. Checkpoint signatures are only
+// checked when a signing key is configured.
+func Verify(db *gorm.DB, chain string) (Verification, error) {
+	result := Verification{Chain: chain, Valid: true}
+
+	var checkpoints []models.AuditCheckpoint
+	if err := db.Where("chain = ?", chain).Order("sequence").Find(&checkpoints).Error; err != nil {
+		return result, err
+	}
+	result.Checkpoints = len(checkpoints)
+
+	publicKey, _ := PublicKey()
+	checkpointAt := map[int64]models.AuditCheckpoint{}
+	for _, checkpoint := range checkpoints {
+		if publicKey != nil && !ed25519.Verify(publicKey, checkpointMessage(checkpoint), decodeSignature(checkpoint.Signature)) {
+			result.fail(&Break{Sequence: checkpoint.Sequence, Reason: "checkpoint signature is invalid"})
+			return result, nil
+		}
+		checkpointAt[checkpoint.Sequence] = checkpoint
+	}
+
+	var last models.AuditEvent
+	for {
+		var batch []models.AuditEvent
+		err := db.Where("chain = ? AND (sequence, id) > (?, ?)", chain, last.Sequence, last.ID).
+			Order("sequence").
+			Order("id").
+			Limit(verifyBatchSize).
+			Find(&batch).Error
+		if err != nil {
+			return result, err
+		}
+
+		details, err := detailsOf(db, batch)
+		if err != nil {
+			return result, err
+		}
+
+		for _, event := range batch {
+			var detail *models.AuditEventDetail
+			if found, ok := details[event.EventID]; ok {
+				detail = &found
+			}
+
+			if brk := checkLink(event, last, detail); brk != nil {
+				result.fail(brk)
+				return result, nil
+			}
+
+			// Erased details are fine, but ones that are kept must match
+			if detail != nil && detailsDigest(*detail) != event.DetailsHash {
+				result.fail(&Break{Sequence: event.Sequence, EventID: &event.EventID, Reason: "personal data doesn't match the event"})
+				return result, nil
+			}
+
+			if checkpoint, ok := checkpointAt[event.Sequence]; ok && checkpoint.Hash != event.Hash {
+				result.fail(&Break{Sequence: event.Sequence, EventID: &event.EventID, Reason: "hash doesn't match the signed checkpoint"})
+				return result, nil
+			}
+
+			last = event
+			result.Checked++
+			result.HeadSequence = event.Sequence
+			result.HeadHash = event.Hash
+		}
+
+		if len(batch) < verifyBatchSize {
+			break
+		}
+	}
+
+	// A checkpoint past the head means events were cut off the end
+	if n := len(checkpoints); n > 0 && checkpoints[n-1].Sequence > last.Sequence {
+		result.fail(&Break{
+			Sequence: last.Sequence + 1,
+			Reason:   fmt.Sprintf("events up to checkpoint %d are missing", checkpoints[n-1].Sequence),
+		})
+	}
+
+	return result, nil
+}
+
+// Check that event follows last. last is empty for the first event, and
+// detail is the event's personal data if it still has any.
+func checkLink(event models.AuditEvent, last models.AuditEvent, detail *models.AuditEventDetail) *Break {
+	switch {
+	case event.Sequence == last.Sequence:
+		return &Break{Sequence: event.Sequence, EventID: &event.EventID, Reason: "sequence is used more than once"}
+	case event.Sequence != last.Sequence+1:
+		return &Break{Sequence: last.Sequence + 1, Reason: "event is missing"}
+	case event.PrevHash != last.Hash:
+		return &Break{Sequence: event.Sequence, EventID: &event.EventID, Reason: "previous hash doesn't match"}
+	case !hashMatches(event, detail):
+		return &Break{Sequence: event.Sequence, EventID: &event.EventID, Reason: "hash doesn't match the event"}
+	}
+	return nil
+}
+
+// The details of a batch of events that still have them, by event id
+func detailsOf(db *gorm.DB, events []models.AuditEvent) (map[uuid.UUID]models.AuditEventDetail, error) {
+	ids := make([]uuid.UUID, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.EventID)
+	}
+
+	details := map[uuid.UUID]models.AuditEventDetail{}
+	if len(ids) == 0 {
+		return details, nil
+	}
+
+	var found []models.AuditEventDetail
+	if err := db.Where("event_id IN ?", ids).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	for _, detail := range found {
+		details[detail.EventID] = detail
+	}
+	return details, nil
+}
+
+func (v *Verification) fail(brk *Break) {
+	v.Valid = false
+	v.Break = brk
+}
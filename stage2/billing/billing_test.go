@@ -0,0 +1,151 @@
+package billing
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestProrate(t *testing.T) {
+	start := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	end := PeriodEnd(start)
+
+	testCases := []struct {
+		name     string
+		amount   int64
+		at       time.Time
+		expected int64
+	}{
+		{"start of period", 3000, start, 3000},
+		{"before period", 3000, start.Add(-time.Hour), 3000},
+		{"half way", 3000, start.AddDate(0, 0, 15), 1500},
+		{"a third left", 3000, start.AddDate(0, 0, 20), 1000},
+		{"rounds half up", 1, start.AddDate(0, 0, 15), 1},
+		{"rounds to nearest", 1000, start.AddDate(0, 0, 29).Add(12 * time.Hour), 17},
+		{"credit", -3000, start.AddDate(0, 0, 15), -1500},
+		{"end of period", 3000, end, 0},
+		{"after period", 3000, end.Add(time.Hour), 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Prorate(tc.amount, start, end, tc.at))
+		})
+	}
+}
+
+func TestPlans(t *testing.T) {
+	plan, err := LookupPlan(DefaultPlan)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), plan.SeatPrice)
+
+	_, err = LookupPlan("enterprise")
+	assert.ErrorIs(t, err, ErrUnknownPlan)
+
+	seen := map[string]bool{}
+	for _, plan := range Plans() {
+		assert.False(t, seen[plan.ID], plan.ID)
+		seen[plan.ID] = true
+	}
+}
+
+func TestPlanLimits(t *testing.T) {
+	limited := Plan{MaxMembers: 5, MaxOrgsPerUser: 2}
+	assert.True(t, limited.AllowsMembers(5))
+	assert.False(t, limited.AllowsMembers(6))
+	assert.True(t, limited.AllowsOrgs(2))
+	assert.False(t, limited.AllowsOrgs(3))
+
+	unlimited := Plan{}
+	assert.True(t, unlimited.AllowsMembers(100000))
+	assert.True(t, unlimited.AllowsOrgs(100000))
+}
+
+func TestParseTaxRates(t *testing.T) {
+	rates, err := ParseTaxRates("VAT:7.5, Levy:1")
+	assert.NoError(t, err)
+	if assert.Len(t, rates, 2) {
+		assert.Equal(t, "VAT (7.5%)", rates[0].Label())
+		assert.Equal(t, int64(750), rates[0].Apply(10000))
+		assert.Equal(t, int64(100), rates[1].Apply(10000))
+		assert.Equal(t, int64(1), rates[0].Apply(7), "rounds to nearest")
+	}
+
+	rates, err = ParseTaxRates("")
+	assert.NoError(t, err)
+	assert.Empty(t, rates)
+
+	for _, spec := range []string{"VAT", "VAT:abc", ":5", "VAT:-1", "VAT:101"} {
+		_, err := ParseTaxRates(spec)
+		assert.ErrorIs(t, err, ErrInvalidTaxRate, spec)
+	}
+}
+
+func TestBuildInvoice(t *testing.T) {
+	start := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	end := PeriodEnd(start)
+	taxes, _ := ParseTaxRates("VAT:7.5")
+
+	charges := []models.SubscriptionCharge{
+		{Description: "3 Team seats", Quantity: 3, Amount: 2400, Currency: "USD", PeriodStart: start, PeriodEnd: end},
+		{Description: "1 Team seats added", Quantity: 1, Amount: 400, Currency: "USD", PeriodStart: start.AddDate(0, 0, 15), PeriodEnd: end},
+		{Description: "1 Team seats removed", Quantity: -1, Amount: -200, Currency: "USD", PeriodStart: start.AddDate(0, 0, 22), PeriodEnd: end},
+	}
+
+	invoice := BuildInvoice(uuid.New(), "USD", charges, taxes, end)
+
+	assert.Equal(t, int64(2600), invoice.Subtotal)
+	assert.Equal(t, int64(195), invoice.Tax)
+	assert.Equal(t, int64(2795), invoice.Total)
+	assert.Equal(t, start, invoice.PeriodStart)
+	assert.Equal(t, end, invoice.PeriodEnd)
+
+	if assert.Len(t, invoice.Lines, 4) {
+		assert.Equal(t, models.InvoiceLineItem, invoice.Lines[2].Kind)
+		assert.Equal(t, models.InvoiceLineTax, invoice.Lines[3].Kind)
+		assert.Equal(t, 3, invoice.Lines[3].Position)
+	}
+}
+
+func TestRenderInvoice(t *testing.T) {
+	start := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	// Enough lines to run onto a second page
+	var charges []models.SubscriptionCharge
+	for i := 0; i < 60; i++ {
+		charges = append(charges, models.SubscriptionCharge{Description: "1 Team seats added", Quantity: 1, Amount: 800, Currency: "USD", PeriodStart: start, PeriodEnd: PeriodEnd(start)})
+	}
+
+	invoice := BuildInvoice(uuid.New(), "USD", charges, nil, start)
+	invoice.Number = 42
+
+	out := string(RenderInvoice(invoice, models.Organisation{Name: "Acme (Nigeria)"}))
+
+	assert.True(t, strings.HasPrefix(out, "%PDF-"))
+	assert.Contains(t, out, "(Invoice INV-00042) Tj")
+	assert.Contains(t, out, "(Invoice INV-00042 \\(continued\\)) Tj")
+	assert.Contains(t, out, "(Acme \\(Nigeria\\)) Tj")
+	assert.Contains(t, out, "(USD 480.00) Tj")
+	assert.Contains(t, out, "/Count 2")
+}
+
+func TestRenewalSkipsTrashedOrganisations(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	assert.NoError(t, err)
+	now := time.Now()
+
+	var due []models.Subscription
+	sql := dueForRenewal(db, now).Find(&due).Statement.SQL.String()
+
+	assert.Contains(t, sql, "JOIN organisations ON organisations.id = subscriptions.organisation_id AND organisations.deleted_at IS NULL")
+	assert.Contains(t, sql, "subscriptions.suspended_at IS NULL")
+}
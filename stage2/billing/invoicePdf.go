@@ -0,0 +1,124 @@
+package billing
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/payments"
+	"github.com/mryan-3/hng11/stage2/pdf"
+)
+
+// Layout of an invoice page, in points
+const (
+	marginLeft     = 50.0
+	marginRight    = pdf.PageWidth - 50
+	marginBottom   = 80.0
+	quantityRight  = 420.0
+	rowHeight      = 16.0
+	maxDescription = 60
+)
+
+const dateFormat = "2 Jan 2006"
+
+// Render an invoice to PDF
+func RenderInvoice(invoice models.Invoice, org models.Organisation) []byte {
+	doc := pdf.New()
+	number := InvoiceNumber(invoice)
+
+	page := doc.AddPage()
+	y := pdf.PageHeight - 60
+
+	page.Text(marginLeft, y, pdf.HelveticaBold, 18, invoiceIssuer())
+	y -= 34
+	page.Text(marginLeft, y, pdf.HelveticaBold, 13, "Invoice "+number)
+	y -= 18
+	page.Text(marginLeft, y, pdf.Helvetica, 10, "Issued "+invoice.IssuedAt.Format(dateFormat))
+	y -= 14
+	page.Text(marginLeft, y, pdf.Helvetica, 10,
+		"Period "+invoice.PeriodStart.Format(dateFormat)+" to "+invoice.PeriodEnd.Format(dateFormat))
+
+	y -= 30
+	page.Text(marginLeft, y, pdf.HelveticaBold, 10, "Billed to")
+	y -= 14
+	page.Text(marginLeft, y, pdf.Helvetica, 10, org.Name)
+	y -= 14
+	page.Text(marginLeft, y, pdf.Helvetica, 10, "Organisation "+org.ID.String())
+
+	header := func(y float64) float64 {
+		page.Text(marginLeft, y, pdf.HelveticaBold, 10, "Description")
+		page.TextRight(quantityRight, y, 10, "Qty")
+		page.TextRight(marginRight, y, 10, "Amount")
+		page.Line(marginLeft, y-6, marginRight, y-6, 0.5)
+		return y - rowHeight - 6
+	}
+
+	// Start a new page when the next row won't fit
+	room := func(y float64, rows int) float64 {
+		if y-float64(rows)*rowHeight >= marginBottom {
+			return y
+		}
+		page = doc.AddPage()
+		y = pdf.PageHeight - 60
+		page.Text(marginLeft, y, pdf.HelveticaBold, 13, "Invoice "+number+" (continued)")
+		return header(y - 34)
+	}
+
+	y = header(y - 40)
+
+	for _, line := range invoice.Lines {
+		if line.Kind != models.InvoiceLineItem {
+			continue
+		}
+		y = room(y, 1)
+		page.Text(marginLeft, y, pdf.Helvetica, 10, truncate(line.Description, maxDescription))
+		page.TextRight(quantityRight, y, 10, strconv.Itoa(line.Quantity))
+		page.TextRight(marginRight, y, 10, payments.DisplayMinor(line.Amount, invoice.Currency))
+		y -= rowHeight
+	}
+
+	// The totals are kept together
+	taxes := 0
+	for _, line := range invoice.Lines {
+		if line.Kind == models.InvoiceLineTax {
+			taxes++
+		}
+	}
+	y = room(y, taxes+3)
+
+	page.Line(marginLeft, y+rowHeight-6, marginRight, y+rowHeight-6, 0.5)
+	y -= 4
+	page.Text(marginLeft, y, pdf.Helvetica, 10, "Subtotal")
+	page.TextRight(marginRight, y, 10, payments.DisplayMinor(invoice.Subtotal, invoice.Currency))
+	y -= rowHeight
+
+	for _, line := range invoice.Lines {
+		if line.Kind != models.InvoiceLineTax {
+			continue
+		}
+		page.Text(marginLeft, y, pdf.Helvetica, 10, truncate(line.Description, maxDescription))
+		page.TextRight(marginRight, y, 10, payments.DisplayMinor(line.Amount, invoice.Currency))
+		y -= rowHeight
+	}
+
+	page.Text(marginLeft, y, pdf.HelveticaBold, 11, "Total")
+	page.TextRight(marginRight, y, 11, payments.DisplayMinor(invoice.Total, invoice.Currency))
+
+	return doc.Bytes()
+}
+
+// Who issues invoices, from INVOICE_ISSUER
+func invoiceIssuer() string {
+	if issuer := os.Getenv("INVOICE_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "HNG11"
+}
+
+func truncate(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-3]) + "..."
+}
@@ -0,0 +1,182 @@
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/models"
+	"gorm.io/gorm"
+)
+
+// Issue invoices to every organisation with charges that haven't been
+// invoiced yet
+func IssueInvoices(db *gorm.DB, now time.Time) error {
+	var orgIds []uuid.UUID
+	err := db.Model(&models.SubscriptionCharge{}).
+		Where("invoice_id IS NULL AND period_start <= ?", now).
+		Where("organisation_id IN (?)", db.Model(&models.Organisation{}).Select("id")).
+		Distinct().
+		Pluck("organisation_id", &orgIds).Error
+	if err != nil {
+		return err
+	}
+
+	for _, orgId := range orgIds {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			_, err := IssueInvoice(tx, orgId, now)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("invoicing organisation %s: %w", orgId, err)
+		}
+	}
+
+	return nil
+}
+
+// Invoice an organisation's uninvoiced charges, one invoice for each
+// currency they are in. Credits that outweigh the charges in a currency
+// are carried over to the next invoice rather than invoiced.
+func IssueInvoice(tx *gorm.DB, orgId uuid.UUID, now time.Time) ([]models.Invoice, error) {
+	// Holding the subscription numbers the organisation's invoices one
+	// at a time
+	if _, err := Lock(tx, orgId, now); err != nil {
+		return nil, err
+	}
+
+	var org models.Organisation
+	if err := tx.Where("id = ?", orgId).First(&org).Error; err != nil {
+		return nil, err
+	}
+
+	var charges []models.SubscriptionCharge
+	err := tx.Where("organisation_id = ? AND invoice_id IS NULL AND period_start <= ?", orgId, now).
+		Order("created_at, id").
+		Find(&charges).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byCurrency := map[string][]models.SubscriptionCharge{}
+	for _, charge := range charges {
+		byCurrency[charge.Currency] = append(byCurrency[charge.Currency], charge)
+	}
+
+	currencies := make([]string, 0, len(byCurrency))
+	for currency := range byCurrency {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	invoices := []models.Invoice{}
+	for _, currency := range currencies {
+		invoice, err := issue(tx, org, currency, byCurrency[currency], now)
+		if errors.Is(err, errNothingOwed) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, invoice)
+	}
+
+	return invoices, nil
+}
+
+var errNothingOwed = errors.New("nothing owed")
+
+func issue(tx *gorm.DB, org models.Organisation, currency string, charges []models.SubscriptionCharge, now time.Time) (models.Invoice, error) {
+	invoice := BuildInvoice(org.ID, currency, charges, TaxRates(), now)
+	if invoice.Subtotal <= 0 {
+		return models.Invoice{}, errNothingOwed
+	}
+
+	var last int
+	err := tx.Unscoped().Model(&models.Invoice{}).
+		Where("organisation_id = ?", org.ID).
+		Select("COALESCE(MAX(number), 0)").
+		Scan(&last).Error
+	if err != nil {
+		return models.Invoice{}, err
+	}
+	invoice.Number = last + 1
+	invoice.PDF = RenderInvoice(invoice, org)
+
+	if err := tx.Create(&invoice).Error; err != nil {
+		return models.Invoice{}, err
+	}
+
+	ids := make([]uint, len(charges))
+	for i, charge := range charges {
+		ids[i] = charge.ID
+	}
+	if err := tx.Model(&models.SubscriptionCharge{}).Where("id IN ?", ids).Update("invoice_id", invoice.InvoiceID).Error; err != nil {
+		return models.Invoice{}, err
+	}
+
+	err = audit.Record(tx, audit.Entry{Action: audit.InvoiceIssued}.
+		Org(org.ID).
+		Target("invoice", invoice.InvoiceID).
+		With(map[string]interface{}{
+			"number":   invoice.Number,
+			"total":    invoice.Total,
+			"currency": invoice.Currency,
+		}))
+	if err != nil {
+		return models.Invoice{}, err
+	}
+
+	return invoice, nil
+}
+
+// Work out an invoice for charges in one currency: a line for each
+// charge, then a line for each tax on their subtotal. The invoice isn't
+// numbered or saved.
+func BuildInvoice(orgId uuid.UUID, currency string, charges []models.SubscriptionCharge, taxes []TaxRate, now time.Time) models.Invoice {
+	invoice := models.Invoice{
+		OrganisationID: orgId,
+		Currency:       currency,
+		IssuedAt:       now,
+	}
+
+	for _, charge := range charges {
+		invoice.Lines = append(invoice.Lines, models.InvoiceLine{
+			Position:    len(invoice.Lines),
+			Kind:        models.InvoiceLineItem,
+			Description: charge.Description,
+			Quantity:    charge.Quantity,
+			Amount:      charge.Amount,
+		})
+		invoice.Subtotal += charge.Amount
+
+		if invoice.PeriodStart.IsZero() || charge.PeriodStart.Before(invoice.PeriodStart) {
+			invoice.PeriodStart = charge.PeriodStart
+		}
+		if charge.PeriodEnd.After(invoice.PeriodEnd) {
+			invoice.PeriodEnd = charge.PeriodEnd
+		}
+	}
+
+	for _, tax := range taxes {
+		amount := tax.Apply(invoice.Subtotal)
+		invoice.Lines = append(invoice.Lines, models.InvoiceLine{
+			Position:    len(invoice.Lines),
+			Kind:        models.InvoiceLineTax,
+			Description: tax.Label(),
+			Amount:      amount,
+		})
+		invoice.Tax += amount
+	}
+
+	invoice.Total = invoice.Subtotal + invoice.Tax
+	return invoice
+}
+
+// How an invoice number is shown, e.g. INV-00042
+func InvoiceNumber(invoice models.Invoice) string {
+	return fmt.Sprintf("INV-%05d", invoice.Number)
+}
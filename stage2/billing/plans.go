@@ -0,0 +1,68 @@
+// Package billing charges organisations per seat on a plan. Each member
+// of an organisation takes a seat, and a plan limits how many members an
+// organisation can have and how many organisations on it a user can own.
+package billing
+
+import (
+	"errors"
+	"time"
+)
+
+// Plan is a set of limits and a price per seat. Limits of zero are
+// unlimited.
+type Plan struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	SeatPrice int64  `json:"seatPrice"` // per seat per period, in minor units
+	Currency  string `json:"currency"`
+
+	// The most members an organisation on the plan can have
+	MaxMembers int `json:"maxMembers"`
+
+	// The most organisations on the plan one user can own
+	MaxOrgsPerUser int `json:"maxOrgsPerUser"`
+}
+
+// DefaultPlan is the plan organisations start on
+const DefaultPlan = "free"
+
+// How long a billing period lasts, in months
+const periodMonths = 1
+
+var ErrUnknownPlan = errors.New("unknown plan")
+
+var plans = []Plan{
+	{ID: "free", Name: "Free", SeatPrice: 0, Currency: "USD", MaxMembers: 10, MaxOrgsPerUser: 3},
+	{ID: "team", Name: "Team", SeatPrice: 800, Currency: "USD", MaxMembers: 100, MaxOrgsPerUser: 10},
+	{ID: "business", Name: "Business", SeatPrice: 1500, Currency: "USD", MaxMembers: 0, MaxOrgsPerUser: 0},
+}
+
+// Look up a plan by id
+func LookupPlan(id string) (Plan, error) {
+	for _, plan := range plans {
+		if plan.ID == id {
+			return plan, nil
+		}
+	}
+	return Plan{}, ErrUnknownPlan
+}
+
+// The plans, cheapest first
+func Plans() []Plan {
+	return append([]Plan(nil), plans...)
+}
+
+// Whether an organisation on the plan can have this many members
+func (p Plan) AllowsMembers(members int) bool {
+	return p.MaxMembers == 0 || members <= p.MaxMembers
+}
+
+// Whether a user can own this many organisations on the plan
+func (p Plan) AllowsOrgs(orgs int) bool {
+	return p.MaxOrgsPerUser == 0 || orgs <= p.MaxOrgsPerUser
+}
+
+// The end of a period that starts at start
+func PeriodEnd(start time.Time) time.Time {
+	return start.AddDate(0, periodMonths, 0)
+}
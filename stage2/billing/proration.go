@@ -0,0 +1,39 @@
+package billing
+
+import (
+	"math/big"
+	"time"
+)
+
+// The part of amount for what is left of a period at a time, rounded to
+// the nearest minor unit. A time before the period gives the whole
+// amount and one after it gives nothing.
+func Prorate(amount int64, start time.Time, end time.Time, at time.Time) int64 {
+	if !at.After(start) {
+		return amount
+	}
+	if !at.Before(end) || !end.After(start) {
+		return 0
+	}
+
+	left := new(big.Rat).SetFrac(big.NewInt(int64(end.Sub(at))), big.NewInt(int64(end.Sub(start))))
+	prorated := left.Mul(left, new(big.Rat).SetInt64(amount))
+
+	return round(prorated)
+}
+
+// Round half away from zero
+func round(value *big.Rat) int64 {
+	num := new(big.Int).Abs(value.Num())
+	denom := value.Denom()
+
+	quotient, remainder := new(big.Int).QuoRem(num, denom, new(big.Int))
+	if remainder.Mul(remainder, big.NewInt(2)).Cmp(denom) >= 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+
+	if value.Sign() < 0 {
+		quotient.Neg(quotient)
+	}
+	return quotient.Int64()
+}
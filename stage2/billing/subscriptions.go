@@ -0,0 +1,368 @@
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrSeatLimitReached = errors.New("the organisation's plan has no seats left")
+	ErrTooManyMembers   = errors.New("the organisation has more members than the plan allows")
+	ErrOrgLimitReached  = errors.New("the owner already owns as many organisations on the plan as it allows")
+)
+
+// Find an organisation's subscription and lock it for the rest of the
+// transaction, starting one on the default plan if it has none yet.
+// Changes to an organisation's members or plan are made holding this
+// lock so seats are counted one change at a time.
+func Lock(tx *gorm.DB, orgId uuid.UUID, now time.Time) (models.Subscription, error) {
+	started := models.Subscription{
+		OrganisationID:     orgId,
+		PlanID:             DefaultPlan,
+		CurrentPeriodStart: now,
+		CurrentPeriodEnd:   PeriodEnd(now),
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&started).Error; err != nil {
+		return models.Subscription{}, err
+	}
+
+	var subscription models.Subscription
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("organisation_id = ?", orgId).First(&subscription).Error
+	return subscription, err
+}
+
+// Count an organisation's members, who each take a seat
+func CountMembers(tx *gorm.DB, orgId uuid.UUID) (int, error) {
+	var members int64
+	err := tx.Model(&models.Membership{}).Where("organisation_id = ?", orgId).Count(&members).Error
+	return int(members), err
+}
+
+// Check a user can join an organisation without going over its plan's
+// member limit. A user who is already a member takes no new seat.
+func CheckSeat(tx *gorm.DB, subscription models.Subscription, userId uuid.UUID) error {
+	plan, err := LookupPlan(subscription.PlanID)
+	if err != nil {
+		return err
+	}
+
+	var existing int64
+	err = tx.Model(&models.Membership{}).
+		Where("organisation_id = ? AND user_user_id = ?", subscription.OrganisationID, userId).
+		Count(&existing).Error
+	if err != nil || existing > 0 {
+		return err
+	}
+
+	members, err := CountMembers(tx, subscription.OrganisationID)
+	if err != nil {
+		return err
+	}
+	if !plan.AllowsMembers(members + 1) {
+		return ErrSeatLimitReached
+	}
+
+	return nil
+}
+
+// Check a user can own one more organisation on a plan
+func CheckOrgLimit(tx *gorm.DB, ownerId uuid.UUID, plan Plan) error {
+	if plan.MaxOrgsPerUser == 0 {
+		return nil
+	}
+
+	// Organisations without a subscription are on the default plan
+	var owned int64
+	err := tx.Model(&models.Organisation{}).
+		Joins("LEFT JOIN subscriptions ON subscriptions.organisation_id = organisations.id AND subscriptions.deleted_at IS NULL").
+		Where("organisations.owner_id = ?", ownerId).
+		Where("COALESCE(subscriptions.plan_id, ?) = ?", DefaultPlan, plan.ID).
+		Count(&owned).Error
+	if err != nil {
+		return err
+	}
+
+	if !plan.AllowsOrgs(int(owned) + 1) {
+		return ErrOrgLimitReached
+	}
+	return nil
+}
+
+// Bring a subscription's seats in line with the organisation's members.
+// Seats added or removed partway through a period are charged or
+// credited for what is left of it. Returns the charge made, if any.
+func SyncSeats(tx *gorm.DB, subscription *models.Subscription, now time.Time) (*models.SubscriptionCharge, error) {
+	// Suspended subscriptions catch up when they are resumed
+	if subscription.SuspendedAt != nil {
+		return nil, nil
+	}
+
+	members, err := CountMembers(tx, subscription.OrganisationID)
+	if err != nil {
+		return nil, err
+	}
+
+	change := members - subscription.Seats
+	if change == 0 {
+		return nil, nil
+	}
+
+	plan, err := LookupPlan(subscription.PlanID)
+	if err != nil {
+		return nil, err
+	}
+
+	description := fmt.Sprintf("%d %s seats added", change, plan.Name)
+	if change < 0 {
+		description = fmt.Sprintf("%d %s seats removed", -change, plan.Name)
+	}
+	amount := Prorate(int64(change)*plan.SeatPrice, subscription.CurrentPeriodStart, subscription.CurrentPeriodEnd, now)
+
+	charge, err := addCharge(tx, *subscription, plan, description, change, amount, now)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription.Seats = members
+	if err := tx.Model(subscription).Update("seats", members).Error; err != nil {
+		return nil, err
+	}
+
+	return charge, nil
+}
+
+// Lock an organisation's subscription and sync its seats, for changes to
+// members made outside a request, e.g. when an account is purged
+func SyncOrgSeats(tx *gorm.DB, orgId uuid.UUID, now time.Time) error {
+	subscription, err := Lock(tx, orgId, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = SyncSeats(tx, &subscription, now)
+	return err
+}
+
+// Move a subscription to another plan. The organisation's members must
+// fit the plan and its owner must be allowed another organisation on it.
+// What is left of the period is credited at the old plan's price and
+// charged at the new one's. Returns the charges made.
+func ChangePlan(tx *gorm.DB, subscription *models.Subscription, plan Plan, ownerId *uuid.UUID, now time.Time) ([]models.SubscriptionCharge, error) {
+	charges := []models.SubscriptionCharge{}
+	if subscription.PlanID == plan.ID {
+		return charges, nil
+	}
+
+	synced, err := SyncSeats(tx, subscription, now)
+	if err != nil {
+		return nil, err
+	}
+	if synced != nil {
+		charges = append(charges, *synced)
+	}
+
+	if !plan.AllowsMembers(subscription.Seats) {
+		return nil, ErrTooManyMembers
+	}
+	if ownerId != nil {
+		if err := CheckOrgLimit(tx, *ownerId, plan); err != nil {
+			return nil, err
+		}
+	}
+
+	current, err := LookupPlan(subscription.PlanID)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := subscription.CurrentPeriodStart, subscription.CurrentPeriodEnd
+	seats := subscription.Seats
+
+	credit, err := addCharge(tx, *subscription, current,
+		fmt.Sprintf("Unused time on %d %s seats", seats, current.Name),
+		-seats, -Prorate(int64(seats)*current.SeatPrice, start, end, now), now)
+	if err != nil {
+		return nil, err
+	}
+	if credit != nil {
+		charges = append(charges, *credit)
+	}
+
+	charge, err := addCharge(tx, *subscription, plan,
+		fmt.Sprintf("Remaining time on %d %s seats", seats, plan.Name),
+		seats, Prorate(int64(seats)*plan.SeatPrice, start, end, now), now)
+	if err != nil {
+		return nil, err
+	}
+	if charge != nil {
+		charges = append(charges, *charge)
+	}
+
+	subscription.PlanID = plan.ID
+	if err := tx.Model(subscription).Update("plan_id", plan.ID).Error; err != nil {
+		return nil, err
+	}
+
+	return charges, nil
+}
+
+// Stop billing an organisation that is going to the trash. What is left
+// of the current period is credited.
+func Suspend(tx *gorm.DB, orgId uuid.UUID, now time.Time) error {
+	subscription, err := Lock(tx, orgId, now)
+	if err != nil {
+		return err
+	}
+	if subscription.SuspendedAt != nil {
+		return nil
+	}
+
+	plan, err := LookupPlan(subscription.PlanID)
+	if err != nil {
+		return err
+	}
+
+	seats := subscription.Seats
+	_, err = addCharge(tx, subscription, plan,
+		fmt.Sprintf("Unused time on %d %s seats", seats, plan.Name),
+		-seats, -Prorate(int64(seats)*plan.SeatPrice, subscription.CurrentPeriodStart, subscription.CurrentPeriodEnd, now), now)
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&subscription).Update("suspended_at", now).Error
+}
+
+// Start billing an organisation restored from the trash again. A new
+// period starts now and its seats are charged up front.
+func Resume(tx *gorm.DB, orgId uuid.UUID, now time.Time) error {
+	subscription, err := Lock(tx, orgId, now)
+	if err != nil {
+		return err
+	}
+	if subscription.SuspendedAt == nil {
+		return nil
+	}
+
+	plan, err := LookupPlan(subscription.PlanID)
+	if err != nil {
+		return err
+	}
+
+	subscription.SuspendedAt = nil
+	subscription.CurrentPeriodStart = now
+	subscription.CurrentPeriodEnd = PeriodEnd(now)
+	err = tx.Model(&subscription).Updates(map[string]interface{}{
+		"suspended_at":         nil,
+		"current_period_start": subscription.CurrentPeriodStart,
+		"current_period_end":   subscription.CurrentPeriodEnd,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	_, err = addCharge(tx, subscription, plan,
+		fmt.Sprintf("%d %s seats", subscription.Seats, plan.Name),
+		subscription.Seats, int64(subscription.Seats)*plan.SeatPrice, now)
+	if err != nil {
+		return err
+	}
+
+	// Members may have left while the organisation was in the trash
+	_, err = SyncSeats(tx, &subscription, now)
+	return err
+}
+
+// Start the next period of subscriptions whose period has ended, charging
+// for each period's seats up front. Organisations in the trash aren't
+// renewed.
+func Renew(db *gorm.DB, now time.Time) error {
+	var due []models.Subscription
+	if err := dueForRenewal(db, now).Find(&due).Error; err != nil {
+		return err
+	}
+
+	for _, subscription := range due {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			locked, err := Lock(tx, subscription.OrganisationID, now)
+			if err != nil {
+				return err
+			}
+
+			// The organisation went to the trash since it was found
+			if locked.SuspendedAt != nil {
+				return nil
+			}
+
+			plan, err := LookupPlan(locked.PlanID)
+			if err != nil {
+				return err
+			}
+
+			// A subscription that went unrenewed for a while is charged
+			// for every period it missed
+			for !locked.CurrentPeriodEnd.After(now) {
+				locked.CurrentPeriodStart = locked.CurrentPeriodEnd
+				locked.CurrentPeriodEnd = PeriodEnd(locked.CurrentPeriodStart)
+
+				_, err := addCharge(tx, locked, plan,
+					fmt.Sprintf("%d %s seats", locked.Seats, plan.Name),
+					locked.Seats, int64(locked.Seats)*plan.SeatPrice, locked.CurrentPeriodStart)
+				if err != nil {
+					return err
+				}
+			}
+
+			return tx.Model(&locked).Updates(map[string]interface{}{
+				"current_period_start": locked.CurrentPeriodStart,
+				"current_period_end":   locked.CurrentPeriodEnd,
+			}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("renewing subscription %s: %w", subscription.SubscriptionID, err)
+		}
+	}
+
+	return nil
+}
+
+// Subscriptions whose period has ended, leaving out suspended ones and
+// those of organisations in the trash
+func dueForRenewal(db *gorm.DB, now time.Time) *gorm.DB {
+	return db.Joins("JOIN organisations ON organisations.id = subscriptions.organisation_id AND organisations.deleted_at IS NULL").
+		Where("subscriptions.current_period_end <= ? AND subscriptions.suspended_at IS NULL", now)
+}
+
+// Record a charge for the part of the current period from a time on.
+// Nothing is recorded for an amount of zero.
+func addCharge(tx *gorm.DB, subscription models.Subscription, plan Plan, description string, quantity int, amount int64, from time.Time) (*models.SubscriptionCharge, error) {
+	if amount == 0 {
+		return nil, nil
+	}
+
+	if from.Before(subscription.CurrentPeriodStart) {
+		from = subscription.CurrentPeriodStart
+	}
+
+	charge := models.SubscriptionCharge{
+		SubscriptionID: subscription.SubscriptionID,
+		OrganisationID: subscription.OrganisationID,
+		Description:    description,
+		Quantity:       quantity,
+		Amount:         amount,
+		Currency:       plan.Currency,
+		PeriodStart:    from,
+		PeriodEnd:      subscription.CurrentPeriodEnd,
+	}
+	if err := tx.Create(&charge).Error; err != nil {
+		return nil, err
+	}
+
+	return &charge, nil
+}
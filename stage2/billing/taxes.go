@@ -0,0 +1,70 @@
+package billing
+
+import (
+	"errors"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// TaxRate is a tax charged on every invoice, e.g. VAT at 7.5%
+type TaxRate struct {
+	Name    string
+	Percent string
+	rate    *big.Rat
+}
+
+var taxRates []TaxRate
+
+var ErrInvalidTaxRate = errors.New("INVOICE_TAXES must be a comma separated list of name:percent, e.g. VAT:7.5")
+
+// Read the taxes charged on invoices from INVOICE_TAXES
+func Configure() error {
+	rates, err := ParseTaxRates(os.Getenv("INVOICE_TAXES"))
+	if err != nil {
+		return err
+	}
+
+	taxRates = rates
+	return nil
+}
+
+// Parse tax rates written as "VAT:7.5,Levy:1"
+func ParseTaxRates(spec string) ([]TaxRate, error) {
+	rates := []TaxRate{}
+	if strings.TrimSpace(spec) == "" {
+		return rates, nil
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		name, percent, ok := strings.Cut(part, ":")
+		name, percent = strings.TrimSpace(name), strings.TrimSpace(percent)
+		if !ok || name == "" {
+			return nil, ErrInvalidTaxRate
+		}
+
+		rate, ok := new(big.Rat).SetString(percent)
+		if !ok || rate.Sign() < 0 || rate.Cmp(big.NewRat(100, 1)) > 0 {
+			return nil, ErrInvalidTaxRate
+		}
+
+		rates = append(rates, TaxRate{Name: name, Percent: percent, rate: rate.Quo(rate, big.NewRat(100, 1))})
+	}
+
+	return rates, nil
+}
+
+// The configured tax rates
+func TaxRates() []TaxRate {
+	return taxRates
+}
+
+// The tax on an amount, rounded to the nearest minor unit
+func (t TaxRate) Apply(amount int64) int64 {
+	return round(new(big.Rat).Mul(new(big.Rat).SetInt64(amount), t.rate))
+}
+
+// How the tax is described on an invoice
+func (t TaxRate) Label() string {
+	return t.Name + " (" + t.Percent + "%)"
+}
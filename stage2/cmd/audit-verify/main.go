@@ -0,0 +1,57 @@
+// Command audit-verify walks the audit hash chains and reports the first
+// break in each. It exits with status 1 if any chain is broken.
+//
+//	go run ./cmd/audit-verify            # every chain
+//	go run ./cmd/audit-verify -chain <orgId>
+//	go run ./cmd/audit-verify -chain global
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/database"
+)
+
+func main() {
+	chain := flag.String("chain", "", "organisation id or \"global\" (default every chain)")
+	flag.Parse()
+
+	database.ConnectDb()
+
+	chains := []string{*chain}
+	if *chain == "" {
+		var err error
+		if chains, err = audit.Chains(database.DB.Db); err != nil {
+			log.Fatal("Failed to list audit chains: ", err)
+		}
+	}
+
+	broken := false
+	for _, name := range chains {
+		verification, err := audit.Verify(database.DB.Db, name)
+		if err != nil {
+			log.Fatalf("Failed to verify chain %s: %v", name, err)
+		}
+
+		if verification.Valid {
+			fmt.Printf("ok     %s  %d events, %d checkpoints, head %s\n",
+				name, verification.Checked, verification.Checkpoints, verification.HeadHash)
+			continue
+		}
+
+		broken = true
+		fmt.Printf("BROKEN %s  at sequence %d: %s\n", name, verification.Break.Sequence, verification.Break.Reason)
+	}
+
+	if !audit.SigningEnabled() {
+		fmt.Println("AUDIT_SIGNING_KEY is not set, checkpoint signatures were not checked")
+	}
+
+	if broken {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,31 @@
+// Command mail-sink runs a local SMTP server that accepts every message
+// and prints it instead of delivering it. Use it to try the SMTP mail
+// driver without a real mail server:
+//
+//	go run ./cmd/mail-sink -addr localhost:2525
+//	MAIL_DRIVER=smtp SMTP_HOST=localhost SMTP_PORT=2525 go run .
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/mryan-3/hng11/stage2/mailer"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:2525", "address to listen on")
+	flag.Parse()
+
+	sink, err := mailer.ListenSink(*addr, func(msg mailer.Message) {
+		fmt.Printf("From: %s\nTo: %s\nSubject: %s\n\n%s\n", msg.From, msg.To, msg.Subject, msg.Text)
+		fmt.Println("----")
+	})
+	if err != nil {
+		log.Fatal("Failed to start the mail sink: ", err)
+	}
+
+	log.Printf("Mail sink listening on %s", sink.Addr())
+	select {}
+}
@@ -0,0 +1,64 @@
+// Command payment-webhook-replay reprocesses stored payment webhook
+// events, e.g. after a fix for events that failed. Replaying is safe:
+// events that repeat a payment's status change nothing.
+//
+//	go run ./cmd/payment-webhook-replay -status failed
+//	go run ./cmd/payment-webhook-replay -provider fake -event <eventId>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/payments"
+)
+
+func main() {
+	provider := flag.String("provider", "", "only events from this provider")
+	event := flag.String("event", "", "only the event with this id")
+	status := flag.String("status", "", "only events with this status (received, processed, ignored or failed)")
+	flag.Parse()
+
+	if *event == "" && *status == "" {
+		fmt.Fprintln(os.Stderr, "payment-webhook-replay: give -event or -status")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	database.ConnectDb()
+
+	query := database.DB.Db.Order("id")
+	if *provider != "" {
+		query = query.Where("provider = ?", *provider)
+	}
+	if *event != "" {
+		query = query.Where("event_id = ?", *event)
+	}
+	if *status != "" {
+		query = query.Where("status = ?", *status)
+	}
+
+	var events []models.WebhookEvent
+	if err := query.Find(&events).Error; err != nil {
+		log.Fatal("Failed to list webhook events: ", err)
+	}
+
+	for _, stored := range events {
+		replayed, err := payments.ProcessEvent(database.DB.Db, stored)
+		if err != nil {
+			log.Fatalf("Failed to replay event %s: %v", stored.EventID, err)
+		}
+
+		fmt.Printf("%-9s %s/%s  %s", replayed.Status, replayed.Provider, replayed.EventID, replayed.Type)
+		if replayed.Error != "" {
+			fmt.Printf("  (%s)", replayed.Error)
+		}
+		fmt.Println()
+	}
+
+	fmt.Printf("%d events replayed\n", len(events))
+}
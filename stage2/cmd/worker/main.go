@@ -0,0 +1,49 @@
+// Command worker runs outbox jobs, such as sending emails and webhooks,
+// apart from the server. Run the server with OUTBOX_WORKERS=0 to leave
+// the jobs to it. Any number of workers can run at once.
+//
+//	go run ./cmd/worker
+//	go run ./cmd/worker -workers 16
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/mailer"
+	"github.com/mryan-3/hng11/stage2/outbox"
+
+	// Registers its outbox jobs
+	_ "github.com/mryan-3/hng11/stage2/webhooks"
+)
+
+func main() {
+	workers := flag.Int("workers", outbox.DefaultWorkers, "how many jobs to run at once")
+	flag.Parse()
+
+	if *workers < 1 {
+		fmt.Fprintln(os.Stderr, "worker: -workers must be at least 1")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	database.ConnectDb()
+
+	if err := mailer.Configure(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Finish the running jobs on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Running outbox jobs with %d workers\n", *workers)
+	outbox.NewPool(database.DB.Db, *workers).Run(ctx)
+	fmt.Println("Stopped")
+}
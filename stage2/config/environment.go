@@ -0,0 +1,62 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+type Environment string
+
+const (
+	Development Environment = "dev"
+	Test        Environment = "test"
+	Staging     Environment = "staging"
+	Production  Environment = "prod"
+)
+
+// Get the environment from APP_ENV. Anything but a known environment,
+// including not setting it, is production, so a deployment that forgets
+// APP_ENV doesn't turn on what's only meant for development.
+func Env() Environment {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("APP_ENV"))) {
+	case "dev", "development":
+		return Development
+	case "staging", "stage":
+		return Staging
+	case "test", "testing":
+		return Test
+	default:
+		return Production
+	}
+}
+
+// Deployed environments are served over https to real users
+func (e Environment) IsDeployed() bool {
+	return e == Production || e == Staging
+}
+
+// Attributes for the auth cookie in an environment
+type CookieProfile struct {
+	HTTPOnly bool
+	Secure   bool
+	SameSite string
+	Domain   string
+}
+
+// The auth cookie is never readable from javascript. Outside deployed
+// environments it is allowed over plain http for localhost development.
+func AuthCookie() CookieProfile {
+	profile := CookieProfile{
+		HTTPOnly: true,
+		Secure:   false,
+		SameSite: "Lax",
+		Domain:   os.Getenv("COOKIE_DOMAIN"),
+	}
+
+	if Env().IsDeployed() {
+		profile.Secure = true
+		profile.SameSite = "Strict"
+	}
+
+	return profile
+}
@@ -0,0 +1,23 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnv(t *testing.T) {
+	testCases := map[string]Environment{
+		"dev":        Development,
+		"Production": Production,
+		"stage":      Staging,
+		"test":       Test,
+		"":           Production,
+		"prdo":       Production,
+	}
+
+	for value, env := range testCases {
+		t.Setenv("APP_ENV", value)
+		assert.Equal(t, env, Env(), value)
+	}
+}
@@ -0,0 +1,303 @@
+package controller
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/validation"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Days an account waits after a deletion request before it is purged
+const defaultDeletionGraceDays = 30
+
+// Download everything stored about the logged in user.
+// ?format=zip returns the same document inside a zip archive.
+// route GET /api/me/export
+func ExportAccount(c *fiber.Ctx) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "User not found",
+		})
+	}
+
+	export, err := accountExport(user)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while exporting your data",
+		})
+	}
+
+	if c.Query("format") != "zip" {
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="export.json"`)
+		return c.Status(http.StatusOK).JSON(export)
+	}
+
+	document, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while exporting your data",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="export.zip"`)
+
+	archive := zip.NewWriter(c.Response().BodyWriter())
+	file, err := archive.Create("export.json")
+	if err == nil {
+		_, err = file.Write(document)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while exporting your data",
+		})
+	}
+
+	return c.SendStatus(http.StatusOK)
+}
+
+// Schedule the logged in user's account for deletion. Logging in
+// again before the grace period ends cancels the request.
+// route DELETE /api/me
+func DeleteAccount(c *fiber.Ctx) error {
+	type ReqBody struct {
+		Password     string `json:"password" validate:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recoveryCode"`
+	}
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	user, err := currentUser(c)
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "User not found",
+		})
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password)) != nil ||
+		(user.TwoFactorEnabled && !verifySecondFactor(database.DB.Db, user, body.Code, body.RecoveryCode)) {
+		return c.Status(http.StatusUnauthorized).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusUnauthorized,
+			"message":    "Authentication failed",
+		})
+	}
+
+	// Organisations with other members need a new owner first
+	var owned []models.Organisation
+	err = database.DB.Db.
+		Where("owner_id = ?", user.UserID).
+		Where("EXISTS (SELECT 1 FROM user_organizations m WHERE m.organisation_id = organisations.id AND m.user_user_id <> ?)", user.UserID).
+		Find(&owned).Error
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while deleting your account",
+		})
+	}
+
+	if len(owned) > 0 {
+		orgs := []fiber.Map{}
+		for _, org := range owned {
+			orgs = append(orgs, fiber.Map{"orgId": org.ID, "name": org.Name})
+		}
+
+		return c.Status(http.StatusConflict).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusConflict,
+			"message":    "Transfer ownership of these organisations before deleting your account",
+			"data": fiber.Map{
+				"organisations": orgs,
+			},
+		})
+	}
+
+	now := time.Now()
+	scheduledAt := now.AddDate(0, 0, deletionGraceDays())
+
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("deletion_scheduled_at", scheduledAt).Error; err != nil {
+			return err
+		}
+
+		return audit.Record(tx, audit.FromRequest(c, audit.UserDeletionRequested).
+			Target("user", user.UserID).
+			With(map[string]interface{}{"deletionScheduledAt": scheduledAt}))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while deleting your account",
+		})
+	}
+
+	// Sign the account out everywhere
+	database.DB.Db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", user.UserID).
+		Update("revoked_at", now)
+	clearUserCookie(c)
+
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Account scheduled for deletion. Log in before the deletion date to cancel.",
+		"data": fiber.Map{
+			"deletionScheduledAt": scheduledAt,
+		},
+	})
+}
+
+// Collect the user's personal data for an export
+func accountExport(user models.User) (fiber.Map, error) {
+	type membershipRow struct {
+		OrganisationID string
+		Name           string
+		Role           string
+		CreatedAt      time.Time
+	}
+
+	var rows []membershipRow
+	err := database.DB.Db.Table("user_organizations").
+		Select("user_organizations.organisation_id, organisations.name, user_organizations.role, user_organizations.created_at").
+		Joins("JOIN organisations ON organisations.id = user_organizations.organisation_id AND organisations.deleted_at IS NULL").
+		Where("user_organizations.user_user_id = ?", user.UserID).
+		Order("user_organizations.created_at").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	memberships := []fiber.Map{}
+	for _, row := range rows {
+		memberships = append(memberships, fiber.Map{
+			"orgId":    row.OrganisationID,
+			"name":     row.Name,
+			"role":     row.Role,
+			"joinedAt": row.CreatedAt,
+		})
+	}
+
+	var sessions []models.Session
+	if err := database.DB.Db.Where("user_id = ?", user.UserID).Order("created_at").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	sessionList := []fiber.Map{}
+	for _, session := range sessions {
+		sessionList = append(sessionList, fiber.Map{
+			"sessionId":  session.SessionID,
+			"userAgent":  session.UserAgent,
+			"ip":         session.IP,
+			"createdAt":  session.CreatedAt,
+			"lastSeenAt": session.LastSeenAt,
+			"expiresAt":  session.ExpiresAt,
+			"revokedAt":  session.RevokedAt,
+		})
+	}
+
+	var apiKeys []models.ApiKey
+	if err := database.DB.Db.Where("user_id = ?", user.UserID).Order("created_at").Find(&apiKeys).Error; err != nil {
+		return nil, err
+	}
+
+	keys := []fiber.Map{}
+	for _, apiKey := range apiKeys {
+		keys = append(keys, apiKeyResponse(apiKey))
+	}
+
+	var emailChanges []models.EmailChange
+	if err := database.DB.Db.Where("user_id = ?", user.UserID).Order("created_at").Find(&emailChanges).Error; err != nil {
+		return nil, err
+	}
+
+	changes := []fiber.Map{}
+	for _, change := range emailChanges {
+		changes = append(changes, fiber.Map{
+			"newEmail":    change.NewEmail,
+			"requestedAt": change.CreatedAt,
+			"confirmedAt": change.ConfirmedAt,
+		})
+	}
+
+	var auditEvents []models.AuditEvent
+	if err := database.DB.Db.Where("actor_id = ?", user.UserID).Order("created_at").Find(&auditEvents).Error; err != nil {
+		return nil, err
+	}
+
+	events := []fiber.Map{}
+	for _, event := range auditEvents {
+		events = append(events, auditEventResponse(event))
+	}
+
+	var paymentRows []models.Payment
+	if err := database.DB.Db.Where("user_id = ?", user.UserID).Order("created_at").Find(&paymentRows).Error; err != nil {
+		return nil, err
+	}
+
+	paymentList := []fiber.Map{}
+	for _, payment := range paymentRows {
+		item := paymentResponse(payment, user)
+		delete(item, "customer")
+		paymentList = append(paymentList, item)
+	}
+
+	profile := userResponse(user)
+	profile["twoFactorEnabled"] = user.TwoFactorEnabled
+	profile["createdAt"] = user.CreatedAt
+
+	return fiber.Map{
+		"exportedAt":   time.Now(),
+		"profile":      profile,
+		"memberships":  memberships,
+		"sessions":     sessionList,
+		"apiKeys":      keys,
+		"emailChanges": changes,
+		"auditEvents":  events,
+		"payments":     paymentList,
+	}, nil
+}
+
+func deletionGraceDays() int {
+	days, err := strconv.Atoi(os.Getenv("ACCOUNT_DELETION_GRACE_DAYS"))
+	if err != nil || days < 0 {
+		return defaultDeletionGraceDays
+	}
+	return days
+}
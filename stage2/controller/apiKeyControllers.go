@@ -0,0 +1,340 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/pagination"
+	"github.com/mryan-3/hng11/stage2/policy"
+	"github.com/mryan-3/hng11/stage2/utils"
+	"github.com/mryan-3/hng11/stage2/validation"
+	"gorm.io/gorm"
+)
+
+// Create an API key. Organisation keys can only be created by admins.
+// route POST /api/api-keys
+func CreateApiKey(c *fiber.Ctx) error {
+	type ReqBody struct {
+		Name      string     `json:"name" validate:"required,max=255"`
+		Scopes    []string   `json:"scopes" validate:"required,min=1,dive,oneof=organisations:read organisations:write users:read"`
+		OrgID     *uuid.UUID `json:"orgId"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+
+	userId := c.Locals("userId").(string)
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	if body.ExpiresAt != nil && body.ExpiresAt.Before(time.Now()) {
+		return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+			"errors": []validation.ValidationError{{Field: "ExpiresAt", Message: "ExpiresAt must be in the future"}},
+		})
+	}
+
+	if body.OrgID != nil && !hasOrgPermission(body.OrgID.String(), userId, policy.ApiKeyManage) {
+		return orgForbidden(c, body.OrgID.String(), "You need the "+policy.ApiKeyManage+" permission to create organisation API keys")
+	}
+
+	key, prefix, err := utils.GenerateApiKey()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while generating the API key",
+		})
+	}
+
+	apiKey := models.ApiKey{
+		Name:           body.Name,
+		Prefix:         prefix,
+		KeyHash:        utils.HashApiKey(key),
+		Scopes:         strings.Join(body.Scopes, " "),
+		UserID:         uuid.MustParse(userId),
+		OrganisationID: body.OrgID,
+		ExpiresAt:      body.ExpiresAt,
+	}
+
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&apiKey).Error; err != nil {
+			return err
+		}
+
+		return audit.Record(tx, apiKeyAuditEntry(c, apiKey, audit.ApiKeyCreated))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while creating the API key",
+		})
+	}
+
+	data := apiKeyResponse(apiKey)
+	// The full key is only ever shown here
+	data["key"] = key
+
+	response := fiber.Map{
+		"status":  "success",
+		"message": "API key created successfully",
+		"data":    data,
+	}
+
+	return c.Status(http.StatusCreated).JSON(response)
+}
+
+// List the logged in user's API keys, or an organisation's keys
+// for admins when ?orgId= is given
+// route GET /api/api-keys
+func GetApiKeys(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	orgId := c.Query("orgId")
+
+	page, err := pagination.Parse(c, apiKeyPagination)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    err.Error(),
+		})
+	}
+
+	query := database.DB.Db.Scopes(page.Scope)
+	if orgId != "" {
+		if !hasOrgPermission(orgId, userId, policy.ApiKeyManage) {
+			return orgForbidden(c, orgId, "You need the "+policy.ApiKeyManage+" permission to list organisation API keys")
+		}
+		query = query.Where("organisation_id = ?", orgId)
+	} else {
+		query = query.Where("user_id = ?", userId)
+	}
+
+	var apiKeys []models.ApiKey
+	if err := query.Find(&apiKeys).Error; err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while fetching API keys",
+		})
+	}
+
+	apiKeys, meta := page.Trim(apiKeys)
+
+	keys := []fiber.Map{}
+	for _, apiKey := range apiKeys {
+		keys = append(keys, apiKeyResponse(apiKey))
+	}
+
+	response := fiber.Map{
+		"status":  "success",
+		"message": "API keys found",
+		"data": fiber.Map{
+			"apiKeys": keys,
+		},
+		"meta": meta,
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// Get a single API key
+// route GET /api/api-keys/:keyId
+func GetApiKey(c *fiber.Ctx) error {
+	apiKey, ok := findManageableApiKey(c)
+	if !ok {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "API key not found",
+		})
+	}
+
+	response := fiber.Map{
+		"status":  "success",
+		"message": "API key found",
+		"data":    apiKeyResponse(apiKey),
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// Rename an API key or change its scopes and expiry
+// route PUT /api/api-keys/:keyId
+func UpdateApiKey(c *fiber.Ctx) error {
+	type ReqBody struct {
+		Name      *string    `json:"name" validate:"omitempty,min=1,max=255"`
+		Scopes    []string   `json:"scopes" validate:"omitempty,min=1,dive,oneof=organisations:read organisations:write users:read"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	if body.ExpiresAt != nil && body.ExpiresAt.Before(time.Now()) {
+		return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+			"errors": []validation.ValidationError{{Field: "ExpiresAt", Message: "ExpiresAt must be in the future"}},
+		})
+	}
+
+	apiKey, ok := findManageableApiKey(c)
+	if !ok {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "API key not found",
+		})
+	}
+
+	updates := map[string]interface{}{}
+	if body.Name != nil {
+		updates["name"] = *body.Name
+	}
+	if len(body.Scopes) > 0 {
+		updates["scopes"] = strings.Join(body.Scopes, " ")
+	}
+	if body.ExpiresAt != nil {
+		updates["expires_at"] = body.ExpiresAt
+	}
+
+	if len(updates) > 0 {
+		err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&apiKey).Updates(updates).Error; err != nil {
+				return err
+			}
+
+			// Respond with the key as it is now
+			if err := tx.First(&apiKey, "key_id = ?", apiKey.KeyID).Error; err != nil {
+				return err
+			}
+
+			return audit.Record(tx, apiKeyAuditEntry(c, apiKey, audit.ApiKeyUpdated))
+		})
+
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+				"status":  "error",
+				"message": "An error occurred while updating the API key",
+			})
+		}
+	}
+
+	response := fiber.Map{
+		"status":  "success",
+		"message": "API key updated successfully",
+		"data":    apiKeyResponse(apiKey),
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// Revoke an API key
+// route DELETE /api/api-keys/:keyId
+func DeleteApiKey(c *fiber.Ctx) error {
+	apiKey, ok := findManageableApiKey(c)
+	if !ok {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "API key not found",
+		})
+	}
+
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&apiKey).Error; err != nil {
+			return err
+		}
+
+		return audit.Record(tx, apiKeyAuditEntry(c, apiKey, audit.ApiKeyRevoked))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while revoking the API key",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "API key revoked successfully",
+	})
+}
+
+// Find the API key in the :keyId param if the logged in user created it
+// or manages its organisation's keys
+func findManageableApiKey(c *fiber.Ctx) (models.ApiKey, bool) {
+	userId := c.Locals("userId").(string)
+
+	var apiKey models.ApiKey
+	if err := database.DB.Db.Where("key_id = ?", c.Params("keyId")).First(&apiKey).Error; err != nil {
+		return apiKey, false
+	}
+
+	if apiKey.UserID.String() == userId {
+		return apiKey, true
+	}
+
+	if apiKey.OrganisationID != nil && hasOrgPermission(apiKey.OrganisationID.String(), userId, policy.ApiKeyManage) {
+		return apiKey, true
+	}
+
+	return apiKey, false
+}
+
+func apiKeyResponse(apiKey models.ApiKey) fiber.Map {
+	return fiber.Map{
+		"keyId":      apiKey.KeyID,
+		"name":       apiKey.Name,
+		"prefix":     apiKey.Prefix,
+		"scopes":     apiKey.ScopeList(),
+		"userId":     apiKey.UserID,
+		"orgId":      apiKey.OrganisationID,
+		"expiresAt":  apiKey.ExpiresAt,
+		"lastUsedAt": apiKey.LastUsedAt,
+		"createdAt":  apiKey.CreatedAt,
+	}
+}
+
+func apiKeyAuditEntry(c *fiber.Ctx, apiKey models.ApiKey, action string) audit.Entry {
+	entry := audit.FromRequest(c, action).
+		Target("api_key", apiKey.KeyID).
+		With(map[string]interface{}{"prefix": apiKey.Prefix, "scopes": apiKey.ScopeList()})
+
+	if apiKey.OrganisationID != nil {
+		entry = entry.Org(*apiKey.OrganisationID)
+	}
+
+	return entry
+}
@@ -0,0 +1,238 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/pagination"
+	"gorm.io/gorm"
+)
+
+// Rows read at a time when exporting audit events
+const auditExportBatchSize = 500
+
+// List an organisation's audit events, newest first.
+// Filters: action (a trailing .* matches a prefix, e.g. organisation.member.*),
+// actor_id, target_type, target_id and created_before.
+// route GET /api/organisations/:orgId/audit-events
+func GetAuditEvents(c *fiber.Ctx) error {
+	filters, err := auditEventFilters(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    err.Error(),
+		})
+	}
+
+	page, err := pagination.Parse(c, auditEventPagination)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    err.Error(),
+		})
+	}
+
+	var events []models.AuditEvent
+	if err := database.DB.Db.Scopes(filters, page.Scope).Preload("Detail").Find(&events).Error; err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while fetching audit events",
+		})
+	}
+
+	events, meta := page.Trim(events)
+
+	data := []fiber.Map{}
+	for _, event := range events {
+		data = append(data, auditEventResponse(event))
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Audit events found",
+		"data": fiber.Map{
+			"events": data,
+		},
+		"meta": meta,
+	})
+}
+
+// Download an organisation's audit events as JSON Lines, oldest first.
+// Takes the same filters as the list endpoint plus created_after.
+// route GET /api/organisations/:orgId/audit-events/export
+func ExportAuditEvents(c *fiber.Ctx) error {
+	filters, err := auditEventFilters(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    err.Error(),
+		})
+	}
+
+	query := database.DB.Db.Model(&models.AuditEvent{}).Scopes(filters).Preload("Detail")
+	if after := c.Query("created_after"); after != "" {
+		createdAfter, err := time.Parse(time.RFC3339, after)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+				"status":     "Bad request",
+				"statusCode": http.StatusBadRequest,
+				"message":    "created_after must be an RFC 3339 time",
+			})
+		}
+		query = query.Where("audit_events.created_at > ?", createdAfter)
+	}
+
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="audit-events.jsonl"`)
+
+	// Stream the rows in batches so large logs aren't held in memory
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		var batch []models.AuditEvent
+		encoder := json.NewEncoder(w)
+
+		err := query.FindInBatches(&batch, auditExportBatchSize, func(tx *gorm.DB, _ int) error {
+			for _, event := range batch {
+				if err := encoder.Encode(auditEventResponse(event)); err != nil {
+					return err
+				}
+			}
+			return w.Flush()
+		}).Error
+
+		if err != nil {
+			log.Printf("Audit event export failed: %v", err)
+		}
+	})
+
+	return nil
+}
+
+// Check the organisation's audit chain hasn't been tampered with.
+// Reports the first break found, if any.
+// route GET /api/organisations/:orgId/audit-events/verify
+func VerifyAuditEvents(c *fiber.Ctx) error {
+	verification, err := audit.Verify(database.DB.Db, c.Params("orgId"))
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while verifying audit events",
+		})
+	}
+
+	data := fiber.Map{"verification": verification}
+	if key, err := audit.PublicKey(); err == nil {
+		data["checkpointKey"] = base64.StdEncoding.EncodeToString(key)
+	}
+
+	message := "Audit chain verified"
+	if !verification.Valid {
+		message = "Audit chain is broken"
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": message,
+		"data":    data,
+	})
+}
+
+// Limit a query to the :orgId organisation's events matching the
+// request's filters
+func auditEventFilters(c *fiber.Ctx) (func(*gorm.DB) *gorm.DB, error) {
+	orgId := c.Params("orgId")
+	action := c.Query("action")
+	actorId := c.Query("actor_id")
+	targetType := c.Query("target_type")
+	targetId := c.Query("target_id")
+
+	if actorId != "" {
+		if _, err := uuid.Parse(actorId); err != nil {
+			return nil, errors.New("actor_id must be a user id")
+		}
+	}
+
+	var createdBefore time.Time
+	if before := c.Query("created_before"); before != "" {
+		var err error
+		if createdBefore, err = time.Parse(time.RFC3339, before); err != nil {
+			return nil, errors.New("created_before must be an RFC 3339 time")
+		}
+	}
+
+	return func(db *gorm.DB) *gorm.DB {
+		db = db.Where("audit_events.organisation_id = ?", orgId)
+
+		if prefix, ok := strings.CutSuffix(action, ".*"); ok {
+			db = db.Where("audit_events.action LIKE ?", pagination.HasPrefix(prefix+"."))
+		} else if action != "" {
+			db = db.Where("audit_events.action = ?", action)
+		}
+		if actorId != "" {
+			db = db.Where("audit_events.actor_id = ?", actorId)
+		}
+		if targetType != "" {
+			db = db.Where("audit_events.target_type = ?", targetType)
+		}
+		if targetId != "" {
+			db = db.Where("audit_events.target_id = ?", targetId)
+		}
+		if !createdBefore.IsZero() {
+			db = db.Where("audit_events.created_at < ?", createdBefore)
+		}
+
+		return db
+	}, nil
+}
+
+func auditEventResponse(event models.AuditEvent) fiber.Map {
+	// Personal data is shown with the event until it is erased
+	var ip, userAgent string
+	metadata := json.RawMessage(event.Metadata)
+	if detail := event.Detail; detail != nil {
+		ip, userAgent = detail.IP, detail.UserAgent
+		metadata = mergeJsonObjects(event.Metadata, detail.Personal)
+	}
+
+	return fiber.Map{
+		"eventId":    event.EventID,
+		"orgId":      event.OrganisationID,
+		"actorId":    event.ActorID,
+		"action":     event.Action,
+		"targetType": event.TargetType,
+		"targetId":   event.TargetID,
+		"ip":         ip,
+		"userAgent":  userAgent,
+		"metadata":   metadata,
+		"createdAt":  event.CreatedAt,
+		"sequence":   event.Sequence,
+		"prevHash":   event.PrevHash,
+		"hash":       event.Hash,
+	}
+}
+
+// Merge two JSON objects, the second's keys winning
+func mergeJsonObjects(first string, second string) json.RawMessage {
+	merged := map[string]interface{}{}
+	json.Unmarshal([]byte(first), &merged)
+	json.Unmarshal([]byte(second), &merged)
+
+	out, err := json.Marshal(merged)
+	if err != nil {
+		return json.RawMessage(first)
+	}
+	return out
+}
@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/mailer"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/validation"
+	"gorm.io/gorm"
+)
+
+// Queue an email, either written out in full or rendered from a template.
+// Only platform admins can send arbitrary email.
+// route POST /api/emails/send
+func SendEmail(c *fiber.Ctx) error {
+	type ReqBody struct {
+		To         string                 `json:"to" validate:"required,email"`
+		From       string                 `json:"from" validate:"omitempty,email"`
+		Subject    string                 `json:"subject" validate:"required_without=TemplateID,max=255"`
+		Body       string                 `json:"body" validate:"required_without=TemplateID"`
+		HTML       string                 `json:"html"`
+		TemplateID string                 `json:"templateId" validate:"omitempty,max=100"`
+		Data       map[string]interface{} `json:"data"`
+	}
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	msg := mailer.Message{Subject: body.Subject, Text: body.Body, HTML: body.HTML}
+
+	if body.TemplateID != "" {
+		tmpl, err := mailer.LookupTemplate(body.TemplateID)
+		if err == nil {
+			msg, err = tmpl.Render(body.Data)
+		}
+
+		if err != nil {
+			message := "The template could not be rendered with this data: " + err.Error()
+			if errors.Is(err, mailer.ErrUnknownTemplate) {
+				message = "Template not found"
+			}
+
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"errors": []validation.ValidationError{{Field: "TemplateID", Message: message}},
+			})
+		}
+	}
+
+	msg.To = body.To
+	msg.From = body.From
+
+	var email models.OutboxEmail
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		email, err = mailer.Enqueue(tx, msg, body.TemplateID)
+		return err
+	})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while queueing the email",
+		})
+	}
+
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Email queued for delivery",
+		"data":    outboxEmailResponse(email),
+	})
+}
+
+// List the emails captured by the in-memory mailer, newest first
+// route GET /api/dev/emails
+func GetCapturedEmails(c *fiber.Ctx) error {
+	capture, ok := mailer.Captured()
+	if !ok {
+		return captureDisabled(c)
+	}
+
+	messages := capture.Messages()
+	data := make([]mailer.Message, 0, len(messages))
+	for i := len(messages) - 1; i >= 0; i-- {
+		if to := c.Query("to"); to != "" && messages[i].To != to {
+			continue
+		}
+		data = append(data, messages[i])
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Captured emails found",
+		"data": fiber.Map{
+			"emails": data,
+		},
+	})
+}
+
+// Get one captured email
+// route GET /api/dev/emails/:emailId
+func GetCapturedEmail(c *fiber.Ctx) error {
+	capture, ok := mailer.Captured()
+	if !ok {
+		return captureDisabled(c)
+	}
+
+	msg, found := capture.Find(c.Params("emailId"))
+	if !found {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Email not found",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Email found",
+		"data":    msg,
+	})
+}
+
+// Forget every captured email
+// route DELETE /api/dev/emails
+func ClearCapturedEmails(c *fiber.Ctx) error {
+	capture, ok := mailer.Captured()
+	if !ok {
+		return captureDisabled(c)
+	}
+
+	capture.Clear()
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Captured emails cleared",
+	})
+}
+
+func captureDisabled(c *fiber.Ctx) error {
+	return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+		"status":     "error",
+		"statusCode": http.StatusNotFound,
+		"message":    "Email capture is off. Set MAIL_DRIVER=memory to capture emails.",
+	})
+}
+
+func outboxEmailResponse(email models.OutboxEmail) fiber.Map {
+	return fiber.Map{
+		"emailId":    email.EmailID,
+		"templateId": email.TemplateID,
+		"to":         email.Recipient,
+		"from":       email.Sender,
+		"subject":    email.Subject,
+		"status":     email.Status,
+		"createdAt":  email.CreatedAt,
+	}
+}
@@ -0,0 +1,497 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/mailer"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/validation"
+	"gorm.io/gorm"
+)
+
+// Where an organisation's version of a template comes from
+const (
+	templateSourceSystem   = "system"
+	templateSourceOverride = "override"
+	templateSourceCustom   = "custom"
+)
+
+var templateIdPattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// List the system email templates and the variables each declares
+// route GET /api/email-templates
+func GetSystemEmailTemplates(c *fiber.Ctx) error {
+	data := []fiber.Map{}
+	for _, tmpl := range mailer.SystemTemplates() {
+		data = append(data, emailTemplateResponse(tmpl, templateSourceSystem))
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Email templates found",
+		"data": fiber.Map{
+			"templates": data,
+		},
+	})
+}
+
+// List the templates an organisation sends: the system templates, with
+// its overrides in their place, followed by its own templates
+// route GET /api/organisations/:orgId/email-templates
+func GetOrgEmailTemplates(c *fiber.Ctx) error {
+	var stored []models.EmailTemplate
+	if err := database.DB.Db.Where("organisation_id = ?", c.Params("orgId")).Order("template_id").Find(&stored).Error; err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while fetching email templates",
+		})
+	}
+
+	own := map[string]models.EmailTemplate{}
+	for _, row := range stored {
+		own[row.TemplateID] = row
+	}
+
+	data := []fiber.Map{}
+	for _, system := range mailer.SystemTemplates() {
+		row, overridden := own[system.ID]
+		if !overridden {
+			data = append(data, emailTemplateResponse(system, templateSourceSystem))
+			continue
+		}
+
+		delete(own, system.ID)
+		data = append(data, storedEmailTemplateResponse(row))
+	}
+	for _, row := range stored {
+		if _, custom := own[row.TemplateID]; custom {
+			data = append(data, storedEmailTemplateResponse(row))
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Email templates found",
+		"data": fiber.Map{
+			"templates": data,
+		},
+	})
+}
+
+// Get the version of a template an organisation sends
+// route GET /api/organisations/:orgId/email-templates/:templateId
+func GetOrgEmailTemplate(c *fiber.Ctx) error {
+	stored, err := findEmailTemplate(c.Params("orgId"), c.Params("templateId"))
+	if err == nil {
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"status":  "success",
+			"message": "Email template found",
+			"data":    storedEmailTemplateResponse(stored),
+		})
+	}
+
+	system, err := mailer.LookupTemplate(c.Params("templateId"))
+	if err != nil {
+		return emailTemplateNotFound(c)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Email template found",
+		"data":    emailTemplateResponse(system, templateSourceSystem),
+	})
+}
+
+// Add an organisation template. Using the id of a system template
+// overrides it for the organisation. Templates must compile and render
+// with their variables' examples before they are saved.
+// route POST /api/organisations/:orgId/email-templates
+func CreateOrgEmailTemplate(c *fiber.Ctx) error {
+	type ReqBody struct {
+		TemplateID string            `json:"templateId" validate:"required,max=100"`
+		Subject    string            `json:"subject" validate:"required,max=998"`
+		Body       string            `json:"body" validate:"required"`
+		HTML       string            `json:"html"`
+		Variables  []mailer.Variable `json:"variables"`
+	}
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+	if body.TemplateID != "" && !templateIdPattern.MatchString(body.TemplateID) {
+		validationErrors = append(validationErrors, validation.ValidationError{
+			Field:   "TemplateID",
+			Message: "TemplateID can only have lowercase letters, digits and underscores",
+		})
+	}
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	orgId, err := uuid.Parse(c.Params("orgId"))
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Organisation not found",
+		})
+	}
+
+	stored := models.EmailTemplate{
+		OrganisationID: orgId,
+		TemplateID:     body.TemplateID,
+		Subject:        body.Subject,
+		Body:           body.Body,
+		HTML:           body.HTML,
+	}
+
+	if errs := compileEmailTemplate(&stored, body.Variables, body.Variables != nil); len(errs) > 0 {
+		return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{"errors": errs})
+	}
+
+	var count int64
+	database.DB.Db.Model(&models.EmailTemplate{}).Where("organisation_id = ? AND template_id = ?", orgId, body.TemplateID).Count(&count)
+
+	if count > 0 {
+		return c.Status(http.StatusConflict).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusConflict,
+			"message":    "The organisation already has a template with this id",
+		})
+	}
+
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&stored).Error; err != nil {
+			return err
+		}
+
+		return audit.Record(tx, emailTemplateAuditEntry(c, stored, audit.EmailTemplateCreated))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while creating the email template",
+		})
+	}
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Email template created successfully",
+		"data":    storedEmailTemplateResponse(stored),
+	})
+}
+
+// Change an organisation template
+// route PUT /api/organisations/:orgId/email-templates/:templateId
+func UpdateOrgEmailTemplate(c *fiber.Ctx) error {
+	type ReqBody struct {
+		Subject   *string            `json:"subject" validate:"omitempty,min=1,max=998"`
+		Body      *string            `json:"body" validate:"omitempty,min=1"`
+		HTML      *string            `json:"html"`
+		Variables *[]mailer.Variable `json:"variables"`
+	}
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	stored, err := findEmailTemplate(c.Params("orgId"), c.Params("templateId"))
+	if err != nil {
+		return emailTemplateNotFound(c)
+	}
+
+	if body.Subject != nil {
+		stored.Subject = *body.Subject
+	}
+	if body.Body != nil {
+		stored.Body = *body.Body
+	}
+	if body.HTML != nil {
+		stored.HTML = *body.HTML
+	}
+
+	var variables []mailer.Variable
+	if body.Variables != nil {
+		variables = *body.Variables
+	} else {
+		json.Unmarshal([]byte(stored.Variables), &variables)
+	}
+
+	if errs := compileEmailTemplate(&stored, variables, body.Variables != nil); len(errs) > 0 {
+		return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{"errors": errs})
+	}
+
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&stored).Updates(map[string]interface{}{
+			"subject":   stored.Subject,
+			"body":      stored.Body,
+			"html":      stored.HTML,
+			"variables": stored.Variables,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(tx, emailTemplateAuditEntry(c, stored, audit.EmailTemplateUpdated))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while updating the email template",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Email template updated successfully",
+		"data":    storedEmailTemplateResponse(stored),
+	})
+}
+
+// Delete an organisation template. Deleting an override puts the system
+// template back in use.
+// route DELETE /api/organisations/:orgId/email-templates/:templateId
+func DeleteOrgEmailTemplate(c *fiber.Ctx) error {
+	stored, err := findEmailTemplate(c.Params("orgId"), c.Params("templateId"))
+	if err != nil {
+		return emailTemplateNotFound(c)
+	}
+
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(&stored).Error; err != nil {
+			return err
+		}
+
+		return audit.Record(tx, emailTemplateAuditEntry(c, stored, audit.EmailTemplateDeleted))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while deleting the email template",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Email template deleted successfully",
+	})
+}
+
+// Render a template without sending it. Any of subject, body, html and
+// variables given replace the saved ones, so edits can be previewed
+// before they are saved. Variables not given in data use their examples.
+// route POST /api/organisations/:orgId/email-templates/:templateId/preview
+func PreviewOrgEmailTemplate(c *fiber.Ctx) error {
+	type ReqBody struct {
+		Subject   *string                `json:"subject"`
+		Body      *string                `json:"body"`
+		HTML      *string                `json:"html"`
+		Variables *[]mailer.Variable     `json:"variables"`
+		Data      map[string]interface{} `json:"data"`
+	}
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	templateId := c.Params("templateId")
+	if !templateIdPattern.MatchString(templateId) {
+		return emailTemplateNotFound(c)
+	}
+
+	// Start from the organisation's current version, if there is one
+	var base *mailer.Template
+	if stored, err := findEmailTemplate(c.Params("orgId"), templateId); err == nil {
+		base, _ = mailer.OrgTemplate(stored)
+	} else if system, err := mailer.LookupTemplate(templateId); err == nil {
+		base = system
+	}
+
+	draft := models.EmailTemplate{TemplateID: templateId}
+	var variables []mailer.Variable
+	if base != nil {
+		draft.Subject, draft.Body, draft.HTML = base.Subject, base.Body, base.HTML
+		variables = base.Variables
+	}
+
+	if body.Subject != nil {
+		draft.Subject = *body.Subject
+	}
+	if body.Body != nil {
+		draft.Body = *body.Body
+	}
+	if body.HTML != nil {
+		draft.HTML = *body.HTML
+	}
+	if body.Variables != nil {
+		variables = *body.Variables
+	}
+
+	if base == nil && body.Subject == nil && body.Body == nil {
+		return emailTemplateNotFound(c)
+	}
+
+	if errs := compileEmailTemplate(&draft, variables, body.Variables != nil); len(errs) > 0 {
+		return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{"errors": errs})
+	}
+
+	tmpl, _ := mailer.OrgTemplate(draft)
+
+	data := tmpl.SampleData()
+	for name, value := range body.Data {
+		data[name] = value
+	}
+
+	msg, err := tmpl.Render(data)
+	if err != nil {
+		return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+			"errors": []validation.ValidationError{{Field: "Data", Message: err.Error()}},
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Email template rendered",
+		"data": fiber.Map{
+			"subject": msg.Subject,
+			"text":    msg.Text,
+			"html":    msg.HTML,
+		},
+	})
+}
+
+func findEmailTemplate(orgId string, templateId string) (models.EmailTemplate, error) {
+	var stored models.EmailTemplate
+	err := database.DB.Db.Where("organisation_id = ? AND template_id = ?", orgId, templateId).First(&stored).Error
+	return stored, err
+}
+
+// Check a template compiles and store its variables on it. Overrides of
+// a system template can't declare variables of their own.
+func compileEmailTemplate(stored *models.EmailTemplate, variables []mailer.Variable, declared bool) []validation.ValidationError {
+	if _, err := mailer.LookupTemplate(stored.TemplateID); err == nil {
+		if declared && len(variables) > 0 {
+			return []validation.ValidationError{{
+				Field:   "Variables",
+				Message: "Overrides use the variables of the system template and can't declare their own",
+			}}
+		}
+		variables = nil
+	}
+
+	if variables == nil {
+		variables = []mailer.Variable{}
+	}
+	raw, _ := json.Marshal(variables)
+	stored.Variables = string(raw)
+
+	if _, err := mailer.OrgTemplate(*stored); err != nil {
+		field := "Body"
+		var compileErr *mailer.CompileError
+		if errors.As(err, &compileErr) {
+			field = map[string]string{
+				"subject":   "Subject",
+				"body":      "Body",
+				"html":      "HTML",
+				"variables": "Variables",
+			}[compileErr.Part]
+			err = compileErr.Err
+		}
+
+		return []validation.ValidationError{{Field: field, Message: err.Error()}}
+	}
+
+	return nil
+}
+
+func emailTemplateNotFound(c *fiber.Ctx) error {
+	return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+		"status":     "error",
+		"statusCode": http.StatusNotFound,
+		"message":    "Email template not found",
+	})
+}
+
+func emailTemplateResponse(tmpl *mailer.Template, source string) fiber.Map {
+	variables := tmpl.Variables
+	if variables == nil {
+		variables = []mailer.Variable{}
+	}
+
+	return fiber.Map{
+		"templateId": tmpl.ID,
+		"subject":    tmpl.Subject,
+		"body":       tmpl.Body,
+		"html":       tmpl.HTML,
+		"variables":  variables,
+		"source":     source,
+	}
+}
+
+func storedEmailTemplateResponse(stored models.EmailTemplate) fiber.Map {
+	source := templateSourceCustom
+	var variables []mailer.Variable
+
+	if system, err := mailer.LookupTemplate(stored.TemplateID); err == nil {
+		source = templateSourceOverride
+		variables = system.Variables
+	} else {
+		json.Unmarshal([]byte(stored.Variables), &variables)
+	}
+
+	response := emailTemplateResponse(&mailer.Template{
+		ID:        stored.TemplateID,
+		Subject:   stored.Subject,
+		Body:      stored.Body,
+		HTML:      stored.HTML,
+		Variables: variables,
+	}, source)
+	response["updatedAt"] = stored.UpdatedAt
+
+	return response
+}
+
+func emailTemplateAuditEntry(c *fiber.Ctx, stored models.EmailTemplate, action string) audit.Entry {
+	return audit.FromRequest(c, action).
+		Org(stored.OrganisationID).
+		Target("email_template", stored.TemplateID)
+}
@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mryan-3/hng11/stage2/billing"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/pagination"
+	"github.com/mryan-3/hng11/stage2/payments"
+	"gorm.io/gorm"
+)
+
+// List an organisation's invoices, newest first
+// route GET /api/organisations/:orgId/invoices
+func GetOrgInvoices(c *fiber.Ctx) error {
+	page, err := pagination.Parse(c, invoicePagination)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    err.Error(),
+		})
+	}
+
+	var list []models.Invoice
+	err = database.DB.Db.Omit("pdf").
+		Where("organisation_id = ?", c.Params("orgId")).
+		Scopes(page.Scope).
+		Find(&list).Error
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while fetching invoices",
+		})
+	}
+
+	list, meta := page.Trim(list)
+
+	data := []fiber.Map{}
+	for _, invoice := range list {
+		data = append(data, invoiceResponse(invoice))
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Invoices found",
+		"data": fiber.Map{
+			"invoices": data,
+		},
+		"meta": meta,
+	})
+}
+
+// Get an invoice with its lines
+// route GET /api/organisations/:orgId/invoices/:invoiceId
+func GetOrgInvoice(c *fiber.Ctx) error {
+	invoice, ok := findInvoice(c, false)
+	if !ok {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Invoice not found",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Invoice found",
+		"data":    invoiceResponse(invoice),
+	})
+}
+
+// Download an invoice as the PDF it was issued as, or with
+// ?format=json as JSON
+// route GET /api/organisations/:orgId/invoices/:invoiceId/download
+func DownloadInvoice(c *fiber.Ctx) error {
+	format := c.Query("format", "pdf")
+	if format != "pdf" && format != "json" {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    "format must be pdf or json",
+		})
+	}
+
+	invoice, ok := findInvoice(c, format == "pdf")
+	if !ok {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Invoice not found",
+		})
+	}
+
+	filename := billing.InvoiceNumber(invoice)
+
+	if format == "json" {
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+filename+`.json"`)
+		return c.Status(http.StatusOK).JSON(invoiceResponse(invoice))
+	}
+
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+filename+`.pdf"`)
+	return c.Status(http.StatusOK).Send(invoice.PDF)
+}
+
+// Find the organisation's invoice named by the invoiceId param, with its
+// lines and optionally its PDF
+func findInvoice(c *fiber.Ctx, withPDF bool) (models.Invoice, bool) {
+	query := database.DB.Db.Preload("Lines", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	})
+	if !withPDF {
+		query = query.Omit("pdf")
+	}
+
+	var invoice models.Invoice
+	err := query.Where("invoice_id = ? AND organisation_id = ?", c.Params("invoiceId"), c.Params("orgId")).First(&invoice).Error
+
+	return invoice, err == nil
+}
+
+func invoiceResponse(invoice models.Invoice) fiber.Map {
+	amount := func(minor int64) json.Number {
+		return json.Number(payments.FormatMinor(minor, invoice.Currency))
+	}
+
+	lines := []fiber.Map{}
+	for _, line := range invoice.Lines {
+		entry := fiber.Map{
+			"kind":        line.Kind,
+			"description": line.Description,
+			"amount":      amount(line.Amount),
+		}
+		if line.Kind == models.InvoiceLineItem {
+			entry["quantity"] = line.Quantity
+		}
+		lines = append(lines, entry)
+	}
+
+	response := fiber.Map{
+		"id":          invoice.InvoiceID,
+		"orgId":       invoice.OrganisationID,
+		"number":      billing.InvoiceNumber(invoice),
+		"currency":    invoice.Currency,
+		"subtotal":    amount(invoice.Subtotal),
+		"tax":         amount(invoice.Tax),
+		"total":       amount(invoice.Total),
+		"periodStart": invoice.PeriodStart,
+		"periodEnd":   invoice.PeriodEnd,
+		"issuedAt":    invoice.IssuedAt,
+	}
+
+	// Lists leave the lines out
+	if invoice.Lines != nil {
+		response["lines"] = lines
+	}
+
+	return response
+}
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/pagination"
+	"github.com/mryan-3/hng11/stage2/policy"
+	"gorm.io/gorm"
+)
+
+// Find a user's membership of an organisation
+func findMembership(orgId string, userId string) (models.Membership, error) {
+	var membership models.Membership
+	err := database.DB.Db.Where("organisation_id = ? AND user_user_id = ?", orgId, userId).First(&membership).Error
+
+	return membership, err
+}
+
+// Check whether a user's role in an organisation grants a permission
+func hasOrgPermission(orgId string, userId string, permission string) bool {
+	_, permissions, err := policy.OrgPermissions(database.DB.Db, orgId, userId)
+
+	return err == nil && permissions.Has(permission)
+}
+
+// Respond that the user can't act in an organisation. Organisations that
+// don't exist or are in the trash are not found rather than forbidden.
+func orgForbidden(c *fiber.Ctx, orgId string, message string) error {
+	var orgs int64
+	database.DB.Db.Model(&models.Organisation{}).Where("id = ?", orgId).Count(&orgs)
+
+	if orgs == 0 {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Organisation not found",
+		})
+	}
+
+	return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+		"status":     "error",
+		"statusCode": http.StatusForbidden,
+		"message":    message,
+	})
+}
+
+// Check whether a user owns an organisation
+func isOrgOwner(orgId string, userId string) bool {
+	var count int64
+	database.DB.Db.Model(&models.Organisation{}).Where("id = ? AND owner_id = ?", orgId, userId).Count(&count)
+
+	return count > 0
+}
+
+// Set the role of an existing membership
+func setMembershipRole(tx *gorm.DB, orgId uuid.UUID, userId uuid.UUID, role string) error {
+	return tx.Model(&models.Membership{}).
+		Where("organisation_id = ? AND user_user_id = ?", orgId, userId).
+		Update("role", role).Error
+}
+
+// A member of an organisation as listed by the members endpoints
+type memberRow struct {
+	UserID    uuid.UUID
+	FirstName string
+	LastName  string
+	Email     string
+	Phone     string
+	Role      string
+	JoinedAt  time.Time
+}
+
+// Find a page of an organisation's members, optionally with one role
+func findMembers(page *pagination.Page[memberRow], orgId string, role string) ([]fiber.Map, pagination.Meta, error) {
+	query := database.DB.Db.Table("users").
+		Select("users.user_id, users.first_name, users.last_name, users.email, users.phone, "+
+			"user_organizations.role, user_organizations.created_at AS joined_at").
+		Joins("JOIN user_organizations ON user_organizations.user_user_id = users.user_id").
+		Where("user_organizations.organisation_id = ? AND users.deleted_at IS NULL", orgId)
+
+	if role != "" {
+		query = query.Where("user_organizations.role = ?", role)
+	}
+
+	var rows []memberRow
+	if err := query.Scopes(page.Scope).Find(&rows).Error; err != nil {
+		return nil, pagination.Meta{}, err
+	}
+
+	rows, meta := page.Trim(rows)
+
+	members := []fiber.Map{}
+	for _, row := range rows {
+		members = append(members, fiber.Map{
+			"userId":    row.UserID,
+			"firstName": row.FirstName,
+			"lastName":  row.LastName,
+			"email":     row.Email,
+			"phone":     row.Phone,
+			"role":      row.Role,
+			"joinedAt":  row.JoinedAt,
+		})
+	}
+
+	return members, meta, nil
+}
@@ -0,0 +1,489 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/config"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/pagination"
+	"github.com/mryan-3/hng11/stage2/validation"
+	"github.com/mryan-3/hng11/stage2/webhooks"
+	"gorm.io/gorm"
+)
+
+// List an organisation's webhook endpoints
+// route GET /api/organisations/:orgId/webhooks
+func GetOrgWebhooks(c *fiber.Ctx) error {
+	var endpoints []models.WebhookEndpoint
+	if err := database.DB.Db.Where("organisation_id = ?", c.Params("orgId")).Order("created_at").Find(&endpoints).Error; err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while fetching webhooks",
+		})
+	}
+
+	data := []fiber.Map{}
+	for _, endpoint := range endpoints {
+		data = append(data, webhookResponse(endpoint))
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Webhooks found",
+		"data": fiber.Map{
+			"webhooks": data,
+			"events":   webhooks.Events,
+		},
+	})
+}
+
+// Register a webhook endpoint. Leave events out to be sent every event.
+// The signing secret is only shown here and when it is rotated.
+// route POST /api/organisations/:orgId/webhooks
+func CreateOrgWebhook(c *fiber.Ctx) error {
+	type ReqBody struct {
+		URL         string   `json:"url" validate:"required,max=2048"`
+		Description string   `json:"description" validate:"max=255"`
+		Events      []string `json:"events"`
+	}
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+	validationErrors = append(validationErrors, validateWebhook(body.URL, body.Events)...)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	orgId, err := uuid.Parse(c.Params("orgId"))
+	if err != nil {
+		return webhookNotFound(c)
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while creating the webhook",
+		})
+	}
+
+	endpoint := models.WebhookEndpoint{
+		OrganisationID: orgId,
+		URL:            body.URL,
+		Description:    body.Description,
+		Events:         strings.Join(body.Events, " "),
+		Secret:         secret,
+		Active:         true,
+	}
+
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&endpoint).Error; err != nil {
+			return err
+		}
+
+		return audit.Record(tx, webhookAuditEntry(c, endpoint, audit.WebhookCreated))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while creating the webhook",
+		})
+	}
+
+	response := webhookResponse(endpoint)
+	response["secret"] = endpoint.Secret
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Webhook created successfully",
+		"data":    response,
+	})
+}
+
+// Get a webhook endpoint
+// route GET /api/organisations/:orgId/webhooks/:webhookId
+func GetOrgWebhook(c *fiber.Ctx) error {
+	endpoint, err := findWebhook(c)
+	if err != nil {
+		return webhookNotFound(c)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Webhook found",
+		"data":    webhookResponse(endpoint),
+	})
+}
+
+// Change a webhook endpoint. Inactive endpoints are sent nothing, and
+// their pending deliveries wait until they are active again.
+// route PUT /api/organisations/:orgId/webhooks/:webhookId
+func UpdateOrgWebhook(c *fiber.Ctx) error {
+	type ReqBody struct {
+		URL         *string   `json:"url" validate:"omitempty,max=2048"`
+		Description *string   `json:"description" validate:"omitempty,max=255"`
+		Events      *[]string `json:"events"`
+		Active      *bool     `json:"active"`
+	}
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	endpoint, err := findWebhook(c)
+	if err != nil {
+		return webhookNotFound(c)
+	}
+
+	wasActive := endpoint.Active
+
+	updates := map[string]interface{}{}
+	if body.URL != nil {
+		updates["url"] = *body.URL
+		endpoint.URL = *body.URL
+	}
+	if body.Description != nil {
+		updates["description"] = *body.Description
+		endpoint.Description = *body.Description
+	}
+	if body.Events != nil {
+		updates["events"] = strings.Join(*body.Events, " ")
+		endpoint.Events = updates["events"].(string)
+	}
+	if body.Active != nil {
+		updates["active"] = *body.Active
+		endpoint.Active = *body.Active
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+	validationErrors = append(validationErrors, validateWebhook(endpoint.URL, endpoint.EventList())...)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	if len(updates) > 0 {
+		err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&endpoint).Updates(updates).Error; err != nil {
+				return err
+			}
+
+			// Send what was held back while the endpoint was off
+			if endpoint.Active && !wasActive {
+				if err := webhooks.Resume(tx, endpoint); err != nil {
+					return err
+				}
+			}
+
+			return audit.Record(tx, webhookAuditEntry(c, endpoint, audit.WebhookUpdated))
+		})
+
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+				"status":  "error",
+				"message": "An error occurred while updating the webhook",
+			})
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Webhook updated successfully",
+		"data":    webhookResponse(endpoint),
+	})
+}
+
+// Replace a webhook endpoint's signing secret. Requests are signed with
+// the new secret from now on.
+// route POST /api/organisations/:orgId/webhooks/:webhookId/rotate-secret
+func RotateOrgWebhookSecret(c *fiber.Ctx) error {
+	endpoint, err := findWebhook(c)
+	if err != nil {
+		return webhookNotFound(c)
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err == nil {
+		err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&endpoint).Update("secret", secret).Error; err != nil {
+				return err
+			}
+
+			return audit.Record(tx, webhookAuditEntry(c, endpoint, audit.WebhookSecretRotated))
+		})
+	}
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while rotating the secret",
+		})
+	}
+
+	response := webhookResponse(endpoint)
+	response["secret"] = secret
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Webhook secret rotated",
+		"data":    response,
+	})
+}
+
+// Remove a webhook endpoint. Its pending deliveries are not sent.
+// route DELETE /api/organisations/:orgId/webhooks/:webhookId
+func DeleteOrgWebhook(c *fiber.Ctx) error {
+	endpoint, err := findWebhook(c)
+	if err != nil {
+		return webhookNotFound(c)
+	}
+
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&endpoint).Error; err != nil {
+			return err
+		}
+
+		return audit.Record(tx, webhookAuditEntry(c, endpoint, audit.WebhookDeleted))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while deleting the webhook",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Webhook deleted successfully",
+	})
+}
+
+// The delivery log of a webhook endpoint, newest first. Filter with
+// ?status=pending, delivered or dead.
+// route GET /api/organisations/:orgId/webhooks/:webhookId/deliveries
+func GetOrgWebhookDeliveries(c *fiber.Ctx) error {
+	page, err := pagination.Parse(c, webhookDeliveryPagination)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    err.Error(),
+		})
+	}
+
+	endpoint, err := findWebhook(c)
+	if err != nil {
+		return webhookNotFound(c)
+	}
+
+	query := database.DB.Db.Where("endpoint_id = ?", endpoint.EndpointID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var list []models.WebhookDelivery
+	if err := query.Scopes(page.Scope).Find(&list).Error; err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while fetching deliveries",
+		})
+	}
+
+	list, meta := page.Trim(list)
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Deliveries found",
+		"data": fiber.Map{
+			"deliveries": list,
+		},
+		"meta": meta,
+	})
+}
+
+// Get a delivery with its payload and every attempt at sending it
+// route GET /api/organisations/:orgId/webhooks/:webhookId/deliveries/:deliveryId
+func GetOrgWebhookDelivery(c *fiber.Ctx) error {
+	delivery, err := findWebhookDelivery(c)
+	if err != nil {
+		return deliveryNotFound(c)
+	}
+
+	var attempts []models.WebhookAttempt
+	if err := database.DB.Db.Where("delivery_id = ?", delivery.DeliveryID).Order("attempted_at").Find(&attempts).Error; err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while fetching the delivery",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Delivery found",
+		"data": fiber.Map{
+			"delivery": delivery,
+			"payload":  json.RawMessage(delivery.Payload),
+			"attempts": attempts,
+		},
+	})
+}
+
+// Send a delivery again, e.g. a dead one once the endpoint is fixed
+// route POST /api/organisations/:orgId/webhooks/:webhookId/deliveries/:deliveryId/retry
+func RetryOrgWebhookDelivery(c *fiber.Ctx) error {
+	delivery, err := findWebhookDelivery(c)
+	if err != nil {
+		return deliveryNotFound(c)
+	}
+
+	if delivery.Status == models.DeliveryPending {
+		return c.Status(http.StatusConflict).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusConflict,
+			"message":    "This delivery is already waiting to be sent",
+		})
+	}
+
+	if err := webhooks.Redeliver(database.DB.Db, &delivery); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while retrying the delivery",
+		})
+	}
+
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Delivery queued",
+		"data":    delivery,
+	})
+}
+
+// Check a webhook's URL and events. Production endpoints must use https,
+// and no endpoint can point at a private or internal address.
+func validateWebhook(rawURL string, events []string) []validation.ValidationError {
+	var errs []validation.ValidationError
+
+	if rawURL != "" {
+		parsed, err := url.Parse(rawURL)
+		allowed := err == nil && parsed.Host != "" &&
+			(parsed.Scheme == "https" || parsed.Scheme == "http" && config.Env() != config.Production)
+		if !allowed {
+			message := "URL must be an http or https URL"
+			if config.Env() == config.Production {
+				message = "URL must be an https URL"
+			}
+			errs = append(errs, validation.ValidationError{Field: "URL", Message: message})
+		} else if err := checkWebhookHost(rawURL); err != nil {
+			errs = append(errs, validation.ValidationError{Field: "URL", Message: "URL must point at a public address"})
+		}
+	}
+
+	for _, event := range events {
+		if !webhooks.IsEvent(event) {
+			errs = append(errs, validation.ValidationError{
+				Field:   "Events",
+				Message: "Events must be from: " + strings.Join(webhooks.Events, ", "),
+			})
+			break
+		}
+	}
+
+	return errs
+}
+
+func checkWebhookHost(rawURL string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return webhooks.CheckURL(ctx, rawURL)
+}
+
+func findWebhook(c *fiber.Ctx) (models.WebhookEndpoint, error) {
+	var endpoint models.WebhookEndpoint
+	err := database.DB.Db.
+		Where("endpoint_id = ? AND organisation_id = ?", c.Params("webhookId"), c.Params("orgId")).
+		First(&endpoint).Error
+
+	return endpoint, err
+}
+
+func findWebhookDelivery(c *fiber.Ctx) (models.WebhookDelivery, error) {
+	endpoint, err := findWebhook(c)
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+
+	var delivery models.WebhookDelivery
+	err = database.DB.Db.Where("delivery_id = ? AND endpoint_id = ?", c.Params("deliveryId"), endpoint.EndpointID).First(&delivery).Error
+
+	return delivery, err
+}
+
+func webhookNotFound(c *fiber.Ctx) error {
+	return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+		"status":     "error",
+		"statusCode": http.StatusNotFound,
+		"message":    "Webhook not found",
+	})
+}
+
+func deliveryNotFound(c *fiber.Ctx) error {
+	return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+		"status":     "error",
+		"statusCode": http.StatusNotFound,
+		"message":    "Delivery not found",
+	})
+}
+
+func webhookResponse(endpoint models.WebhookEndpoint) fiber.Map {
+	return fiber.Map{
+		"id":          endpoint.EndpointID,
+		"orgId":       endpoint.OrganisationID,
+		"url":         endpoint.URL,
+		"description": endpoint.Description,
+		"events":      endpoint.EventList(),
+		"active":      endpoint.Active,
+		"createdAt":   endpoint.CreatedAt,
+		"updatedAt":   endpoint.UpdatedAt,
+	}
+}
+
+func webhookAuditEntry(c *fiber.Ctx, endpoint models.WebhookEndpoint, action string) audit.Entry {
+	return audit.FromRequest(c, action).
+		Org(endpoint.OrganisationID).
+		Target("webhook", endpoint.EndpointID).
+		With(map[string]interface{}{
+			"url":    endpoint.URL,
+			"events": endpoint.EventList(),
+			"active": endpoint.Active,
+		})
+}
@@ -1,14 +1,21 @@
 package controller
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/billing"
 	"github.com/mryan-3/hng11/stage2/database"
 	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/pagination"
+	"github.com/mryan-3/hng11/stage2/policy"
 	"github.com/mryan-3/hng11/stage2/validation"
+	"github.com/mryan-3/hng11/stage2/webhooks"
+	"gorm.io/gorm"
 )
 
 // Get a users organisations
@@ -27,22 +34,40 @@ func GetUserOrganisations(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
 			"status":  "error",
-			"message": "Error parsing the doctor ID",
+			"message": "Error parsing the user ID",
 		})
 	}
 
-	var user models.User
-	if err := database.DB.Db.Preload("Organisations").First(&user, "user_id = ?", userId).Error; err != nil {
-		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
-			"status":     "error",
-			"statusCode": http.StatusNotFound,
-			"message":    "User not found",
+	page, err := pagination.Parse(c, organisationPagination)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    err.Error(),
+		})
+	}
+
+	query := database.DB.Db.
+		Joins("JOIN user_organizations ON user_organizations.organisation_id = organisations.id").
+		Where("user_organizations.user_user_id = ?", userId)
+
+	if name := c.Query("name"); name != "" {
+		query = query.Where("organisations.name ILIKE ?", pagination.Contains(name))
+	}
+
+	var organisations []models.Organisation
+	if err := query.Scopes(page.Scope).Find(&organisations).Error; err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while fetching organisations",
 		})
 	}
 
-	var organizationsResponse []OrganizationResponse
+	organisations, meta := page.Trim(organisations)
 
-	for _, org := range user.Organisations {
+	organizationsResponse := []OrganizationResponse{}
+
+	for _, org := range organisations {
 		organizationsResponse = append(organizationsResponse, OrganizationResponse{
 			OrgID:       org.ID.String(),
 			Name:        org.Name,
@@ -55,6 +80,7 @@ func GetUserOrganisations(c *fiber.Ctx) error {
 		"data": fiber.Map{
 			"organisations": organizationsResponse,
 		},
+		"meta": meta,
 	}
 
 	return c.Status(http.StatusOK).JSON(response)
@@ -65,9 +91,11 @@ func GetUserOrganisations(c *fiber.Ctx) error {
 // route GET /api/organisations/:orgId
 func GetSingleOrganisation(c *fiber.Ctx) error {
 	type OrganizationResponse struct {
-		OrgID       string `json:"orgId"`
-		Name        string `json:"name" validate:"required"`
-		Description string `json:"description"`
+		OrgID       string      `json:"orgId"`
+		Name        string      `json:"name" validate:"required"`
+		Description string      `json:"description"`
+		OwnerID     *uuid.UUID  `json:"ownerId"`
+		Users       []fiber.Map `json:"users,omitempty"`
 	}
 	orgId := c.Params("orgId")
 
@@ -91,12 +119,88 @@ func GetSingleOrganisation(c *fiber.Ctx) error {
 		OrgID:       org.ID.String(),
 		Name:        org.Name,
 		Description: org.Description,
+		OwnerID:     org.OwnerID,
 	}
 
 	response := fiber.Map{
 		"status":  "success",
 		"message": "Organisation found",
-		"data":    organizationResponse,
+	}
+
+	// ?include=members adds the first page of members
+	if c.Query("include") == "members" {
+		if !hasOrgPermission(orgId, c.Locals("userId").(string), policy.MemberRead) {
+			return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+				"status":     "error",
+				"statusCode": http.StatusForbidden,
+				"message":    "You need the " + policy.MemberRead + " permission",
+			})
+		}
+
+		page, err := pagination.Parse(c, memberPagination)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+				"status":     "Bad request",
+				"statusCode": http.StatusBadRequest,
+				"message":    err.Error(),
+			})
+		}
+
+		members, meta, err := findMembers(page, orgId, c.Query("role"))
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+				"status":  "error",
+				"message": "An error occurred while fetching members",
+			})
+		}
+
+		organizationResponse.Users = members
+		response["meta"] = meta
+	}
+
+	response["data"] = organizationResponse
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// Get the members of an organisation with their roles
+// route GET /api/organisations/:orgId/users
+func GetOrganisationMembers(c *fiber.Ctx) error {
+	orgId := c.Params("orgId")
+
+	var org models.Organisation
+	if err := database.DB.Db.Where("id = ?", orgId).First(&org).Error; err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Organisation not found",
+		})
+	}
+
+	page, err := pagination.Parse(c, memberPagination)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    err.Error(),
+		})
+	}
+
+	members, meta, err := findMembers(page, orgId, c.Query("role"))
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while fetching members",
+		})
+	}
+
+	response := fiber.Map{
+		"status":  "success",
+		"message": "Members found",
+		"data": fiber.Map{
+			"users": members,
+		},
+		"meta": meta,
 	}
 
 	return c.Status(http.StatusOK).JSON(response)
@@ -138,29 +242,53 @@ func CreateOrganisation(c *fiber.Ctx) error {
 		return c.Status(http.StatusUnprocessableEntity).JSON(response)
 	}
 
+	// New organisations start on the default plan
+	plan, _ := billing.LookupPlan(billing.DefaultPlan)
+	if err := billing.CheckOrgLimit(database.DB.Db, user.UserID, plan); err != nil {
+		return billingErrorResponse(c, err, plan)
+	}
+
 	org := models.Organisation{
 		Name:        body.Name,
 		Description: body.Description,
 	}
 
-	if err := database.DB.Db.Create(&org).Error; err != nil {
-		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
-			"status":     "Bad request",
-			"message":    "Client error",
-			"statusCode": http.StatusBadRequest,
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&org).Error; err != nil {
+			return err
+		}
+
+		// Add user to organisation
+		if err := tx.Model(&user).Association("Organisations").Append(&org); err != nil {
+			return err
+		}
+
+		// Add organisation to user
+		if err := tx.Model(&org).Association("Users").Append(&user); err != nil {
+			return err
+		}
+
+		// The creator owns and administers the organisation
+		if err := setMembershipRole(tx, org.ID, user.UserID, models.RoleAdmin); err != nil {
+			return err
+		}
+		if err := tx.Model(&org).Update("owner_id", user.UserID).Error; err != nil {
+			return err
+		}
+		if err := billing.SyncOrgSeats(tx, org.ID, time.Now()); err != nil {
+			return err
+		}
+
+		return audit.Record(tx, audit.FromRequest(c, audit.OrganisationCreated).Org(org.ID).Target("organisation", org.ID))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while creating the organisation",
 		})
 	}
 
-	// Add user to organisation
-	database.DB.Db.Model(&user).Association("Organisations").Append(&org)
-
-	fmt.Println("User added to organisation", user.Organisations)
-
-	// Add organisation to user
-	database.DB.Db.Model(&org).Association("Users").Append(&user)
-
-	fmt.Println("Organisation added to user", org.Users)
-
 	response := fiber.Map{
 		"status":  "success",
 		"message": "Organisation created successfully",
@@ -168,12 +296,87 @@ func CreateOrganisation(c *fiber.Ctx) error {
 			"orgId":       org.ID.String(),
 			"name":        org.Name,
 			"description": org.Description,
+			"ownerId":     user.UserID,
 		},
 	}
 
 	return c.Status(http.StatusCreated).JSON(response)
 }
 
+// Change an organisation's name or description
+// route PUT /api/organisations/:orgId
+func UpdateOrganisation(c *fiber.Ctx) error {
+	type ReqBody struct {
+		Name        *string `json:"name" validate:"omitempty,min=1,max=255"`
+		Description *string `json:"description" validate:"omitempty,max=255"`
+	}
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	org, ok := findOrganisation(c)
+	if !ok {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Organisation not found",
+		})
+	}
+
+	updates := map[string]interface{}{}
+	if body.Name != nil {
+		updates["name"] = *body.Name
+		org.Name = *body.Name
+	}
+	if body.Description != nil {
+		updates["description"] = *body.Description
+		org.Description = *body.Description
+	}
+
+	if len(updates) > 0 {
+		err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&org).Updates(updates).Error; err != nil {
+				return err
+			}
+			if err := webhooks.Emit(tx, org.ID, webhooks.OrganisationUpdated, organisationEventData(org)); err != nil {
+				return err
+			}
+
+			return audit.Record(tx, audit.FromRequest(c, audit.OrganisationUpdated).
+				Org(org.ID).
+				Target("organisation", org.ID).
+				With(map[string]interface{}{"changes": updates}))
+		})
+
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+				"status":  "error",
+				"message": "An error occurred while updating the organisation",
+			})
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Organisation updated successfully",
+		"data":    organisationEventData(org),
+	})
+}
+
 // Add a user to a particular organisation
 // route POST /api/organisations/:orgId/users
 func AddUserToOrganisation(c *fiber.Ctx) error {
@@ -219,11 +422,63 @@ func AddUserToOrganisation(c *fiber.Ctx) error {
 		})
 	}
 
-	// Add user to organisation
-	database.DB.Db.Model(&user).Association("Organisations").Append(&org)
+	var plan billing.Plan
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		// The new member takes a seat if the plan has one left
+		subscription, err := billing.Lock(tx, org.ID, time.Now())
+		if err != nil {
+			return err
+		}
+		if plan, err = billing.LookupPlan(subscription.PlanID); err != nil {
+			return err
+		}
+		if err := billing.CheckSeat(tx, subscription, user.UserID); err != nil {
+			return err
+		}
+
+		var existing int64
+		err = tx.Model(&models.Membership{}).Where("organisation_id = ? AND user_user_id = ?", org.ID, user.UserID).Count(&existing).Error
+		if err != nil {
+			return err
+		}
+
+		// Add user to organisation
+		if err := tx.Model(&user).Association("Organisations").Append(&org); err != nil {
+			return err
+		}
+
+		// Add organisation to user
+		if err := tx.Model(&org).Association("Users").Append(&user); err != nil {
+			return err
+		}
+
+		if _, err := billing.SyncSeats(tx, &subscription, time.Now()); err != nil {
+			return err
+		}
+
+		if existing == 0 {
+			err := webhooks.Emit(tx, org.ID, webhooks.MemberAdded, map[string]interface{}{
+				"userId": user.UserID,
+				"role":   models.RoleMember,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return audit.Record(tx, audit.FromRequest(c, audit.MemberAdded).Org(org.ID).Target("user", user.UserID))
+	})
+
+	if errors.Is(err, billing.ErrSeatLimitReached) {
+		return billingErrorResponse(c, err, plan)
+	}
 
-	// Add organisation to user
-	database.DB.Db.Model(&org).Association("Users").Append(&user)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while adding the user",
+		})
+	}
 
 	response := fiber.Map{
 		"status":  "success",
@@ -232,3 +487,279 @@ func AddUserToOrganisation(c *fiber.Ctx) error {
 
 	return c.Status(http.StatusOK).JSON(response)
 }
+
+// Change a member's role. This also takes role:manage, and only to and
+// from roles within the caller's own permissions.
+// route PUT /api/organisations/:orgId/users/:userId
+func UpdateMembership(c *fiber.Ctx) error {
+	type ReqBody struct {
+		Role *string `json:"role" validate:"omitempty,min=1,max=50"`
+	}
+
+	orgId := c.Params("orgId")
+	memberId := c.Params("userId")
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	membership, err := findMembership(orgId, memberId)
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Member not found",
+		})
+	}
+
+	updates := map[string]interface{}{}
+	if body.Role != nil {
+		updates["role"] = *body.Role
+	}
+
+	if body.Role != nil && *body.Role != membership.Role {
+		// Custom roles must exist before members can be given them
+		to, ok, err := policy.RolePermissions(database.DB.Db, orgId, *body.Role)
+		if err == nil && !ok {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"errors": []validation.ValidationError{{Field: "Role", Message: "Role must be admin, member or one of the organisation's roles"}},
+			})
+		}
+
+		var from policy.Set
+		if err == nil {
+			from, _, err = policy.RolePermissions(database.DB.Db, orgId, membership.Role)
+		}
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+				"status":  "error",
+				"message": "An error occurred while checking permissions",
+			})
+		}
+
+		caller, _ := c.Locals("permissions").(policy.Set)
+		if err := policy.CanAssignRole(caller, from, to); err != nil {
+			return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+				"status":     "error",
+				"statusCode": http.StatusForbidden,
+				"message":    err.Error(),
+			})
+		}
+	}
+
+	if body.Role != nil && *body.Role != models.RoleAdmin && isOrgOwner(orgId, memberId) {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    "The organisation owner must stay an admin. Transfer ownership first.",
+		})
+	}
+
+	// Don't leave the organisation without an admin
+	if body.Role != nil && *body.Role != models.RoleAdmin && membership.Role == models.RoleAdmin {
+		var admins int64
+		database.DB.Db.Model(&models.Membership{}).Where("organisation_id = ? AND role = ?", orgId, models.RoleAdmin).Count(&admins)
+
+		if admins <= 1 {
+			return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+				"status":     "Bad request",
+				"statusCode": http.StatusBadRequest,
+				"message":    "An organisation must have at least one admin",
+			})
+		}
+	}
+
+	if len(updates) > 0 {
+		err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Model(&models.Membership{}).
+				Where("organisation_id = ? AND user_user_id = ?", orgId, memberId).
+				Updates(updates).Error
+			if err != nil {
+				return err
+			}
+
+			err = webhooks.Emit(tx, membership.OrganisationID, webhooks.MemberUpdated, map[string]interface{}{
+				"userId":  membership.UserUserID,
+				"changes": updates,
+			})
+			if err != nil {
+				return err
+			}
+
+			return audit.Record(tx, audit.FromRequest(c, audit.MemberUpdated).
+				Org(membership.OrganisationID).
+				Target("user", memberId).
+				With(map[string]interface{}{"changes": updates, "previousRole": membership.Role}))
+		})
+
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+				"status":  "error",
+				"message": "An error occurred while updating the member",
+			})
+		}
+	}
+
+	membership, _ = findMembership(orgId, memberId)
+
+	response := fiber.Map{
+		"status":  "success",
+		"message": "Member updated successfully",
+		"data": fiber.Map{
+			"orgId":  orgId,
+			"userId": memberId,
+			"role":   membership.Role,
+		},
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// Remove a member from an organisation, freeing their seat. Members can
+// also remove themselves without member:remove. The owner can't be
+// removed.
+// route DELETE /api/organisations/:orgId/users/:userId
+func RemoveMember(c *fiber.Ctx) error {
+	orgId := c.Params("orgId")
+	memberId := c.Params("userId")
+
+	membership, err := findMembership(orgId, memberId)
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Member not found",
+		})
+	}
+
+	if isOrgOwner(orgId, memberId) {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    "The organisation owner can't be removed. Transfer ownership first.",
+		})
+	}
+
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		subscription, err := billing.Lock(tx, membership.OrganisationID, time.Now())
+		if err != nil {
+			return err
+		}
+
+		teams := tx.Model(&models.Team{}).Select("team_id").Where("organisation_id = ?", membership.OrganisationID)
+		if err := tx.Where("user_id = ? AND team_id IN (?)", membership.UserUserID, teams).Delete(&models.TeamMember{}).Error; err != nil {
+			return err
+		}
+
+		err = tx.Where("organisation_id = ? AND user_user_id = ?", membership.OrganisationID, membership.UserUserID).
+			Delete(&models.Membership{}).Error
+		if err != nil {
+			return err
+		}
+
+		if _, err := billing.SyncSeats(tx, &subscription, time.Now()); err != nil {
+			return err
+		}
+
+		err = webhooks.Emit(tx, membership.OrganisationID, webhooks.MemberRemoved, map[string]interface{}{
+			"userId": membership.UserUserID,
+			"role":   membership.Role,
+		})
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(tx, audit.FromRequest(c, audit.MemberRemoved).
+			Org(membership.OrganisationID).
+			Target("user", membership.UserUserID).
+			With(map[string]interface{}{"role": membership.Role}))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while removing the member",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Member removed successfully",
+	})
+}
+
+// Delete an organisation. Only the owner can do this. The organisation
+// goes to the trash with its members and teams, so a platform admin can
+// restore it until the retention period ends. Its subscription is
+// suspended meanwhile.
+// route DELETE /api/organisations/:orgId
+func DeleteOrganisation(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	orgId := c.Params("orgId")
+
+	var org models.Organisation
+	if err := database.DB.Db.Where("id = ?", orgId).First(&org).Error; err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Organisation not found",
+		})
+	}
+
+	if !isOrgOwner(orgId, userId) {
+		return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusForbidden,
+			"message":    "Only the organisation owner can delete it",
+		})
+	}
+
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&org).Error; err != nil {
+			return err
+		}
+
+		if err := billing.Suspend(tx, org.ID, time.Now()); err != nil {
+			return err
+		}
+
+		return audit.Record(tx, audit.FromRequest(c, audit.OrganisationDeleted).
+			Org(org.ID).
+			Target("organisation", org.ID))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while deleting the organisation",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Organisation deleted successfully",
+	})
+}
+
+// An organisation as sent in organisation webhooks
+func organisationEventData(org models.Organisation) map[string]interface{} {
+	return map[string]interface{}{
+		"orgId":            org.ID,
+		"name":             org.Name,
+		"description":      org.Description,
+		"requireTwoFactor": org.RequireTwoFactor,
+	}
+}
@@ -0,0 +1,334 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/billing"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/mailer"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/validation"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// How long the recipient has to accept an ownership transfer
+const ownershipTransferLifetime = 7 * 24 * time.Hour
+
+var errTransferClosed = errors.New("ownership transfer is no longer open")
+
+// Offer ownership of an organisation to another member. Only the owner
+// can do this, and only one transfer can be open at a time.
+// route POST /api/organisations/:orgId/ownership-transfers
+func CreateOwnershipTransfer(c *fiber.Ctx) error {
+	type ReqBody struct {
+		UserID string `json:"userId" validate:"required,uuid"`
+	}
+
+	orgId := c.Params("orgId")
+	userId := c.Locals("userId").(string)
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	var org models.Organisation
+	if err := database.DB.Db.Where("id = ?", orgId).First(&org).Error; err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Organisation not found",
+		})
+	}
+
+	if org.OwnerID == nil || org.OwnerID.String() != userId {
+		return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusForbidden,
+			"message":    "Only the organisation owner can transfer ownership",
+		})
+	}
+
+	if body.UserID == userId {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    "You already own this organisation",
+		})
+	}
+
+	var recipient models.User
+	if err := database.DB.Db.Where("user_id = ?", body.UserID).First(&recipient).Error; err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Member not found",
+		})
+	}
+
+	if _, err := findMembership(orgId, body.UserID); err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Member not found",
+		})
+	}
+
+	var open int64
+	database.DB.Db.Model(&models.OwnershipTransfer{}).
+		Where("organisation_id = ? AND status = ? AND expires_at > ?", org.ID, models.TransferPending, time.Now()).
+		Count(&open)
+
+	if open > 0 {
+		return c.Status(http.StatusConflict).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusConflict,
+			"message":    "An ownership transfer is already pending for this organisation",
+		})
+	}
+
+	transfer := models.OwnershipTransfer{
+		OrganisationID: org.ID,
+		FromUserID:     *org.OwnerID,
+		ToUserID:       recipient.UserID,
+		Status:         models.TransferPending,
+		ExpiresAt:      time.Now().Add(ownershipTransferLifetime),
+	}
+
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&transfer).Error; err != nil {
+			return err
+		}
+
+		link := os.Getenv("CLIENT_FRONTEND_URL") + "/ownership-transfers/" + transfer.TransferID.String()
+		err := mailer.EnqueueTemplate(tx, &org.ID, recipient.Email, "ownership_transfer", map[string]interface{}{
+			"firstName":        recipient.FirstName,
+			"organisationName": org.Name,
+			"link":             link,
+		})
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(tx, transferAuditEntry(c, transfer, audit.OwnershipTransferRequested))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while creating the ownership transfer",
+		})
+	}
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Ownership transfer requested",
+		"data":    transferResponse(transfer),
+	})
+}
+
+// Accept an ownership transfer. The recipient becomes the owner and an
+// admin; the previous owner stays an admin.
+// route POST /api/ownership-transfers/:transferId/accept
+func AcceptOwnershipTransfer(c *fiber.Ctx) error {
+	transfer, ok := findRecipientTransfer(c)
+	if !ok {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Ownership transfer not found",
+		})
+	}
+
+	var plan billing.Plan
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		// Lock the transfer so it can only be answered once
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&transfer, "transfer_id = ?", transfer.TransferID).Error; err != nil {
+			return err
+		}
+		if !transfer.Open() {
+			return errTransferClosed
+		}
+
+		// The recipient must be allowed to own another organisation on
+		// the organisation's plan
+		subscription, err := billing.Lock(tx, transfer.OrganisationID, time.Now())
+		if err != nil {
+			return err
+		}
+		if plan, err = billing.LookupPlan(subscription.PlanID); err != nil {
+			return err
+		}
+		if err := billing.CheckOrgLimit(tx, transfer.ToUserID, plan); err != nil {
+			return err
+		}
+
+		// The offer lapses if the sender no longer owns the organisation
+		// or the recipient has left it
+		result := tx.Model(&models.Organisation{}).
+			Where("id = ? AND owner_id = ?", transfer.OrganisationID, transfer.FromUserID).
+			Update("owner_id", transfer.ToUserID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errTransferClosed
+		}
+
+		result = tx.Model(&models.Membership{}).
+			Where("organisation_id = ? AND user_user_id = ?", transfer.OrganisationID, transfer.ToUserID).
+			Update("role", models.RoleAdmin)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errTransferClosed
+		}
+
+		now := time.Now()
+		transfer.Status = models.TransferAccepted
+		transfer.RespondedAt = &now
+		if err := tx.Save(&transfer).Error; err != nil {
+			return err
+		}
+
+		return audit.Record(tx, transferAuditEntry(c, transfer, audit.OwnershipTransferAccepted))
+	})
+
+	if errors.Is(err, billing.ErrOrgLimitReached) {
+		return billingErrorResponse(c, err, plan)
+	}
+
+	return respondToTransfer(c, transfer, err, "Ownership transfer accepted")
+}
+
+// Decline an ownership transfer
+// route POST /api/ownership-transfers/:transferId/decline
+func DeclineOwnershipTransfer(c *fiber.Ctx) error {
+	transfer, ok := findRecipientTransfer(c)
+	if !ok {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Ownership transfer not found",
+		})
+	}
+
+	err := closeTransfer(c, transfer, models.TransferDeclined, audit.OwnershipTransferDeclined)
+
+	return respondToTransfer(c, transfer, err, "Ownership transfer declined")
+}
+
+// Withdraw an ownership transfer before the recipient answers
+// route DELETE /api/ownership-transfers/:transferId
+func CancelOwnershipTransfer(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+
+	var transfer models.OwnershipTransfer
+	err := database.DB.Db.Where("transfer_id = ? AND from_user_id = ?", c.Params("transferId"), userId).First(&transfer).Error
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Ownership transfer not found",
+		})
+	}
+
+	err = closeTransfer(c, transfer, models.TransferCancelled, audit.OwnershipTransferCancelled)
+
+	return respondToTransfer(c, transfer, err, "Ownership transfer cancelled")
+}
+
+// Find the transfer in the :transferId param if it was offered to the
+// logged in user
+func findRecipientTransfer(c *fiber.Ctx) (models.OwnershipTransfer, bool) {
+	userId := c.Locals("userId").(string)
+
+	var transfer models.OwnershipTransfer
+	err := database.DB.Db.Where("transfer_id = ? AND to_user_id = ?", c.Params("transferId"), userId).First(&transfer).Error
+
+	return transfer, err == nil
+}
+
+// Mark an open transfer as declined or cancelled
+func closeTransfer(c *fiber.Ctx, transfer models.OwnershipTransfer, status string, action string) error {
+	return database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&transfer).
+			Where("status = ? AND expires_at > ?", models.TransferPending, time.Now()).
+			Updates(map[string]interface{}{"status": status, "responded_at": time.Now()})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errTransferClosed
+		}
+
+		return audit.Record(tx, transferAuditEntry(c, transfer, action))
+	})
+}
+
+func respondToTransfer(c *fiber.Ctx, transfer models.OwnershipTransfer, err error, message string) error {
+	if errors.Is(err, errTransferClosed) {
+		return c.Status(http.StatusConflict).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusConflict,
+			"message":    "This ownership transfer is no longer open",
+		})
+	}
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while updating the ownership transfer",
+		})
+	}
+
+	database.DB.Db.First(&transfer, "transfer_id = ?", transfer.TransferID)
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": message,
+		"data":    transferResponse(transfer),
+	})
+}
+
+func transferResponse(transfer models.OwnershipTransfer) fiber.Map {
+	return fiber.Map{
+		"transferId":  transfer.TransferID,
+		"orgId":       transfer.OrganisationID,
+		"fromUserId":  transfer.FromUserID,
+		"toUserId":    transfer.ToUserID,
+		"status":      transfer.Status,
+		"expiresAt":   transfer.ExpiresAt,
+		"respondedAt": transfer.RespondedAt,
+		"createdAt":   transfer.CreatedAt,
+	}
+}
+
+func transferAuditEntry(c *fiber.Ctx, transfer models.OwnershipTransfer, action string) audit.Entry {
+	return audit.FromRequest(c, action).
+		Org(transfer.OrganisationID).
+		Target("ownership_transfer", transfer.TransferID).
+		With(map[string]interface{}{
+			"fromUserId": transfer.FromUserID,
+			"toUserId":   transfer.ToUserID,
+		})
+}
@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/pagination"
+)
+
+// How each list endpoint can be sorted and paged
+
+var userPagination = pagination.Options[models.User]{
+	Sorts: map[string]pagination.Sort[models.User]{
+		"created_at": pagination.TimeSort("users.created_at", func(u models.User) time.Time { return u.CreatedAt }),
+		"first_name": pagination.StringSort("users.first_name", func(u models.User) string { return u.FirstName }),
+		"last_name":  pagination.StringSort("users.last_name", func(u models.User) string { return u.LastName }),
+		"email":      pagination.StringSort("users.email", func(u models.User) string { return u.Email }),
+	},
+	DefaultSort:   "created_at",
+	TieBreaker:    pagination.StringSort("users.user_id", func(u models.User) string { return u.UserID.String() }),
+	CreatedColumn: "users.created_at",
+}
+
+var organisationPagination = pagination.Options[models.Organisation]{
+	Sorts: map[string]pagination.Sort[models.Organisation]{
+		"created_at": pagination.TimeSort("organisations.created_at", func(o models.Organisation) time.Time { return o.CreatedAt }),
+		"name":       pagination.StringSort("organisations.name", func(o models.Organisation) string { return o.Name }),
+	},
+	DefaultSort:   "created_at",
+	TieBreaker:    pagination.StringSort("organisations.id", func(o models.Organisation) string { return o.ID.String() }),
+	CreatedColumn: "organisations.created_at",
+}
+
+var apiKeyPagination = pagination.Options[models.ApiKey]{
+	Sorts: map[string]pagination.Sort[models.ApiKey]{
+		"created_at": pagination.TimeSort("api_keys.created_at", func(k models.ApiKey) time.Time { return k.CreatedAt }),
+		"name":       pagination.StringSort("api_keys.name", func(k models.ApiKey) string { return k.Name }),
+	},
+	DefaultSort:   "-created_at",
+	TieBreaker:    pagination.StringSort("api_keys.key_id", func(k models.ApiKey) string { return k.KeyID.String() }),
+	CreatedColumn: "api_keys.created_at",
+}
+
+var sessionPagination = pagination.Options[models.Session]{
+	Sorts: map[string]pagination.Sort[models.Session]{
+		"created_at":   pagination.TimeSort("sessions.created_at", func(s models.Session) time.Time { return s.CreatedAt }),
+		"last_seen_at": pagination.TimeSort("sessions.last_seen_at", func(s models.Session) time.Time { return s.LastSeenAt }),
+	},
+	DefaultSort:   "-last_seen_at",
+	TieBreaker:    pagination.StringSort("sessions.session_id", func(s models.Session) string { return s.SessionID.String() }),
+	CreatedColumn: "sessions.created_at",
+}
+
+var memberPagination = pagination.Options[memberRow]{
+	Sorts: map[string]pagination.Sort[memberRow]{
+		"joined_at":  pagination.TimeSort("user_organizations.created_at", func(m memberRow) time.Time { return m.JoinedAt }),
+		"first_name": pagination.StringSort("users.first_name", func(m memberRow) string { return m.FirstName }),
+		"last_name":  pagination.StringSort("users.last_name", func(m memberRow) string { return m.LastName }),
+		"email":      pagination.StringSort("users.email", func(m memberRow) string { return m.Email }),
+	},
+	DefaultSort:   "joined_at",
+	TieBreaker:    pagination.StringSort("users.user_id", func(m memberRow) string { return m.UserID.String() }),
+	CreatedColumn: "user_organizations.created_at",
+}
+
+var teamPagination = pagination.Options[models.Team]{
+	Sorts: map[string]pagination.Sort[models.Team]{
+		"created_at": pagination.TimeSort("teams.created_at", func(t models.Team) time.Time { return t.CreatedAt }),
+		"name":       pagination.StringSort("teams.name", func(t models.Team) string { return t.Name }),
+	},
+	DefaultSort:   "name",
+	TieBreaker:    pagination.StringSort("teams.team_id", func(t models.Team) string { return t.TeamID.String() }),
+	CreatedColumn: "teams.created_at",
+}
+
+var auditEventPagination = pagination.Options[models.AuditEvent]{
+	Sorts: map[string]pagination.Sort[models.AuditEvent]{
+		"created_at": pagination.TimeSort("audit_events.created_at", func(e models.AuditEvent) time.Time { return e.CreatedAt }),
+	},
+	DefaultSort:   "-created_at",
+	TieBreaker:    pagination.StringSort("audit_events.event_id", func(e models.AuditEvent) string { return e.EventID.String() }),
+	CreatedColumn: "audit_events.created_at",
+}
+
+var trashedUserPagination = pagination.Options[models.User]{
+	Sorts: map[string]pagination.Sort[models.User]{
+		"deleted_at": pagination.TimeSort("users.deleted_at", func(u models.User) time.Time { return u.DeletedAt.Time }),
+		"email":      pagination.StringSort("users.email", func(u models.User) string { return u.Email }),
+	},
+	DefaultSort:   "-deleted_at",
+	TieBreaker:    pagination.StringSort("users.user_id", func(u models.User) string { return u.UserID.String() }),
+	CreatedColumn: "users.created_at",
+}
+
+var trashedOrganisationPagination = pagination.Options[models.Organisation]{
+	Sorts: map[string]pagination.Sort[models.Organisation]{
+		"deleted_at": pagination.TimeSort("organisations.deleted_at", func(o models.Organisation) time.Time { return o.DeletedAt.Time }),
+		"name":       pagination.StringSort("organisations.name", func(o models.Organisation) string { return o.Name }),
+	},
+	DefaultSort:   "-deleted_at",
+	TieBreaker:    pagination.StringSort("organisations.id", func(o models.Organisation) string { return o.ID.String() }),
+	CreatedColumn: "organisations.created_at",
+}
+
+var paymentPagination = pagination.Options[models.Payment]{
+	Sorts: map[string]pagination.Sort[models.Payment]{
+		"created_at": pagination.TimeSort("payments.created_at", func(p models.Payment) time.Time { return p.CreatedAt }),
+	},
+	DefaultSort:   "-created_at",
+	TieBreaker:    pagination.StringSort("payments.payment_id", func(p models.Payment) string { return p.PaymentID.String() }),
+	CreatedColumn: "payments.created_at",
+}
+
+var invoicePagination = pagination.Options[models.Invoice]{
+	Sorts: map[string]pagination.Sort[models.Invoice]{
+		"created_at": pagination.TimeSort("invoices.created_at", func(i models.Invoice) time.Time { return i.CreatedAt }),
+	},
+	DefaultSort:   "-created_at",
+	TieBreaker:    pagination.StringSort("invoices.invoice_id", func(i models.Invoice) string { return i.InvoiceID.String() }),
+	CreatedColumn: "invoices.created_at",
+}
+
+var webhookDeliveryPagination = pagination.Options[models.WebhookDelivery]{
+	Sorts: map[string]pagination.Sort[models.WebhookDelivery]{
+		"created_at": pagination.TimeSort("webhook_deliveries.created_at", func(d models.WebhookDelivery) time.Time { return d.CreatedAt }),
+	},
+	DefaultSort:   "-created_at",
+	TieBreaker:    pagination.StringSort("webhook_deliveries.delivery_id", func(d models.WebhookDelivery) string { return d.DeliveryID.String() }),
+	CreatedColumn: "webhook_deliveries.created_at",
+}
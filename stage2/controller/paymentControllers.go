@@ -0,0 +1,520 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/pagination"
+	"github.com/mryan-3/hng11/stage2/payments"
+	"github.com/mryan-3/hng11/stage2/policy"
+	"github.com/mryan-3/hng11/stage2/validation"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const providerTimeout = 30 * time.Second
+
+// Take a payment on behalf of an organisation. The amount is a decimal
+// in the currency's major unit and is stored in minor units.
+// route POST /api/payments/create
+func CreatePayment(c *fiber.Ctx) error {
+	type ReqBody struct {
+		OrgID       string      `json:"orgId" validate:"required,uuid"`
+		Amount      json.Number `json:"amount" validate:"required"`
+		Currency    string      `json:"currency" validate:"required,len=3"`
+		Provider    string      `json:"provider" validate:"required,max=50"`
+		Description string      `json:"description" validate:"max=255"`
+	}
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	currency, err := payments.ParseCurrency(body.Currency)
+	if err != nil && body.Currency != "" {
+		validationErrors = append(validationErrors, validation.ValidationError{Field: "Currency", Message: "Currency is not supported"})
+	}
+
+	var amount int64
+	if err == nil && body.Amount != "" {
+		if amount, err = payments.ToMinor(body.Amount.String(), currency); err != nil {
+			validationErrors = append(validationErrors, amountValidationError(err))
+		}
+	}
+
+	provider, err := payments.Lookup(body.Provider)
+	if err != nil && body.Provider != "" {
+		validationErrors = append(validationErrors, validation.ValidationError{Field: "Provider", Message: "Provider is not available"})
+	}
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	userId := c.Locals("userId").(string)
+	if !hasOrgPermission(body.OrgID, userId, policy.PaymentCreate) {
+		return orgForbidden(c, body.OrgID, "You need the "+policy.PaymentCreate+" permission")
+	}
+
+	customer, err := currentUser(c)
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "User not found",
+		})
+	}
+
+	payment := models.Payment{
+		PaymentID:      uuid.New(),
+		UserID:         customer.UserID,
+		OrganisationID: uuid.MustParse(body.OrgID),
+		Provider:       provider.Name(),
+		Amount:         amount,
+		Currency:       currency,
+		Status:         models.PaymentPending,
+		Description:    body.Description,
+	}
+
+	// Record the payment before asking the provider for it, so there is
+	// never money taken that we have no row for
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&payment).Error; err != nil {
+			return err
+		}
+
+		return audit.Record(tx, paymentAuditEntry(c, payment, audit.PaymentCreated))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while creating the payment",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), providerTimeout)
+	defer cancel()
+
+	intent, providerErr := provider.CreateIntent(ctx, payments.IntentRequest{
+		PaymentID:   payment.PaymentID.String(),
+		Amount:      payment.Amount,
+		Currency:    payment.Currency,
+		Description: payment.Description,
+		CustomerID:  customer.UserID.String(),
+	})
+
+	payment.Status = models.PaymentFailed
+	if providerErr == nil {
+		payment.Status, payment.ProviderRef = intent.Status, intent.Reference
+	}
+
+	err = database.DB.Db.Model(&payment).Updates(map[string]interface{}{
+		"status":       payment.Status,
+		"provider_ref": payment.ProviderRef,
+	}).Error
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while creating the payment",
+		})
+	}
+
+	if providerErr != nil {
+		return c.Status(http.StatusBadGateway).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusBadGateway,
+			"message":    "The payment provider could not take the payment",
+		})
+	}
+
+	response := paymentResponse(payment, customer)
+	if intent.ClientSecret != "" {
+		response["clientSecret"] = intent.ClientSecret
+	}
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Payment created successfully",
+		"data":    response,
+	})
+}
+
+// Get a payment. Visible to the user who made it and to members who
+// manage the organisation's payments.
+// route GET /api/payments/:paymentId
+func GetPayment(c *fiber.Ctx) error {
+	payment, ok := findVisiblePayment(c)
+	if !ok {
+		return paymentNotFound(c)
+	}
+
+	var customer models.User
+	database.DB.Db.Unscoped().Where("user_id = ?", payment.UserID).First(&customer)
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Payment found successfully",
+		"data":    paymentResponse(payment, customer),
+	})
+}
+
+// List an organisation's payments, newest first
+// route GET /api/organisations/:orgId/payments
+func GetOrgPayments(c *fiber.Ctx) error {
+	page, err := pagination.Parse(c, paymentPagination)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    err.Error(),
+		})
+	}
+
+	query := database.DB.Db.Where("organisation_id = ?", c.Params("orgId"))
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var list []models.Payment
+	if err := query.Scopes(page.Scope).Find(&list).Error; err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while fetching payments",
+		})
+	}
+
+	list, meta := page.Trim(list)
+
+	userIds := []uuid.UUID{}
+	for _, payment := range list {
+		userIds = append(userIds, payment.UserID)
+	}
+
+	var users []models.User
+	database.DB.Db.Unscoped().Where("user_id IN ?", userIds).Find(&users)
+
+	customers := map[uuid.UUID]models.User{}
+	for _, user := range users {
+		customers[user.UserID] = user
+	}
+
+	data := []fiber.Map{}
+	for _, payment := range list {
+		data = append(data, paymentResponse(payment, customers[payment.UserID]))
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Payments found",
+		"data": fiber.Map{
+			"payments": data,
+		},
+		"meta": meta,
+	})
+}
+
+// Refund some or all of what is left of a payment. Without an amount the
+// rest of the payment is refunded.
+// route POST /api/payments/:paymentId/refund
+func RefundPayment(c *fiber.Ctx) error {
+	type ReqBody struct {
+		Amount json.Number `json:"amount"`
+	}
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil && len(c.Body()) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	var payment models.Payment
+	if err := database.DB.Db.Where("payment_id = ?", c.Params("paymentId")).First(&payment).Error; err != nil {
+		return paymentNotFound(c)
+	}
+
+	userId := c.Locals("userId").(string)
+	if !hasOrgPermission(payment.OrganisationID.String(), userId, policy.PaymentManage) {
+		return paymentNotFound(c)
+	}
+
+	amount := payment.Amount - payment.RefundedAmount
+	if body.Amount != "" {
+		var err error
+		if amount, err = payments.ToMinor(body.Amount.String(), payment.Currency); err != nil {
+			return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+				"errors": []validation.ValidationError{amountValidationError(err)},
+			})
+		}
+	}
+
+	provider, err := payments.Lookup(payment.Provider)
+	if err != nil {
+		return c.Status(http.StatusBadGateway).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusBadGateway,
+			"message":    "The payment's provider is not available",
+		})
+	}
+
+	// Record the refund as pending before asking the provider for it
+	refund, err := startRefund(payment, amount)
+
+	if errors.Is(err, payments.ErrNotRefundable) || errors.Is(err, payments.ErrRefundTooLarge) {
+		return c.Status(http.StatusConflict).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusConflict,
+			"message":    "The payment can't be refunded by this amount",
+		})
+	}
+
+	if errors.Is(err, errRefundInProgress) {
+		return c.Status(http.StatusConflict).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusConflict,
+			"message":    "Another refund of this payment is in progress. Retry it to finish it.",
+		})
+	}
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while refunding the payment",
+		})
+	}
+
+	// The provider isn't called inside a transaction. The idempotency key
+	// means a retry of a refund that wasn't settled gets the same refund
+	// back rather than a second one.
+	ctx, cancel := context.WithTimeout(c.Context(), providerTimeout)
+	result, providerErr := provider.Refund(ctx, payments.RefundRequest{
+		Reference:      payment.ProviderRef,
+		Amount:         refund.Amount,
+		IdempotencyKey: refund.IdempotencyKey,
+	})
+	cancel()
+
+	if providerErr != nil {
+		database.DB.Db.Model(&refund).Where("status = ?", models.RefundPending).Updates(map[string]interface{}{
+			"status": models.RefundFailed,
+			"error":  providerErr.Error(),
+		})
+
+		if errors.Is(providerErr, payments.ErrNotRefundable) || errors.Is(providerErr, payments.ErrRefundTooLarge) {
+			return c.Status(http.StatusConflict).JSON(&fiber.Map{
+				"status":     "error",
+				"statusCode": http.StatusConflict,
+				"message":    "The payment can't be refunded by this amount",
+			})
+		}
+
+		return c.Status(http.StatusBadGateway).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusBadGateway,
+			"message":    "The payment provider could not refund the payment",
+		})
+	}
+
+	payment, err = settleRefund(c, refund, result)
+	if err != nil {
+		// The refund stays pending; retrying the request settles it
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while recording the refund",
+		})
+	}
+
+	var customer models.User
+	database.DB.Db.Unscoped().Where("user_id = ?", payment.UserID).First(&customer)
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Payment refunded successfully",
+		"data":    paymentResponse(payment, customer),
+	})
+}
+
+var errRefundInProgress = errors.New("another refund of the payment is in progress")
+
+// Record a pending refund of a payment, or pick up the one a retried
+// request left unsettled. The payment is held so two refunds can't both
+// take what is left.
+func startRefund(payment models.Payment, amount int64) (models.PaymentRefund, error) {
+	var refund models.PaymentRefund
+
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, "payment_id = ?", payment.PaymentID).Error; err != nil {
+			return err
+		}
+
+		if payment.Status != models.PaymentSucceeded {
+			return payments.ErrNotRefundable
+		}
+		if amount <= 0 || amount > payment.Amount-payment.RefundedAmount {
+			return payments.ErrRefundTooLarge
+		}
+
+		key := payments.RefundKey(payment.PaymentID, payment.RefundedAmount, amount)
+
+		var pending []models.PaymentRefund
+		if err := tx.Where("payment_id = ? AND status = ?", payment.PaymentID, models.RefundPending).Find(&pending).Error; err != nil {
+			return err
+		}
+		for _, other := range pending {
+			if other.IdempotencyKey != key {
+				return errRefundInProgress
+			}
+		}
+
+		err := tx.Where("idempotency_key = ?", key).First(&refund).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			refund = models.PaymentRefund{
+				RefundID:       uuid.New(),
+				PaymentID:      payment.PaymentID,
+				Amount:         amount,
+				Currency:       payment.Currency,
+				IdempotencyKey: key,
+				Status:         models.RefundPending,
+			}
+			return tx.Create(&refund).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		// A failed attempt is tried again with the same key
+		refund.Status = models.RefundPending
+		return tx.Model(&refund).Updates(map[string]interface{}{"status": refund.Status, "error": ""}).Error
+	})
+
+	return refund, err
+}
+
+// Apply a refund the provider made to its payment. Settling a refund that
+// is already settled changes nothing.
+func settleRefund(c *fiber.Ctx, refund models.PaymentRefund, result payments.Refund) (models.Payment, error) {
+	var payment models.Payment
+
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, refund.PaymentID).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&refund, "refund_id = ?", refund.RefundID).Error; err != nil {
+			return err
+		}
+		if refund.Status == models.RefundSucceeded {
+			return nil
+		}
+
+		err := tx.Model(&refund).Updates(map[string]interface{}{
+			"status":       models.RefundSucceeded,
+			"provider_ref": result.Reference,
+			"error":        "",
+			"settled_at":   time.Now(),
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		payment.RefundedAmount += result.Amount
+		if payment.RefundedAmount >= payment.Amount {
+			payment.Status = models.PaymentRefunded
+		}
+
+		err = tx.Model(&payment).Updates(map[string]interface{}{
+			"refunded_amount": payment.RefundedAmount,
+			"status":          payment.Status,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(tx, paymentAuditEntry(c, payment, audit.PaymentRefunded).With(map[string]interface{}{
+			"amount":    result.Amount,
+			"currency":  payment.Currency,
+			"reference": result.Reference,
+			"refundId":  refund.RefundID,
+		}))
+	})
+
+	return payment, err
+}
+
+func findVisiblePayment(c *fiber.Ctx) (models.Payment, bool) {
+	var payment models.Payment
+	if err := database.DB.Db.Where("payment_id = ?", c.Params("paymentId")).First(&payment).Error; err != nil {
+		return payment, false
+	}
+
+	userId := c.Locals("userId").(string)
+	if payment.UserID.String() == userId {
+		return payment, true
+	}
+
+	return payment, hasOrgPermission(payment.OrganisationID.String(), userId, policy.PaymentManage)
+}
+
+func amountValidationError(err error) validation.ValidationError {
+	message := "Amount must be a positive number"
+	if errors.Is(err, payments.ErrTooPrecise) {
+		message = "Amount has more decimal places than the currency allows"
+	}
+
+	return validation.ValidationError{Field: "Amount", Message: message}
+}
+
+func paymentNotFound(c *fiber.Ctx) error {
+	return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+		"status":     "error",
+		"statusCode": http.StatusNotFound,
+		"message":    "Payment not found",
+	})
+}
+
+// Amounts are given as decimals in the major unit, as they were asked for
+func paymentResponse(payment models.Payment, customer models.User) fiber.Map {
+	return fiber.Map{
+		"id":             payment.PaymentID,
+		"orgId":          payment.OrganisationID,
+		"amount":         json.Number(payments.FormatMinor(payment.Amount, payment.Currency)),
+		"refundedAmount": json.Number(payments.FormatMinor(payment.RefundedAmount, payment.Currency)),
+		"currency":       payment.Currency,
+		"provider":       payment.Provider,
+		"status":         payment.Status,
+		"description":    payment.Description,
+		"customer":       userResponse(customer),
+		"created_at":     payment.CreatedAt,
+	}
+}
+
+func paymentAuditEntry(c *fiber.Ctx, payment models.Payment, action string) audit.Entry {
+	return audit.FromRequest(c, action).
+		Org(payment.OrganisationID).
+		Target("payment", payment.PaymentID).
+		With(map[string]interface{}{
+			"amount":   payment.Amount,
+			"currency": payment.Currency,
+			"provider": payment.Provider,
+		})
+}
@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/payments"
+)
+
+// Receive a payment provider's webhook. The body must be signed with
+// PAYMENT_WEBHOOK_SECRET. Each event is stored by its id before it is
+// applied, so a redelivered event is acknowledged without doing anything
+// once it has been processed.
+// route POST /api/payments/webhook
+func PaymentWebhook(c *fiber.Ctx) error {
+	secret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+	if secret == "" {
+		return c.Status(http.StatusServiceUnavailable).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusServiceUnavailable,
+			"message":    "Payment webhooks are not configured",
+		})
+	}
+
+	body := c.Body()
+
+	err := payments.VerifySignature(c.Get(payments.HeaderWebhookSignature), body, []byte(secret), webhookTolerance(), time.Now())
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    err.Error(),
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	event, err := payments.ParseEvent(body)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    err.Error(),
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	stored, created, err := payments.ReceiveEvent(database.DB.Db, event, body)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusInternalServerError,
+			"message":    "Failed to store webhook event",
+		})
+	}
+
+	// An event that was stored but never processed, because processing
+	// failed the first time, is processed on redelivery
+	if !created && stored.Status != models.WebhookReceived {
+		return c.Status(http.StatusOK).JSON(&fiber.Map{
+			"status":  "success",
+			"message": "Event already received",
+			"data":    stored,
+		})
+	}
+
+	stored, err = payments.ProcessEvent(database.DB.Db, stored)
+	if err != nil {
+		// The event is stored, so it can be replayed once this is fixed
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusInternalServerError,
+			"message":    "Failed to process webhook event",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(&fiber.Map{
+		"status":  "success",
+		"message": "Event processed",
+		"data":    stored,
+	})
+}
+
+func webhookTolerance() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("PAYMENT_WEBHOOK_TOLERANCE_SECONDS"))
+	if err != nil || seconds <= 0 {
+		return payments.DefaultSignatureTolerance
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,347 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/policy"
+	"github.com/mryan-3/hng11/stage2/validation"
+	"gorm.io/gorm"
+)
+
+// Get the logged in user's role and permissions in an organisation,
+// so clients can hide what the user can't do
+// route GET /api/organisations/:orgId/permissions/me
+func GetMyPermissions(c *fiber.Ctx) error {
+	role, permissions, err := policy.OrgPermissions(database.DB.Db, c.Params("orgId"), c.Locals("userId").(string))
+
+	if errors.Is(err, policy.ErrOrgNotFound) {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Organisation not found",
+		})
+	}
+
+	if errors.Is(err, policy.ErrNotMember) {
+		return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusForbidden,
+			"message":    "You are not a member of this organisation",
+		})
+	}
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while checking permissions",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Permissions found",
+		"data": fiber.Map{
+			"role":        role,
+			"permissions": permissions.List(),
+		},
+	})
+}
+
+// List the roles members of an organisation can be given, built in
+// roles first
+// route GET /api/organisations/:orgId/roles
+func GetOrgRoles(c *fiber.Ctx) error {
+	var roles []models.OrgRole
+	if err := database.DB.Db.Where("organisation_id = ?", c.Params("orgId")).Order("name").Find(&roles).Error; err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while fetching roles",
+		})
+	}
+
+	data := []fiber.Map{}
+	for _, name := range []string{models.RoleAdmin, models.RoleMember} {
+		permissions, _ := policy.BuiltInRole(name)
+		data = append(data, fiber.Map{
+			"name":        name,
+			"builtIn":     true,
+			"permissions": permissions,
+		})
+	}
+	for _, role := range roles {
+		data = append(data, orgRoleResponse(role))
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Roles found",
+		"data": fiber.Map{
+			"roles":       data,
+			"permissions": policy.AllPermissions,
+		},
+	})
+}
+
+// Define a custom role for an organisation. The role can only have
+// permissions the caller holds.
+// route POST /api/organisations/:orgId/roles
+func CreateOrgRole(c *fiber.Ctx) error {
+	type ReqBody struct {
+		Name        string   `json:"name" validate:"required,max=50"`
+		Description string   `json:"description" validate:"max=255"`
+		Permissions []string `json:"permissions" validate:"required"`
+	}
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+	validationErrors = append(validationErrors, validateOrgRole(&body.Name, body.Permissions)...)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	orgId, err := uuid.Parse(c.Params("orgId"))
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Organisation not found",
+		})
+	}
+
+	caller, _ := c.Locals("permissions").(policy.Set)
+	if err := policy.CanDefineRole(caller, policy.Set{}, policy.NewSet(body.Permissions...)); err != nil {
+		return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusForbidden,
+			"message":    err.Error(),
+		})
+	}
+
+	var count int64
+	database.DB.Db.Model(&models.OrgRole{}).Where("organisation_id = ? AND name = ?", orgId, body.Name).Count(&count)
+
+	if count > 0 {
+		return c.Status(http.StatusConflict).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusConflict,
+			"message":    "A role with this name already exists",
+		})
+	}
+
+	role := models.OrgRole{
+		OrganisationID: orgId,
+		Name:           body.Name,
+		Description:    body.Description,
+		Permissions:    strings.Join(body.Permissions, " "),
+	}
+
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&role).Error; err != nil {
+			return err
+		}
+
+		return audit.Record(tx, roleAuditEntry(c, role, audit.RoleCreated))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while creating the role",
+		})
+	}
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Role created successfully",
+		"data":    orgRoleResponse(role),
+	})
+}
+
+// Change a custom role's description or permissions. Roles can't be
+// renamed because memberships refer to them by name. The caller must hold
+// the role's permissions, both before and after the change.
+// route PUT /api/organisations/:orgId/roles/:roleId
+func UpdateOrgRole(c *fiber.Ctx) error {
+	type ReqBody struct {
+		Description *string  `json:"description" validate:"omitempty,max=255"`
+		Permissions []string `json:"permissions"`
+	}
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+	if body.Permissions != nil {
+		validationErrors = append(validationErrors, validateOrgRole(nil, body.Permissions)...)
+	}
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	var role models.OrgRole
+	if err := database.DB.Db.Where("organisation_id = ? AND role_id = ?", c.Params("orgId"), c.Params("roleId")).First(&role).Error; err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Role not found",
+		})
+	}
+
+	if body.Permissions != nil {
+		caller, _ := c.Locals("permissions").(policy.Set)
+		from := policy.NewSet(role.PermissionList()...)
+		if err := policy.CanDefineRole(caller, from, policy.NewSet(body.Permissions...)); err != nil {
+			return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+				"status":     "error",
+				"statusCode": http.StatusForbidden,
+				"message":    err.Error(),
+			})
+		}
+	}
+
+	updates := map[string]interface{}{}
+	if body.Description != nil {
+		updates["description"] = *body.Description
+	}
+	if body.Permissions != nil {
+		updates["permissions"] = strings.Join(body.Permissions, " ")
+	}
+
+	if len(updates) > 0 {
+		err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&role).Updates(updates).Error; err != nil {
+				return err
+			}
+
+			return audit.Record(tx, roleAuditEntry(c, role, audit.RoleUpdated))
+		})
+
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+				"status":  "error",
+				"message": "An error occurred while updating the role",
+			})
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Role updated successfully",
+		"data":    orgRoleResponse(role),
+	})
+}
+
+// Delete a custom role that no member has
+// route DELETE /api/organisations/:orgId/roles/:roleId
+func DeleteOrgRole(c *fiber.Ctx) error {
+	var role models.OrgRole
+	if err := database.DB.Db.Where("organisation_id = ? AND role_id = ?", c.Params("orgId"), c.Params("roleId")).First(&role).Error; err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Role not found",
+		})
+	}
+
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		var members int64
+		if err := tx.Model(&models.Membership{}).
+			Where("organisation_id = ? AND role = ?", role.OrganisationID, role.Name).
+			Count(&members).Error; err != nil {
+			return err
+		}
+		if members > 0 {
+			return errRoleInUse
+		}
+
+		if err := tx.Unscoped().Delete(&role).Error; err != nil {
+			return err
+		}
+
+		return audit.Record(tx, roleAuditEntry(c, role, audit.RoleDeleted))
+	})
+
+	if errors.Is(err, errRoleInUse) {
+		return c.Status(http.StatusConflict).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusConflict,
+			"message":    "Give members with this role another role first",
+		})
+	}
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while deleting the role",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Role deleted successfully",
+	})
+}
+
+var errRoleInUse = errors.New("role is in use")
+
+// Check a custom role's name, when given, and its permissions
+func validateOrgRole(name *string, permissions []string) []validation.ValidationError {
+	var errs []validation.ValidationError
+
+	if name != nil && policy.IsBuiltInRole(*name) {
+		errs = append(errs, validation.ValidationError{Field: "Name", Message: "Name can't be a built in role"})
+	}
+
+	for _, permission := range permissions {
+		if !policy.IsPermission(permission) {
+			errs = append(errs, validation.ValidationError{Field: "Permissions", Message: permission + " is not a permission"})
+		}
+	}
+
+	return errs
+}
+
+func orgRoleResponse(role models.OrgRole) fiber.Map {
+	return fiber.Map{
+		"roleId":      role.RoleID,
+		"name":        role.Name,
+		"description": role.Description,
+		"builtIn":     false,
+		"permissions": role.PermissionList(),
+		"createdAt":   role.CreatedAt,
+	}
+}
+
+func roleAuditEntry(c *fiber.Ctx, role models.OrgRole, action string) audit.Entry {
+	return audit.FromRequest(c, action).
+		Org(role.OrganisationID).
+		Target("role", role.RoleID).
+		With(map[string]interface{}{"name": role.Name, "permissions": role.PermissionList()})
+}
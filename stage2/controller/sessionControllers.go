@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/pagination"
+	"github.com/mryan-3/hng11/stage2/utils"
+	"gorm.io/gorm"
+)
+
+// List the logged in user's active sessions
+// route GET /api/sessions
+func GetSessions(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	currentSessionId, _ := c.Locals("sessionId").(string)
+
+	page, err := pagination.Parse(c, sessionPagination)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    err.Error(),
+		})
+	}
+
+	var sessions []models.Session
+	err = database.DB.Db.
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userId, time.Now()).
+		Scopes(page.Scope).
+		Find(&sessions).Error
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while fetching sessions",
+		})
+	}
+
+	sessions, meta := page.Trim(sessions)
+
+	sessionsResponse := []fiber.Map{}
+	for _, session := range sessions {
+		sessionsResponse = append(sessionsResponse, fiber.Map{
+			"sessionId":  session.SessionID,
+			"userAgent":  session.UserAgent,
+			"ip":         session.IP,
+			"createdAt":  session.CreatedAt,
+			"lastSeenAt": session.LastSeenAt,
+			"expiresAt":  session.ExpiresAt,
+			"current":    session.SessionID.String() == currentSessionId,
+		})
+	}
+
+	response := fiber.Map{
+		"status":  "success",
+		"message": "Sessions found",
+		"data": fiber.Map{
+			"sessions": sessionsResponse,
+		},
+		"meta": meta,
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// Sign out a session, e.g. a lost device
+// route DELETE /api/sessions/:id
+func RevokeSession(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+
+	var session models.Session
+	err := database.DB.Db.Where("session_id = ? AND user_id = ?", c.Params("id"), userId).First(&session).Error
+
+	if err != nil || !session.Active() {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Session not found",
+		})
+	}
+
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&session).Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return audit.Record(tx, audit.FromRequest(c, audit.SessionRevoked).Target("session", session.SessionID))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while revoking the session",
+		})
+	}
+
+	// Signing out the current session also clears the cookie
+	if session.SessionID.String() == c.Locals("sessionId") {
+		clearUserCookie(c)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Session revoked successfully",
+	})
+}
+
+// Get the CSRF token cookie authenticated clients must send in the
+// X-CSRF-Token header on POST, PUT and DELETE requests
+// route GET /api/csrf-token
+func GetCsrfToken(c *fiber.Ctx) error {
+	sessionId := c.Locals("sessionId").(string)
+
+	response := fiber.Map{
+		"status":  "success",
+		"message": "CSRF token generated",
+		"data": fiber.Map{
+			"csrfToken": utils.CsrfToken(sessionId),
+		},
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// Record a new session for the request's device and sign a token for it
+func startSession(c *fiber.Ctx, user models.User) (string, error) {
+	now := time.Now()
+	session := models.Session{
+		UserID:     user.UserID,
+		UserAgent:  truncate(c.Get(fiber.HeaderUserAgent), 512),
+		IP:         c.IP(),
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(utils.JwtTokenLifetime),
+	}
+
+	if err := database.DB.Db.Create(&session).Error; err != nil {
+		return "", err
+	}
+
+	return utils.SignSessionJwtToken(user.UserID.String(), session.SessionID.String(), session.ExpiresAt)
+}
+
+func truncate(text string, max int) string {
+	if len(text) > max {
+		return text[:max]
+	}
+	return text
+}
@@ -0,0 +1,228 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/billing"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/payments"
+	"github.com/mryan-3/hng11/stage2/validation"
+	"gorm.io/gorm"
+)
+
+// List the plans organisations can subscribe to
+// route GET /api/plans
+func GetPlans(c *fiber.Ctx) error {
+	list := []fiber.Map{}
+	for _, plan := range billing.Plans() {
+		list = append(list, planResponse(plan))
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Plans retrieved successfully",
+		"data":    list,
+	})
+}
+
+// Get an organisation's subscription and what has been charged on it
+// this period
+// route GET /api/organisations/:orgId/subscription
+func GetSubscription(c *fiber.Ctx) error {
+	org, ok := findOrganisation(c)
+	if !ok {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Organisation not found",
+		})
+	}
+
+	var subscription models.Subscription
+	var charges []models.SubscriptionCharge
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if subscription, err = billing.Lock(tx, org.ID, time.Now()); err != nil {
+			return err
+		}
+
+		return tx.Where("subscription_id = ? AND period_end = ?", subscription.SubscriptionID, subscription.CurrentPeriodEnd).
+			Order("created_at").
+			Find(&charges).Error
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while fetching the subscription",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Subscription retrieved successfully",
+		"data":    subscriptionResponse(subscription, charges),
+	})
+}
+
+// Move an organisation to another plan. What is left of the period is
+// credited at the old plan's price and charged at the new one's.
+// route PUT /api/organisations/:orgId/subscription
+func ChangeSubscription(c *fiber.Ctx) error {
+	type ReqBody struct {
+		PlanID string `json:"planId" validate:"required"`
+	}
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	plan, err := billing.LookupPlan(body.PlanID)
+	if err != nil && body.PlanID != "" {
+		validationErrors = append(validationErrors, validation.ValidationError{Field: "PlanID", Message: "PlanID must be one of the plans"})
+	}
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	org, ok := findOrganisation(c)
+	if !ok {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Organisation not found",
+		})
+	}
+
+	var subscription models.Subscription
+	var charges []models.SubscriptionCharge
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		var err error
+		if subscription, err = billing.Lock(tx, org.ID, now); err != nil {
+			return err
+		}
+
+		previous := subscription.PlanID
+		if previous == plan.ID {
+			return nil
+		}
+
+		if charges, err = billing.ChangePlan(tx, &subscription, plan, org.OwnerID, now); err != nil {
+			return err
+		}
+
+		return audit.Record(tx, audit.FromRequest(c, audit.SubscriptionChanged).
+			Org(org.ID).
+			Target("subscription", subscription.SubscriptionID).
+			With(map[string]interface{}{"from": previous, "to": plan.ID, "seats": subscription.Seats}))
+	})
+
+	if errors.Is(err, billing.ErrTooManyMembers) || errors.Is(err, billing.ErrOrgLimitReached) {
+		return billingErrorResponse(c, err, plan)
+	}
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while changing the plan",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Plan changed successfully",
+		"data":    subscriptionResponse(subscription, charges),
+	})
+}
+
+// Find the organisation named by the orgId param
+func findOrganisation(c *fiber.Ctx) (models.Organisation, bool) {
+	var org models.Organisation
+	err := database.DB.Db.Where("id = ?", c.Params("orgId")).First(&org).Error
+
+	return org, err == nil
+}
+
+// Respond to a request refused by a plan's limits
+func billingErrorResponse(c *fiber.Ctx, err error, plan billing.Plan) error {
+	status := http.StatusPaymentRequired
+	var message string
+
+	switch {
+	case errors.Is(err, billing.ErrSeatLimitReached):
+		message = fmt.Sprintf("The %s plan allows at most %d members. Upgrade the plan to add more.", plan.Name, plan.MaxMembers)
+	case errors.Is(err, billing.ErrOrgLimitReached):
+		message = fmt.Sprintf("The %s plan allows a user to own at most %d organisations", plan.Name, plan.MaxOrgsPerUser)
+	case errors.Is(err, billing.ErrTooManyMembers):
+		status = http.StatusConflict
+		message = fmt.Sprintf("The %s plan allows at most %d members. Remove members before changing to it.", plan.Name, plan.MaxMembers)
+	default:
+		message = err.Error()
+	}
+
+	return c.Status(status).JSON(&fiber.Map{
+		"status":     "error",
+		"statusCode": status,
+		"message":    message,
+	})
+}
+
+func planResponse(plan billing.Plan) fiber.Map {
+	return fiber.Map{
+		"id":             plan.ID,
+		"name":           plan.Name,
+		"seatPrice":      json.Number(payments.FormatMinor(plan.SeatPrice, plan.Currency)),
+		"currency":       plan.Currency,
+		"maxMembers":     plan.MaxMembers,
+		"maxOrgsPerUser": plan.MaxOrgsPerUser,
+	}
+}
+
+func subscriptionResponse(subscription models.Subscription, charges []models.SubscriptionCharge) fiber.Map {
+	plan, _ := billing.LookupPlan(subscription.PlanID)
+
+	chargeList := []fiber.Map{}
+	for _, charge := range charges {
+		chargeList = append(chargeList, chargeResponse(charge))
+	}
+
+	return fiber.Map{
+		"id":                 subscription.SubscriptionID,
+		"orgId":              subscription.OrganisationID,
+		"plan":               planResponse(plan),
+		"seats":              subscription.Seats,
+		"currentPeriodStart": subscription.CurrentPeriodStart,
+		"currentPeriodEnd":   subscription.CurrentPeriodEnd,
+		"charges":            chargeList,
+	}
+}
+
+func chargeResponse(charge models.SubscriptionCharge) fiber.Map {
+	return fiber.Map{
+		"description": charge.Description,
+		"quantity":    charge.Quantity,
+		"amount":      json.Number(payments.FormatMinor(charge.Amount, charge.Currency)),
+		"currency":    charge.Currency,
+		"periodStart": charge.PeriodStart,
+		"periodEnd":   charge.PeriodEnd,
+	}
+}
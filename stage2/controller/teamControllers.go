@@ -0,0 +1,578 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/pagination"
+	"github.com/mryan-3/hng11/stage2/policy"
+	"github.com/mryan-3/hng11/stage2/validation"
+	"gorm.io/gorm"
+)
+
+// Create a team. Top level teams need the team:manage permission,
+// subteams can be added by anyone who manages the parent team.
+// route POST /api/organisations/:orgId/teams
+func CreateTeam(c *fiber.Ctx) error {
+	type ReqBody struct {
+		Name        string  `json:"name" validate:"required,max=255"`
+		Description string  `json:"description" validate:"max=255"`
+		ParentID    *string `json:"parentId" validate:"omitempty,uuid"`
+	}
+
+	orgId := c.Params("orgId")
+	userId := c.Locals("userId").(string)
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	org, err := uuid.Parse(orgId)
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Organisation not found",
+		})
+	}
+
+	team := models.Team{
+		OrganisationID: org,
+		Name:           body.Name,
+		Description:    body.Description,
+	}
+
+	if body.ParentID != nil {
+		parent, err := findTeam(orgId, *body.ParentID)
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+				"status":     "error",
+				"statusCode": http.StatusNotFound,
+				"message":    "Parent team not found",
+			})
+		}
+
+		if !canManageTeam(parent, userId) {
+			return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+				"status":     "error",
+				"statusCode": http.StatusForbidden,
+				"message":    "Only admins and leads of the parent team can add subteams",
+			})
+		}
+
+		team.ParentID = &parent.TeamID
+	} else if !hasOrgPermission(orgId, userId, policy.TeamManage) {
+		return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusForbidden,
+			"message":    "You need the " + policy.TeamManage + " permission to create top level teams",
+		})
+	}
+
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&team).Error; err != nil {
+			return err
+		}
+
+		return audit.Record(tx, teamAuditEntry(c, team, audit.TeamCreated, nil))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while creating the team",
+		})
+	}
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Team created successfully",
+		"data":    teamResponse(team),
+	})
+}
+
+// List an organisation's teams. ?parentId= lists the subteams of one
+// team and ?parentId=none lists the top level teams.
+// route GET /api/organisations/:orgId/teams
+func GetTeams(c *fiber.Ctx) error {
+	orgId := c.Params("orgId")
+
+	page, err := pagination.Parse(c, teamPagination)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    err.Error(),
+		})
+	}
+
+	query := database.DB.Db.Scopes(page.Scope).Where("organisation_id = ?", orgId)
+
+	switch parentId := c.Query("parentId"); parentId {
+	case "":
+	case "none":
+		query = query.Where("parent_id IS NULL")
+	default:
+		if _, err := uuid.Parse(parentId); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+				"status":     "Bad request",
+				"statusCode": http.StatusBadRequest,
+				"message":    "parentId must be a team id or none",
+			})
+		}
+		query = query.Where("parent_id = ?", parentId)
+	}
+
+	var teams []models.Team
+	if err := query.Find(&teams).Error; err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while fetching teams",
+		})
+	}
+
+	teams, meta := page.Trim(teams)
+
+	data := []fiber.Map{}
+	for _, team := range teams {
+		data = append(data, teamResponse(team))
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Teams found",
+		"data": fiber.Map{
+			"teams": data,
+		},
+		"meta": meta,
+	})
+}
+
+// Get a team with its members and the caller's effective role
+// route GET /api/organisations/:orgId/teams/:teamId
+func GetTeam(c *fiber.Ctx) error {
+	orgId := c.Params("orgId")
+	userId := c.Locals("userId").(string)
+
+	team, err := findTeam(orgId, c.Params("teamId"))
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Team not found",
+		})
+	}
+
+	type teamMemberRow struct {
+		UserID    uuid.UUID
+		FirstName string
+		LastName  string
+		Email     string
+		Role      string
+	}
+
+	var rows []teamMemberRow
+	err = database.DB.Db.Table("team_members").
+		Select("users.user_id, users.first_name, users.last_name, users.email, team_members.role").
+		Joins("JOIN users ON users.user_id = team_members.user_id AND users.deleted_at IS NULL").
+		Where("team_members.team_id = ?", team.TeamID).
+		Order("team_members.created_at").
+		Scan(&rows).Error
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while fetching the team",
+		})
+	}
+
+	members := []fiber.Map{}
+	for _, row := range rows {
+		members = append(members, fiber.Map{
+			"userId":    row.UserID,
+			"firstName": row.FirstName,
+			"lastName":  row.LastName,
+			"email":     row.Email,
+			"role":      row.Role,
+		})
+	}
+
+	data := teamResponse(team)
+	data["members"] = members
+	data["yourRole"] = teamRole(team, userId)
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Team found",
+		"data":    data,
+	})
+}
+
+// Rename a team or move it. An empty parentId moves it to the top level,
+// which needs the team:manage permission.
+// route PUT /api/organisations/:orgId/teams/:teamId
+func UpdateTeam(c *fiber.Ctx) error {
+	type ReqBody struct {
+		Name        *string `json:"name" validate:"omitempty,min=1,max=255"`
+		Description *string `json:"description" validate:"omitempty,max=255"`
+		ParentID    *string `json:"parentId" validate:"omitempty,uuid"`
+	}
+
+	orgId := c.Params("orgId")
+	userId := c.Locals("userId").(string)
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	// Validate everything but an empty parentId
+	parentId := body.ParentID
+	if parentId != nil && *parentId == "" {
+		body.ParentID = nil
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	team, err := findTeam(orgId, c.Params("teamId"))
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Team not found",
+		})
+	}
+
+	if !canManageTeam(team, userId) {
+		return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusForbidden,
+			"message":    "Only admins and leads of the team can change it",
+		})
+	}
+
+	updates := map[string]interface{}{}
+	if body.Name != nil {
+		updates["name"] = *body.Name
+	}
+	if body.Description != nil {
+		updates["description"] = *body.Description
+	}
+
+	if parentId != nil && *parentId == "" {
+		if !hasOrgPermission(orgId, userId, policy.TeamManage) {
+			return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+				"status":     "error",
+				"statusCode": http.StatusForbidden,
+				"message":    "You need the " + policy.TeamManage + " permission to move teams to the top level",
+			})
+		}
+		updates["parent_id"] = nil
+	} else if parentId != nil {
+		parent, err := findTeam(orgId, *parentId)
+		if err != nil {
+			return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+				"status":     "error",
+				"statusCode": http.StatusNotFound,
+				"message":    "Parent team not found",
+			})
+		}
+
+		// A team can't be moved under itself
+		if isWithinTeam(team, parent.TeamID.String()) {
+			return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+				"status":     "Bad request",
+				"statusCode": http.StatusBadRequest,
+				"message":    "A team can't be moved under itself or one of its subteams",
+			})
+		}
+
+		if !canManageTeam(parent, userId) {
+			return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+				"status":     "error",
+				"statusCode": http.StatusForbidden,
+				"message":    "Only admins and leads of the parent team can add subteams",
+			})
+		}
+		updates["parent_id"] = parent.TeamID
+	}
+
+	if len(updates) > 0 {
+		err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&team).Updates(updates).Error; err != nil {
+				return err
+			}
+
+			return audit.Record(tx, teamAuditEntry(c, team, audit.TeamUpdated, updates))
+		})
+
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+				"status":  "error",
+				"message": "An error occurred while updating the team",
+			})
+		}
+	}
+
+	team, _ = findTeam(orgId, team.TeamID.String())
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Team updated successfully",
+		"data":    teamResponse(team),
+	})
+}
+
+// Delete a team. Its subteams have to be moved or deleted first.
+// route DELETE /api/organisations/:orgId/teams/:teamId
+func DeleteTeam(c *fiber.Ctx) error {
+	orgId := c.Params("orgId")
+	userId := c.Locals("userId").(string)
+
+	team, err := findTeam(orgId, c.Params("teamId"))
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Team not found",
+		})
+	}
+
+	if !canManageTeam(team, userId) {
+		return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusForbidden,
+			"message":    "Only admins and leads of the team can delete it",
+		})
+	}
+
+	var subteams int64
+	database.DB.Db.Model(&models.Team{}).Where("parent_id = ?", team.TeamID).Count(&subteams)
+
+	if subteams > 0 {
+		return c.Status(http.StatusConflict).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusConflict,
+			"message":    "Move or delete this team's subteams first",
+		})
+	}
+
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("team_id = ?", team.TeamID).Delete(&models.TeamMember{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&team).Error; err != nil {
+			return err
+		}
+
+		return audit.Record(tx, teamAuditEntry(c, team, audit.TeamDeleted, nil))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while deleting the team",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Team deleted successfully",
+	})
+}
+
+// Add an organisation member to a team or change their team role
+// route PUT /api/organisations/:orgId/teams/:teamId/members/:userId
+func SetTeamMember(c *fiber.Ctx) error {
+	type ReqBody struct {
+		Role string `json:"role" validate:"omitempty,oneof=lead member"`
+	}
+
+	orgId := c.Params("orgId")
+	memberId := c.Params("userId")
+	userId := c.Locals("userId").(string)
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	if body.Role == "" {
+		body.Role = models.TeamRoleMember
+	}
+
+	team, err := findTeam(orgId, c.Params("teamId"))
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Team not found",
+		})
+	}
+
+	if !canManageTeam(team, userId) {
+		return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusForbidden,
+			"message":    "Only admins and leads of the team can change its members",
+		})
+	}
+
+	// Teams are drawn from the organisation's members
+	membership, err := findMembership(orgId, memberId)
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Member not found",
+		})
+	}
+
+	member := models.TeamMember{
+		TeamID: team.TeamID,
+		UserID: membership.UserUserID,
+		Role:   body.Role,
+	}
+
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where(models.TeamMember{TeamID: member.TeamID, UserID: member.UserID}).
+			Assign(models.TeamMember{Role: member.Role}).
+			FirstOrCreate(&member).Error
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(tx, teamAuditEntry(c, team, audit.TeamMemberSet, map[string]interface{}{
+			"userId": member.UserID,
+			"role":   member.Role,
+		}))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while updating the team",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Team member updated successfully",
+		"data":    member,
+	})
+}
+
+// Remove a member from a team. Members can also remove themselves.
+// route DELETE /api/organisations/:orgId/teams/:teamId/members/:userId
+func RemoveTeamMember(c *fiber.Ctx) error {
+	orgId := c.Params("orgId")
+	memberId := c.Params("userId")
+	userId := c.Locals("userId").(string)
+
+	team, err := findTeam(orgId, c.Params("teamId"))
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Team not found",
+		})
+	}
+
+	if memberId != userId && !canManageTeam(team, userId) {
+		return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusForbidden,
+			"message":    "Only admins and leads of the team can change its members",
+		})
+	}
+
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("team_id = ? AND user_id = ?", team.TeamID, memberId).Delete(&models.TeamMember{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return audit.Record(tx, teamAuditEntry(c, team, audit.TeamMemberRemoved, map[string]interface{}{"userId": memberId}))
+	})
+
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while updating the team",
+		})
+	}
+
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Member not found",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Team member removed successfully",
+	})
+}
+
+func teamResponse(team models.Team) fiber.Map {
+	return fiber.Map{
+		"teamId":      team.TeamID,
+		"orgId":       team.OrganisationID,
+		"parentId":    team.ParentID,
+		"name":        team.Name,
+		"description": team.Description,
+		"createdAt":   team.CreatedAt,
+	}
+}
+
+func teamAuditEntry(c *fiber.Ctx, team models.Team, action string, metadata map[string]interface{}) audit.Entry {
+	return audit.FromRequest(c, action).
+		Org(team.OrganisationID).
+		Target("team", team.TeamID).
+		With(metadata)
+}
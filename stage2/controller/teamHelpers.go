@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/policy"
+)
+
+// A team and every team above it
+const teamAncestorsQuery = `
+	WITH RECURSIVE ancestors AS (
+		SELECT team_id, parent_id FROM teams WHERE team_id = ? AND deleted_at IS NULL
+		UNION ALL
+		SELECT t.team_id, t.parent_id FROM teams t
+		JOIN ancestors a ON t.team_id = a.parent_id
+		WHERE t.deleted_at IS NULL
+	)
+	SELECT team_id FROM ancestors`
+
+// A team and every team below it
+const teamDescendantsQuery = `
+	WITH RECURSIVE descendants AS (
+		SELECT team_id FROM teams WHERE team_id = ? AND deleted_at IS NULL
+		UNION ALL
+		SELECT t.team_id FROM teams t
+		JOIN descendants d ON t.parent_id = d.team_id
+		WHERE t.deleted_at IS NULL
+	)
+	SELECT team_id FROM descendants`
+
+// Find a team in an organisation
+func findTeam(orgId string, teamId string) (models.Team, error) {
+	var team models.Team
+	err := database.DB.Db.Where("organisation_id = ? AND team_id = ?", orgId, teamId).First(&team).Error
+
+	return team, err
+}
+
+// A user's effective role in a team. Members whose organisation role
+// grants team:manage act as admins of every team, and leads of a team
+// lead every team below it.
+// Returns "" for users with no role.
+func teamRole(team models.Team, userId string) string {
+	if hasOrgPermission(team.OrganisationID.String(), userId, policy.TeamManage) {
+		return models.RoleAdmin
+	}
+
+	var leads int64
+	database.DB.Db.Model(&models.TeamMember{}).
+		Where("user_id = ? AND role = ? AND team_id IN ("+teamAncestorsQuery+")", userId, models.TeamRoleLead, team.TeamID).
+		Count(&leads)
+	if leads > 0 {
+		return models.TeamRoleLead
+	}
+
+	var member models.TeamMember
+	if err := database.DB.Db.Where("team_id = ? AND user_id = ?", team.TeamID, userId).First(&member).Error; err == nil {
+		return models.TeamRoleMember
+	}
+
+	return ""
+}
+
+// Check whether a user can change a team, its members and its subteams
+func canManageTeam(team models.Team, userId string) bool {
+	role := teamRole(team, userId)
+
+	return role == models.RoleAdmin || role == models.TeamRoleLead
+}
+
+// Check whether candidate is team or one of the teams below it
+func isWithinTeam(team models.Team, candidateId string) bool {
+	var count int64
+	database.DB.Db.Raw("SELECT count(*) FROM ("+teamDescendantsQuery+") AS d WHERE d.team_id = ?", team.TeamID, candidateId).
+		Scan(&count)
+
+	return count > 0
+}
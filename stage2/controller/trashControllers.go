@@ -0,0 +1,245 @@
+package controller
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/billing"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/pagination"
+	"gorm.io/gorm"
+)
+
+// List soft deleted users, most recently deleted first
+// route GET /api/admin/trash/users
+func GetTrashedUsers(c *fiber.Ctx) error {
+	page, err := pagination.Parse(c, trashedUserPagination)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    err.Error(),
+		})
+	}
+
+	var users []models.User
+	err = database.DB.Db.Unscoped().Scopes(page.Scope).Where("users.deleted_at IS NOT NULL").Find(&users).Error
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while fetching deleted users",
+		})
+	}
+
+	users, meta := page.Trim(users)
+
+	data := []fiber.Map{}
+	for _, user := range users {
+		item := userResponse(user)
+		item["deletedAt"] = user.DeletedAt.Time
+		item["restorable"] = !user.Anonymised()
+		data = append(data, item)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Deleted users found",
+		"data": fiber.Map{
+			"users": data,
+		},
+		"meta": meta,
+	})
+}
+
+// List soft deleted organisations, most recently deleted first
+// route GET /api/admin/trash/organisations
+func GetTrashedOrganisations(c *fiber.Ctx) error {
+	page, err := pagination.Parse(c, trashedOrganisationPagination)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    err.Error(),
+		})
+	}
+
+	var orgs []models.Organisation
+	err = database.DB.Db.Unscoped().Scopes(page.Scope).Where("organisations.deleted_at IS NOT NULL").Find(&orgs).Error
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while fetching deleted organisations",
+		})
+	}
+
+	orgs, meta := page.Trim(orgs)
+
+	data := []fiber.Map{}
+	for _, org := range orgs {
+		data = append(data, fiber.Map{
+			"orgId":       org.ID,
+			"name":        org.Name,
+			"description": org.Description,
+			"ownerId":     org.OwnerID,
+			"deletedAt":   org.DeletedAt.Time,
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Deleted organisations found",
+		"data": fiber.Map{
+			"organisations": data,
+		},
+		"meta": meta,
+	})
+}
+
+// Suspend a user by moving them to the trash. Their memberships are
+// left in place so a restore brings them back as they were.
+// route DELETE /api/admin/users/:id
+func DeleteUser(c *fiber.Ctx) error {
+	if c.Params("id") == c.Locals("userId").(string) {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    "Use account deletion to delete your own account",
+		})
+	}
+
+	var user models.User
+	if err := database.DB.Db.Where("user_id = ?", c.Params("id")).First(&user).Error; err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "User not found",
+		})
+	}
+
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.UserID).Delete(&models.User{}).Error; err != nil {
+			return err
+		}
+
+		err := tx.Model(&models.Session{}).
+			Where("user_id = ? AND revoked_at IS NULL", user.UserID).
+			Update("revoked_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(tx, audit.FromRequest(c, audit.UserDeleted).Target("user", user.UserID))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while deleting the user",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "User deleted successfully",
+	})
+}
+
+// Restore a soft deleted user. Memberships are kept while a user is
+// deleted, so they come back as they were.
+// route POST /api/admin/trash/users/:id/restore
+func RestoreUser(c *fiber.Ctx) error {
+	var user models.User
+	err := database.DB.Db.Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL", c.Params("id")).First(&user).Error
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Deleted user not found",
+		})
+	}
+
+	if user.Anonymised() {
+		return c.Status(http.StatusConflict).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusConflict,
+			"message":    "This account's data has been purged and can't be restored",
+		})
+	}
+
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Model(&models.User{}).
+			Where("user_id = ?", user.UserID).
+			Update("deleted_at", nil).Error
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(tx, audit.FromRequest(c, audit.UserRestored).Target("user", user.UserID))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while restoring the user",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "User restored successfully",
+		"data":    userResponse(user),
+	})
+}
+
+// Restore a soft deleted organisation with its members and teams, and
+// start billing it again
+// route POST /api/admin/trash/organisations/:orgId/restore
+func RestoreOrganisation(c *fiber.Ctx) error {
+	var org models.Organisation
+	err := database.DB.Db.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", c.Params("orgId")).First(&org).Error
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Deleted organisation not found",
+		})
+	}
+
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Model(&models.Organisation{}).
+			Where("id = ?", org.ID).
+			Update("deleted_at", nil).Error
+		if err != nil {
+			return err
+		}
+
+		if err := billing.Resume(tx, org.ID, time.Now()); err != nil {
+			return err
+		}
+
+		return audit.Record(tx, audit.FromRequest(c, audit.OrganisationRestored).
+			Org(org.ID).
+			Target("organisation", org.ID))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while restoring the organisation",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Organisation restored successfully",
+		"data": fiber.Map{
+			"orgId":       org.ID,
+			"name":        org.Name,
+			"description": org.Description,
+			"ownerId":     org.OwnerID,
+		},
+	})
+}
@@ -0,0 +1,568 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/utils"
+	"github.com/mryan-3/hng11/stage2/validation"
+	"github.com/mryan-3/hng11/stage2/webhooks"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const recoveryCodeCount = 10
+
+// Wrong codes a two-factor login challenge takes before it is used up
+const maxMfaFailures = 5
+
+// Start two-factor enrolment for the logged in user
+// route POST /auth/2fa/setup
+func SetupTwoFactor(c *fiber.Ctx) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "User not found",
+		})
+	}
+
+	if user.TwoFactorEnabled {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    "Two-factor authentication is already enabled",
+		})
+	}
+
+	enrolment, err := utils.GenerateTotpEnrolment(user.Email)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while generating the two-factor secret",
+		})
+	}
+
+	// The secret stays pending until a code is confirmed via /auth/2fa/enable
+	if err := database.DB.Db.Model(&user).Update("two_factor_secret", enrolment.Secret).Error; err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while saving the two-factor secret",
+		})
+	}
+
+	response := fiber.Map{
+		"status":  "success",
+		"message": "Scan the QR code with your authenticator app and confirm a code",
+		"data":    enrolment,
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// Confirm enrolment with a code from the authenticator app
+// route POST /auth/2fa/enable
+func EnableTwoFactor(c *fiber.Ctx) error {
+	type ReqBody struct {
+		Code string `json:"code" validate:"required"`
+	}
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	user, err := currentUser(c)
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "User not found",
+		})
+	}
+
+	if user.TwoFactorEnabled {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    "Two-factor authentication is already enabled",
+		})
+	}
+
+	if !acceptTotpCode(database.DB.Db, user, body.Code) {
+		return c.Status(http.StatusUnauthorized).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusUnauthorized,
+			"message":    "Invalid two-factor code",
+		})
+	}
+
+	var codes []string
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("two_factor_enabled", true).Error; err != nil {
+			return err
+		}
+
+		if codes, err = replaceRecoveryCodes(tx, user); err != nil {
+			return err
+		}
+
+		return audit.Record(tx, audit.FromRequest(c, audit.TwoFactorEnabled).Target("user", user.UserID))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while enabling two-factor authentication",
+		})
+	}
+
+	response := fiber.Map{
+		"status":  "success",
+		"message": "Two-factor authentication enabled",
+		"data": fiber.Map{
+			"recoveryCodes": codes,
+		},
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// Turn off two-factor authentication
+// route POST /auth/2fa/disable
+func DisableTwoFactor(c *fiber.Ctx) error {
+	type ReqBody struct {
+		Password     string `json:"password" validate:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recoveryCode"`
+	}
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	user, err := currentUser(c)
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "User not found",
+		})
+	}
+
+	if !user.TwoFactorEnabled {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    "Two-factor authentication is not enabled",
+		})
+	}
+
+	// Members of organisations that require 2FA can't turn it off
+	var enforcing int64
+	database.DB.Db.Model(&models.Organisation{}).
+		Joins("JOIN user_organizations ON user_organizations.organisation_id = organisations.id").
+		Where("user_organizations.user_user_id = ? AND organisations.require_two_factor", user.UserID).
+		Count(&enforcing)
+
+	if enforcing > 0 {
+		return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusForbidden,
+			"message":    "Two-factor authentication is required by one of your organisations",
+		})
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password)) != nil ||
+		!verifySecondFactor(database.DB.Db, user, body.Code, body.RecoveryCode) {
+		return c.Status(http.StatusUnauthorized).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusUnauthorized,
+			"message":    "Authentication failed",
+		})
+	}
+
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"two_factor_enabled": false,
+			"two_factor_secret":  "",
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Where("user_id = ?", user.UserID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		return audit.Record(tx, audit.FromRequest(c, audit.TwoFactorDisabled).Target("user", user.UserID))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while disabling two-factor authentication",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// Replace the logged in user's recovery codes
+// route POST /auth/2fa/recovery-codes
+func RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	type ReqBody struct {
+		Code string `json:"code" validate:"required"`
+	}
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	user, err := currentUser(c)
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "User not found",
+		})
+	}
+
+	if !user.TwoFactorEnabled || !acceptTotpCode(database.DB.Db, user, body.Code) {
+		return c.Status(http.StatusUnauthorized).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusUnauthorized,
+			"message":    "Invalid two-factor code",
+		})
+	}
+
+	codes, err := replaceRecoveryCodes(database.DB.Db, user)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while generating recovery codes",
+		})
+	}
+
+	response := fiber.Map{
+		"status":  "success",
+		"message": "Recovery codes regenerated",
+		"data": fiber.Map{
+			"recoveryCodes": codes,
+		},
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// Second step of a two-factor login
+// route POST /auth/login/2fa
+func VerifyTwoFactorLogin(c *fiber.Ctx) error {
+	type ReqBody struct {
+		MfaToken     string `json:"mfaToken" validate:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recoveryCode"`
+	}
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Parsing failed",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	userId, challengeId, err := utils.VerifyMfaChallengeToken(body.MfaToken)
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Authentication failed",
+			"statusCode": http.StatusUnauthorized,
+		})
+	}
+
+	var user models.User
+	if err := database.DB.Db.First(&user, "user_id = ?", userId).Error; err != nil || !user.TwoFactorEnabled {
+		return c.Status(http.StatusUnauthorized).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Authentication failed",
+			"statusCode": http.StatusUnauthorized,
+		})
+	}
+
+	// The challenge is stored, so it can only be passed once and is used
+	// up by too many wrong codes
+	passed := false
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		var challenge models.MfaChallenge
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("challenge_id = ? AND user_id = ?", challengeId, user.UserID).
+			Where("consumed_at IS NULL AND expires_at > ? AND failures < ?", time.Now(), maxMfaFailures).
+			First(&challenge).Error
+		if err != nil {
+			return err
+		}
+
+		if passed = verifySecondFactor(tx, user, body.Code, body.RecoveryCode); passed {
+			return tx.Model(&challenge).Update("consumed_at", time.Now()).Error
+		}
+		return tx.Model(&challenge).Update("failures", gorm.Expr("failures + 1")).Error
+	})
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(http.StatusUnauthorized).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Authentication failed. Log in again.",
+			"statusCode": http.StatusUnauthorized,
+		})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while checking the two-factor code",
+		})
+	}
+
+	if !passed {
+		if err := audit.Record(database.DB.Db, audit.FromRequest(c, audit.UserLoginFailed).
+			Actor(user.UserID).
+			Target("user", user.UserID).
+			With(map[string]interface{}{"reason": "two_factor"})); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+				"status":  "error",
+				"message": "An error occurred while logging in",
+			})
+		}
+
+		return c.Status(http.StatusUnauthorized).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Invalid two-factor code",
+			"statusCode": http.StatusUnauthorized,
+		})
+	}
+
+	return completeLogin(c, user)
+}
+
+// Set whether an organisation requires 2FA for all members
+// route PUT /api/organisations/:orgId/two-factor
+func UpdateOrganisationTwoFactorPolicy(c *fiber.Ctx) error {
+	type ReqBody struct {
+		Required *bool `json:"required" validate:"required"`
+	}
+
+	orgId := c.Params("orgId")
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	var org models.Organisation
+	if err := database.DB.Db.Where("id = ?", orgId).First(&org).Error; err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Organisation not found",
+		})
+	}
+
+	// Stop admins from locking themselves out
+	if *body.Required {
+		user, err := currentUser(c)
+		if err != nil || !user.TwoFactorEnabled {
+			return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+				"status":     "Bad request",
+				"statusCode": http.StatusBadRequest,
+				"message":    "Enable two-factor authentication on your account first",
+			})
+		}
+	}
+
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&org).Update("require_two_factor", *body.Required).Error; err != nil {
+			return err
+		}
+		org.RequireTwoFactor = *body.Required
+		if err := webhooks.Emit(tx, org.ID, webhooks.OrganisationUpdated, organisationEventData(org)); err != nil {
+			return err
+		}
+
+		return audit.Record(tx, audit.FromRequest(c, audit.TwoFactorPolicyChanged).
+			Org(org.ID).
+			Target("organisation", org.ID).
+			With(map[string]interface{}{"required": *body.Required}))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while updating the organisation",
+		})
+	}
+
+	response := fiber.Map{
+		"status":  "success",
+		"message": "Two-factor policy updated",
+		"data": fiber.Map{
+			"orgId":            org.ID.String(),
+			"requireTwoFactor": *body.Required,
+		},
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// Load the user set by middleware.UserAuth
+func currentUser(c *fiber.Ctx) (models.User, error) {
+	var user models.User
+	err := database.DB.Db.First(&user, "user_id = ?", c.Locals("userId")).Error
+
+	return user, err
+}
+
+// Check whether the logged in user is a platform admin
+func isPlatformAdmin(c *fiber.Ctx) bool {
+	user, err := currentUser(c)
+
+	return err == nil && user.IsPlatformAdmin()
+}
+
+// Check a TOTP code, or failing that consume a recovery code
+func verifySecondFactor(db *gorm.DB, user models.User, code string, recoveryCode string) bool {
+	if code != "" && acceptTotpCode(db, user, code) {
+		return true
+	}
+
+	if recoveryCode == "" {
+		return false
+	}
+
+	var codes []models.RecoveryCode
+	db.Where("user_id = ? AND used_at IS NULL", user.UserID).Find(&codes)
+
+	recoveryCode = strings.ToLower(strings.TrimSpace(recoveryCode))
+	for _, stored := range codes {
+		if bcrypt.CompareHashAndPassword([]byte(stored.CodeHash), []byte(recoveryCode)) == nil {
+			// Only succeed if we're the ones who marked it used
+			result := db.Model(&models.RecoveryCode{}).
+				Where("id = ? AND used_at IS NULL", stored.ID).
+				Update("used_at", time.Now())
+
+			return result.Error == nil && result.RowsAffected == 1
+		}
+	}
+
+	return false
+}
+
+// Check a TOTP code and record its time step. A code, or one from an
+// earlier step, is only accepted once.
+func acceptTotpCode(db *gorm.DB, user models.User, code string) bool {
+	step, ok := utils.TotpStep(code, user.TwoFactorSecret, time.Now())
+	if !ok {
+		return false
+	}
+
+	// Only succeed if we're the ones who moved the step on
+	result := db.Model(&models.User{}).
+		Where("user_id = ? AND two_factor_last_step < ?", user.UserID, step).
+		Update("two_factor_last_step", step)
+
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// Delete a user's recovery codes and store a fresh hashed set,
+// returning the plain codes so they can be shown once
+func replaceRecoveryCodes(tx *gorm.DB, user models.User) ([]string, error) {
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Unscoped().Where("user_id = ?", user.UserID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		hash, err := utils.CreateHashFromText(code, 10)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := tx.Create(&models.RecoveryCode{UserID: user.UserID, CodeHash: hash}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
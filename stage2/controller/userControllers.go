@@ -8,11 +8,18 @@ import (
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/config"
 	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/mailer"
 	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/pagination"
+	"github.com/mryan-3/hng11/stage2/policy"
 	"github.com/mryan-3/hng11/stage2/utils"
 	"github.com/mryan-3/hng11/stage2/validation"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 // Create User
@@ -94,14 +101,41 @@ func CreateUser(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).JSON("An error occurred while creating user")
 	}
 
-	// Add user to organisation
-	database.DB.Db.Model(&org).Association("Users").Append(&user)
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		// Add user to organisation
+		if err := tx.Model(&org).Association("Users").Append(&user); err != nil {
+			return err
+		}
+
+		// Add organisation to user
+		if err := tx.Model(&user).Association("Organisations").Append(&org); err != nil {
+			return err
+		}
+
+		// The user owns and administers their default organisation
+		if err := setMembershipRole(tx, org.ID, user.UserID, models.RoleAdmin); err != nil {
+			return err
+		}
+		if err := tx.Model(&org).Update("owner_id", user.UserID).Error; err != nil {
+			return err
+		}
+
+		if err := audit.Record(tx, audit.FromRequest(c, audit.UserRegistered).Actor(user.UserID).Target("user", user.UserID)); err != nil {
+			return err
+		}
 
-	// Add organisation to user
-	database.DB.Db.Model(&user).Association("Organisations").Append(&org)
+		return audit.Record(tx, audit.FromRequest(c, audit.OrganisationCreated).Actor(user.UserID).Org(org.ID).Target("organisation", org.ID))
+	})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while creating user",
+		})
+	}
 
 	// Generate token
-	token, err := utils.SignJwtToken(user.UserID.String())
+	token, err := startSession(c, user)
 
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
@@ -111,17 +145,13 @@ func CreateUser(c *fiber.Ctx) error {
 
 	}
 
-	// Create cookie
-	cookie := new(fiber.Cookie)
-	cookie.Name = "user"
-	cookie.Value = token
-	cookie.HTTPOnly = utils.Check(os.Getenv("APP_ENV") == "prod", true, false)
-	cookie.SameSite = utils.Check(os.Getenv("APP_ENV") == "prod", "strict", "None")
-	cookie.Secure = utils.Check(os.Getenv("APP_ENV") == "prod", true, false)
-	cookie.Expires = time.Now().Add(24 * time.Hour * 7)
-
 	// Set cookie
-	c.Cookie(cookie)
+	if err := setUserCookie(c, token); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while setting the cookie!",
+		})
+	}
 
 	response := fiber.Map{
 		"status":  "success",
@@ -182,6 +212,16 @@ func LoginUser(c *fiber.Ctx) error {
 	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password))
 
 	if err != nil {
+		if err := audit.Record(database.DB.Db, audit.FromRequest(c, audit.UserLoginFailed).
+			Actor(user.UserID).
+			Target("user", user.UserID).
+			With(map[string]interface{}{"reason": "password"})); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+				"status":  "error",
+				"message": "An error occurred while logging in",
+			})
+		}
+
 		return c.Status(http.StatusUnauthorized).JSON(&fiber.Map{
             "status":     "Bad request",
 			"message":    "Authentication failed",
@@ -190,8 +230,55 @@ func LoginUser(c *fiber.Ctx) error {
 
 	}
 
+	// Users with two-factor enabled get a challenge token instead
+	// and must complete the login at /auth/login/2fa
+	if user.TwoFactorEnabled {
+		challenge := models.MfaChallenge{
+			ChallengeID: uuid.New(),
+			UserID:      user.UserID,
+			ExpiresAt:   time.Now().Add(utils.MfaChallengeLifetime),
+		}
+
+		err := database.DB.Db.Create(&challenge).Error
+		var mfaToken string
+		if err == nil {
+			mfaToken, err = utils.SignMfaChallengeToken(user.UserID.String(), challenge.ChallengeID.String(), challenge.ExpiresAt)
+		}
+
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+				"status":  "error",
+				"message": "An error occurred while generating token!",
+			})
+		}
+
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"status":  "success",
+			"message": "Two-factor authentication required",
+			"data": fiber.Map{
+				"mfaRequired": true,
+				"mfaToken":    mfaToken,
+			},
+		})
+	}
+
+	return completeLogin(c, user)
+}
+
+// Issue a JWT for a user who passed every login step
+func completeLogin(c *fiber.Ctx, user models.User) error {
+	// Logging in during the grace period cancels a pending deletion
+	if user.DeletionScheduledAt != nil {
+		if err := database.DB.Db.Model(&user).Update("deletion_scheduled_at", nil).Error; err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+				"status":  "error",
+				"message": "An error occurred while cancelling the account deletion",
+			})
+		}
+	}
+
 	// Generate JWT token
-	token, err := utils.SignJwtToken(user.UserID.String())
+	token, err := startSession(c, user)
 
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
@@ -201,17 +288,23 @@ func LoginUser(c *fiber.Ctx) error {
 
 	}
 
-	// Create cookie
-	cookie := new(fiber.Cookie)
-	cookie.Name = "user"
-	cookie.Value = token
-	cookie.HTTPOnly = utils.Check(os.Getenv("APP_ENV") == "prod", true, false)
-	cookie.SameSite = utils.Check(os.Getenv("APP_ENV") == "prod", "strict", "None")
-	cookie.Secure = utils.Check(os.Getenv("APP_ENV") == "prod", true, false)
-	cookie.Expires = time.Now().Add(24 * time.Hour * 7)
-
 	// Set cookie
-	c.Cookie(cookie)
+	if err := setUserCookie(c, token); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while setting the cookie!",
+		})
+	}
+
+	if err := audit.Record(database.DB.Db, audit.FromRequest(c, audit.UserLoggedIn).
+		Actor(user.UserID).
+		Target("user", user.UserID).
+		With(map[string]interface{}{"twoFactor": user.TwoFactorEnabled})); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while logging in",
+		})
+	}
 
 	response := fiber.Map{
 		"status":  "success",
@@ -231,7 +324,7 @@ func LoginUser(c *fiber.Ctx) error {
 	return c.Status(http.StatusOK).JSON(response)
 }
 
-// Get a user
+// Get a user the logged in user is allowed to see
 // route GET /api/users/:id
 func GetUser(c *fiber.Ctx) error {
 	userId := c.Params("id")
@@ -243,9 +336,18 @@ func GetUser(c *fiber.Ctx) error {
 		})
 	}
 
+	viewer, err := currentUser(c)
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "Unauthorized",
+		})
+	}
+
 	var user models.User
 
-	if err := database.DB.Db.Where("user_id = ?", userId).First(&user).Error; err != nil {
+	// Users the viewer can't see are reported as not found
+	if err := database.DB.Db.Scopes(policy.VisibleUsers(viewer)).Where("user_id = ?", userId).First(&user).Error; err != nil {
 		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
 			"status":     "error",
 			"statusCode": http.StatusNotFound,
@@ -257,39 +359,348 @@ func GetUser(c *fiber.Ctx) error {
 		"status":  "success",
 		"message": "User found",
 		"data": fiber.Map{
-			"user": fiber.Map{
-				"userId":    user.UserID,
-				"firstName": user.FirstName,
-				"lastName":  user.LastName,
-				"email":     user.Email,
-				"phone":     user.Phone,
-			},
+			"user": userResponse(user),
 		},
 	}
 
 	return c.Status(http.StatusOK).JSON(response)
 }
 
-
-// Get all users
+// Get all users the logged in user is allowed to see
 // route GET /api/users
 func GetUsers(c *fiber.Ctx) error {
+	viewer, err := currentUser(c)
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "Unauthorized",
+		})
+	}
 
-    var users []models.User
-    if err := database.DB.Db.Find(&users).Error; err != nil {
-        return c.Status(http.StatusNotFound).JSON(&fiber.Map{
-            "status":     "error",
-            "statusCode": http.StatusNotFound,
-            "message":    "Users not found",
-        })
-    }
+	page, err := pagination.Parse(c, userPagination)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    err.Error(),
+		})
+	}
 
-    response := fiber.Map{
-        "status":  "success",
-        "message": "Users found",
-        "data": fiber.Map{
-            "users": users,
-        },
-    }
-    return c.Status(http.StatusOK).JSON(response)
+	query := database.DB.Db.Scopes(policy.VisibleUsers(viewer))
+
+	if name := c.Query("name"); name != "" {
+		pattern := pagination.Contains(name)
+		query = query.Where("(users.first_name || ' ' || users.last_name) ILIKE ?", pattern)
+	}
+	if email := c.Query("email"); email != "" {
+		query = query.Where("users.email ILIKE ?", pagination.Contains(email))
+	}
+
+	var users []models.User
+	if err := query.Scopes(page.Scope).Find(&users).Error; err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Users not found",
+		})
+	}
+
+	users, meta := page.Trim(users)
+
+	usersResponse := []fiber.Map{}
+	for _, user := range users {
+		usersResponse = append(usersResponse, userResponse(user))
+	}
+
+	response := fiber.Map{
+		"status":  "success",
+		"message": "Users found",
+		"data": fiber.Map{
+			"users": usersResponse,
+		},
+		"meta": meta,
+	}
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// The public fields of a user
+func userResponse(user models.User) fiber.Map {
+	return fiber.Map{
+		"userId":    user.UserID,
+		"firstName": user.FirstName,
+		"lastName":  user.LastName,
+		"email":     user.Email,
+		"phone":     user.Phone,
+	}
+}
+
+// Update a user's profile. Users can update themselves, and platform
+// admins can update anyone.
+// Email changes only apply once confirmed from the new address.
+// route PUT /api/users/:id
+func UpdateUser(c *fiber.Ctx) error {
+	type ReqBody struct {
+		FirstName *string `json:"firstName" validate:"omitempty,min=1,max=255"`
+		LastName  *string `json:"lastName" validate:"omitempty,min=1,max=255"`
+		Email     *string `json:"email" validate:"omitempty,email"`
+		Phone     *string `json:"phone" validate:"omitempty,max=255"`
+	}
+
+	userId := c.Params("id")
+	callerId := c.Locals("userId").(string)
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	var user models.User
+
+	if err := database.DB.Db.Where("user_id = ?", userId).First(&user).Error; err != nil {
+		return c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "User not found",
+		})
+	}
+
+	// Profiles belong to their users, not to the organisations they are
+	// in, so only platform admins can edit other people's
+	isSelf := user.UserID.String() == callerId
+	if !isSelf && !isPlatformAdmin(c) {
+		return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusForbidden,
+			"message":    "You are not allowed to update this user",
+		})
+	}
+
+	changingEmail := body.Email != nil && !strings.EqualFold(*body.Email, user.Email)
+	if changingEmail && !isSelf {
+		return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusForbidden,
+			"message":    "Only the account owner can change their email",
+		})
+	}
+
+	if changingEmail {
+		var count int64
+		database.DB.Db.Model(&models.User{}).Where("email = ?", *body.Email).Count(&count)
+
+		if count > 0 {
+			return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+				"status":     "Bad request",
+				"statusCode": http.StatusBadRequest,
+				"message":    "Email is already in use",
+			})
+		}
+	}
+
+	updates := map[string]interface{}{}
+	if body.FirstName != nil {
+		updates["first_name"] = *body.FirstName
+	}
+	if body.LastName != nil {
+		updates["last_name"] = *body.LastName
+	}
+	if body.Phone != nil {
+		updates["phone"] = *body.Phone
+	}
+
+	if len(updates) > 0 {
+		if err := database.DB.Db.Model(&user).Updates(updates).Error; err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+				"status":  "error",
+				"message": "An error occurred while updating user",
+			})
+		}
+	}
+
+	data := fiber.Map{
+		"user": fiber.Map{
+			"userId":    user.UserID,
+			"firstName": user.FirstName,
+			"lastName":  user.LastName,
+			"email":     user.Email,
+			"phone":     user.Phone,
+		},
+	}
+
+	if changingEmail {
+		if err := requestEmailChange(user, *body.Email); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+				"status":  "error",
+				"message": "An error occurred while requesting the email change",
+			})
+		}
+
+		data["pendingEmail"] = *body.Email
+	}
+
+	response := fiber.Map{
+		"status":  "success",
+		"message": utils.Check(changingEmail, "User updated, confirm the new email to finish changing it", "User updated successfully"),
+		"data":    data,
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// Confirm an email change with the token sent to the new address
+// route POST /auth/confirm-email
+func ConfirmEmailChange(c *fiber.Ctx) error {
+	type ReqBody struct {
+		Token string `json:"token" validate:"required"`
+	}
+
+	body := new(ReqBody)
+
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"message":    "Client error",
+			"statusCode": http.StatusBadRequest,
+		})
+	}
+
+	validationErrors := validation.ValidateStruct(body)
+
+	if len(validationErrors) > 0 {
+		response := fiber.Map{"errors": validationErrors}
+		return c.Status(http.StatusUnprocessableEntity).JSON(response)
+	}
+
+	var change models.EmailChange
+	err := database.DB.Db.
+		Where("token_hash = ? AND confirmed_at IS NULL AND expires_at > ?", utils.HashToken(body.Token), time.Now()).
+		First(&change).Error
+
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+			"status":     "Bad request",
+			"statusCode": http.StatusBadRequest,
+			"message":    "Invalid or expired token",
+		})
+	}
+
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("user_id = ?", change.UserID).Update("email", change.NewEmail).Error; err != nil {
+			return err
+		}
+
+		// Any other pending change for the user is now stale
+		if err := tx.Model(&models.EmailChange{}).
+			Where("user_id = ? AND confirmed_at IS NULL", change.UserID).
+			Update("confirmed_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return audit.Record(tx, audit.FromRequest(c, audit.UserEmailChanged).
+			Actor(change.UserID).
+			Target("user", change.UserID))
+	})
+
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key value") {
+			return c.Status(http.StatusBadRequest).JSON(&fiber.Map{
+				"status":     "Bad request",
+				"statusCode": http.StatusBadRequest,
+				"message":    "Email is already in use",
+			})
+		}
+
+		return c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while changing email",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Email changed successfully",
+		"data": fiber.Map{
+			"email": change.NewEmail,
+		},
+	})
+}
+
+// Store a pending email change and send its confirmation token
+// to the new address
+func requestEmailChange(user models.User, newEmail string) error {
+	token, err := utils.RandomToken()
+	if err != nil {
+		return err
+	}
+
+	change := models.EmailChange{
+		UserID:    user.UserID,
+		NewEmail:  newEmail,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(24 * time.Hour),
+	}
+
+	link := os.Getenv("CLIENT_FRONTEND_URL") + "/confirm-email?token=" + token
+
+	return database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&change).Error; err != nil {
+			return err
+		}
+
+		return mailer.EnqueueTemplate(tx, nil, newEmail, "email_change", map[string]interface{}{
+			"firstName": user.FirstName,
+			"link":      link,
+		})
+	})
+}
+
+// Set the auth cookie holding the user's JWT, encrypted with
+// ENCRYPT_COOKIE_KEY
+func setUserCookie(c *fiber.Ctx, token string) error {
+	value, err := utils.EncryptCookie(token)
+	if err != nil {
+		return err
+	}
+
+	profile := config.AuthCookie()
+
+	cookie := new(fiber.Cookie)
+	cookie.Name = "user"
+	cookie.Value = value
+	cookie.HTTPOnly = profile.HTTPOnly
+	cookie.SameSite = profile.SameSite
+	cookie.Secure = profile.Secure
+	cookie.Domain = profile.Domain
+	cookie.Expires = time.Now().Add(utils.JwtTokenLifetime)
+
+	c.Cookie(cookie)
+
+	return nil
+}
+
+// Expire the auth cookie
+func clearUserCookie(c *fiber.Ctx) {
+	profile := config.AuthCookie()
+
+	c.Cookie(&fiber.Cookie{
+		Name:     "user",
+		Value:    "",
+		HTTPOnly: profile.HTTPOnly,
+		SameSite: profile.SameSite,
+		Secure:   profile.Secure,
+		Domain:   profile.Domain,
+		Expires:  time.Unix(0, 0),
+	})
 }
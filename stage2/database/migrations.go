@@ -2,19 +2,83 @@ package database
 
 import (
 	"fmt"
+	"log"
+	"strings"
 
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/mailer"
 	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/webhooks"
 	"gorm.io/gorm"
 )
 
 func MigrateDatabase(DB *gorm.DB) {
 	fmt.Println("Running migration")
 
+	// Use the Membership model for the user_organizations join table
+	// so memberships can carry a role
+	if err := DB.SetupJoinTable(&models.User{}, "Organisations", &models.Membership{}); err != nil {
+		fmt.Println("Failed to set up join table", err)
+	}
+	if err := DB.SetupJoinTable(&models.Organisation{}, "Users", &models.Membership{}); err != nil {
+		fmt.Println("Failed to set up join table", err)
+	}
+
+	// The models below key users on user_id alone, so carrying on with
+	// the old keys in place would leave the schema half migrated
+	if err := dropIntegerUserIds(DB); err != nil {
+		log.Fatal("Failed to migrate users to a single uuid primary key. \n", err)
+	}
+	if err := dropIntegerIds(DB); err != nil {
+		log.Fatal("Failed to migrate tables to uuid primary keys. \n", err)
+	}
+
 	DB.AutoMigrate(
 		models.User{},
 		models.Organisation{},
+		models.RecoveryCode{},
+		models.ApiKey{},
+		models.Session{},
+		models.EmailChange{},
+		models.OwnershipTransfer{},
+		models.AuditEvent{},
+		models.AuditEventDetail{},
+		models.Team{},
+		models.TeamMember{},
+		models.OrgRole{},
+		models.AuditCheckpoint{},
+		models.OutboxEmail{},
+		models.EmailTemplate{},
+		models.Payment{},
+		models.WebhookEvent{},
+		models.Subscription{},
+		models.SubscriptionCharge{},
+		models.Invoice{},
+		models.InvoiceLine{},
+		models.WebhookEndpoint{},
+		models.WebhookDelivery{},
+		models.WebhookAttempt{},
+		models.OutboxJob{},
+		models.MfaChallenge{},
+		models.PaymentRefund{},
 	)
 
+	backfillOrganisationAdmins(DB)
+	backfillOrganisationOwners(DB)
+	protectAuditEvents(DB)
+	backfillOutboxJobs(DB)
+	dropWebhookResponses(DB)
+	dropCanEditProfiles(DB)
+	suspendTrashedSubscriptions(DB)
+
+	if err := audit.SeparateDetails(DB); err != nil {
+		fmt.Println("Failed to separate audit event details", err)
+	}
+
+	if err := audit.ChainUnchained(DB); err != nil {
+		fmt.Println("Failed to chain audit events", err)
+	}
+
     Session := DB.Session(&gorm.Session{PrepareStmt: true})
     if Session != nil {
         fmt.Println("success")
@@ -22,3 +86,305 @@ func MigrateDatabase(DB *gorm.DB) {
 
 	fmt.Println("Migration ran!")
 }
+
+// Organisations put in the trash before subscriptions were suspended
+// with them are still being renewed. Suspend them from when they were
+// trashed.
+func suspendTrashedSubscriptions(DB *gorm.DB) {
+	err := DB.Exec(`
+		UPDATE subscriptions AS s SET suspended_at = o.deleted_at
+		FROM organisations o
+		WHERE o.id = s.organisation_id AND o.deleted_at IS NOT NULL AND s.suspended_at IS NULL`,
+	).Error
+	if err != nil {
+		fmt.Println("Failed to suspend subscriptions of trashed organisations", err)
+	}
+}
+
+// Organisations created before memberships had roles have no admin.
+// Promote the earliest registered member of each of them.
+func backfillOrganisationAdmins(DB *gorm.DB) {
+	err := DB.Exec(`
+		UPDATE user_organizations AS uo SET role = ?
+		FROM (
+			SELECT DISTINCT ON (m.organisation_id) m.organisation_id, m.user_user_id
+			FROM user_organizations m
+			JOIN users u ON u.user_id = m.user_user_id
+			WHERE NOT EXISTS (
+				SELECT 1 FROM user_organizations a
+				WHERE a.organisation_id = m.organisation_id AND a.role = ?
+			)
+			ORDER BY m.organisation_id, u.created_at
+		) AS first
+		WHERE uo.organisation_id = first.organisation_id AND uo.user_user_id = first.user_user_id`,
+		models.RoleAdmin, models.RoleAdmin,
+	).Error
+	if err != nil {
+		fmt.Println("Failed to backfill organisation admins", err)
+	}
+}
+
+// Organisations created before ownership existed are owned by their
+// earliest registered admin
+func backfillOrganisationOwners(DB *gorm.DB) {
+	err := DB.Exec(`
+		UPDATE organisations AS o SET owner_id = first.user_user_id
+		FROM (
+			SELECT DISTINCT ON (m.organisation_id) m.organisation_id, m.user_user_id
+			FROM user_organizations m
+			JOIN users u ON u.user_id = m.user_user_id AND u.deleted_at IS NULL
+			WHERE m.role = ?
+			ORDER BY m.organisation_id, u.created_at
+		) AS first
+		WHERE o.id = first.organisation_id AND o.owner_id IS NULL`,
+		models.RoleAdmin,
+	).Error
+	if err != nil {
+		fmt.Println("Failed to backfill organisation owners", err)
+	}
+}
+
+// Emails and webhook deliveries queued before the outbox ran them have
+// no job. Queue one for each that is still waiting.
+func backfillOutboxJobs(DB *gorm.DB) {
+	backfills := []struct {
+		kind, table, column, key, pending string
+	}{
+		{mailer.JobSend, "outbox_emails", "email_id", "emailId", models.EmailPending},
+		{webhooks.JobDeliver, "webhook_deliveries", "delivery_id", "deliveryId", models.DeliveryPending},
+	}
+
+	for _, backfill := range backfills {
+		err := DB.Exec(`
+			INSERT INTO outbox_jobs (kind, payload, status, attempts, run_at, created_at, updated_at)
+			SELECT ?, jsonb_build_object(?::text, t.`+backfill.column+`), ?, 0, NOW(), NOW(), NOW()
+			FROM `+backfill.table+` t
+			WHERE t.status = ? AND NOT EXISTS (
+				SELECT 1 FROM outbox_jobs j
+				WHERE j.kind = ? AND j.payload->>? = t.`+backfill.column+`::text
+			)`,
+			backfill.kind, backfill.key, models.JobPending, backfill.pending, backfill.kind, backfill.key,
+		).Error
+		if err != nil {
+			fmt.Println("Failed to backfill outbox jobs", err)
+		}
+	}
+}
+
+// Webhook attempts used to keep what endpoints responded with, which
+// could be read back from internal services. Drop what was kept.
+func dropWebhookResponses(DB *gorm.DB) {
+	if DB.Migrator().HasColumn(&models.WebhookAttempt{}, "response") {
+		if err := DB.Migrator().DropColumn(&models.WebhookAttempt{}, "response"); err != nil {
+			fmt.Println("Failed to drop webhook responses", err)
+		}
+	}
+}
+
+// Editing members' profiles was a per membership flag before it was the
+// member:update permission
+func dropCanEditProfiles(DB *gorm.DB) {
+	if DB.Migrator().HasColumn(&models.Membership{}, "can_edit_profiles") {
+		if err := DB.Migrator().DropColumn(&models.Membership{}, "can_edit_profiles"); err != nil {
+			fmt.Println("Failed to drop can_edit_profiles", err)
+		}
+	}
+}
+
+// Audit events are append only. The model refuses updates and deletes,
+// and this trigger stops anything else changing them.
+func protectAuditEvents(DB *gorm.DB) {
+	for _, column := range []string{"updated_at", "deleted_at"} {
+		if DB.Migrator().HasColumn(&models.AuditEvent{}, column) {
+			DB.Migrator().DropColumn(&models.AuditEvent{}, column)
+		}
+	}
+
+	// Run one at a time, prepared statements can't hold several
+	statements := []string{
+		`CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_events is append only';
+		END;
+		$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events`,
+		`CREATE TRIGGER audit_events_append_only
+			BEFORE UPDATE OR DELETE ON audit_events
+			FOR EACH ROW EXECUTE FUNCTION audit_events_append_only()`,
+	}
+
+	for _, statement := range statements {
+		if err := DB.Exec(statement).Error; err != nil {
+			fmt.Println("Failed to protect audit events", err)
+			return
+		}
+	}
+}
+
+// Users used to embed gorm.Model next to their uuid, which gave them a
+// composite (id, user_id) primary key and an extra user_id integer column
+// in user_organizations. Drop the integer ids and key both tables on the
+// uuids alone. Foreign keys to users are dropped first and recreated by
+// AutoMigrate afterwards.
+func dropIntegerUserIds(DB *gorm.DB) error {
+	if !DB.Migrator().HasColumn("users", "id") {
+		return nil
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		statements, err := dropForeignKeysTo(tx, "users")
+		if err != nil {
+			return err
+		}
+
+		if tx.Migrator().HasColumn("user_organizations", "user_id") {
+			statements = append(statements,
+				`ALTER TABLE user_organizations DROP CONSTRAINT IF EXISTS user_organizations_pkey`,
+				`ALTER TABLE user_organizations DROP COLUMN user_id`,
+				`DELETE FROM user_organizations a USING user_organizations b
+					WHERE a.ctid > b.ctid
+					AND a.user_user_id = b.user_user_id
+					AND a.organisation_id = b.organisation_id`,
+				`ALTER TABLE user_organizations ADD PRIMARY KEY (user_user_id, organisation_id)`,
+			)
+		}
+
+		statements = append(statements,
+			`ALTER TABLE users DROP CONSTRAINT IF EXISTS users_pkey`,
+			`ALTER TABLE users DROP COLUMN id`,
+			`ALTER TABLE users ADD PRIMARY KEY (user_id)`,
+		)
+
+		// Run one at a time, prepared statements can't hold several
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Tables that embedded gorm.Model, or had an integer id of their own,
+// next to the uuid they are known by, and the uuid column that is now
+// their primary key
+var uuidKeyedTables = []struct {
+	table, key string
+}{
+	{"api_keys", "key_id"},
+	{"sessions", "session_id"},
+	{"ownership_transfers", "transfer_id"},
+	{"teams", "team_id"},
+	{"org_roles", "role_id"},
+	{"payments", "payment_id"},
+	{"payment_refunds", "refund_id"},
+	{"subscriptions", "subscription_id"},
+	{"invoices", "invoice_id"},
+	{"webhook_endpoints", "endpoint_id"},
+	{"webhook_deliveries", "delivery_id"},
+	{"outbox_emails", "email_id"},
+	{"outbox_jobs", "job_id"},
+	{"mfa_challenges", "challenge_id"},
+}
+
+// Columns that referred to those tables by their integer ids
+var uuidForeignKeys = []struct {
+	table, column, references, key string
+}{
+	{"payment_refunds", "payment_id", "payments", "payment_id"},
+	{"subscription_charges", "subscription_id", "subscriptions", "subscription_id"},
+	{"subscription_charges", "invoice_id", "invoices", "invoice_id"},
+	{"invoice_lines", "invoice_id", "invoices", "invoice_id"},
+	{"webhook_deliveries", "endpoint_id", "webhook_endpoints", "endpoint_id"},
+	{"webhook_attempts", "delivery_id", "webhook_deliveries", "delivery_id"},
+}
+
+// Key the tables above on their uuids alone. Columns referring to them
+// are switched to the uuids first, while the integer ids they hold can
+// still be looked up. Rows referring to a row that no longer exists
+// can't be carried over and are deleted. Indexes and foreign keys are
+// recreated by AutoMigrate afterwards.
+func dropIntegerIds(DB *gorm.DB) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		statements := []string{}
+
+		for _, fk := range uuidForeignKeys {
+			if !tx.Migrator().HasTable(fk.table) || !tx.Migrator().HasColumn(fk.references, "id") {
+				continue
+			}
+
+			column := quoteIdentifier(fk.column)
+			converted := quoteIdentifier(fk.column + "_uuid")
+			statements = append(statements,
+				fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s uuid", fk.table, converted),
+				fmt.Sprintf("UPDATE %s AS c SET %s = p.%s FROM %s AS p WHERE p.id = c.%s",
+					fk.table, converted, quoteIdentifier(fk.key), fk.references, column),
+				fmt.Sprintf("DELETE FROM %s WHERE %s IS NOT NULL AND %s IS NULL", fk.table, column, converted),
+				fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", fk.table, column),
+				fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", fk.table, converted, column),
+			)
+		}
+
+		for _, keyed := range uuidKeyedTables {
+			if !tx.Migrator().HasColumn(keyed.table, "id") {
+				continue
+			}
+
+			foreignKeys, err := dropForeignKeysTo(tx, keyed.table)
+			if err != nil {
+				return err
+			}
+
+			statements = append(statements, foreignKeys...)
+			statements = append(statements,
+				fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s", keyed.table, quoteIdentifier(keyed.table+"_pkey")),
+				fmt.Sprintf("ALTER TABLE %s DROP COLUMN id", keyed.table),
+				fmt.Sprintf("DROP INDEX IF EXISTS %s", quoteIdentifier("idx_"+keyed.table+"_"+keyed.key)),
+				fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY (%s)", keyed.table, quoteIdentifier(keyed.key)),
+			)
+		}
+
+		// Run one at a time, prepared statements can't hold several
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Statements dropping the foreign keys that refer to a table
+func dropForeignKeysTo(tx *gorm.DB, table string) ([]string, error) {
+	var foreignKeys []struct {
+		Schema string
+		Table  string
+		Name   string
+	}
+	err := tx.Raw(`
+		SELECT n.nspname AS schema, t.relname AS "table", c.conname AS name
+		FROM pg_constraint c
+		JOIN pg_class t ON t.oid = c.conrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		WHERE c.contype = 'f' AND c.confrelid = ?::regclass`,
+		table,
+	).Scan(&foreignKeys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	statements := []string{}
+	for _, fk := range foreignKeys {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s.%s DROP CONSTRAINT %s",
+			quoteIdentifier(fk.Schema), quoteIdentifier(fk.Table), quoteIdentifier(fk.Name)))
+	}
+
+	return statements, nil
+}
+
+// Quote a name for use in SQL as Postgres does, doubling any quotes in it
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,13 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuoteIdentifier(t *testing.T) {
+	assert.Equal(t, `"users"`, quoteIdentifier("users"))
+	assert.Equal(t, `"Team Members"`, quoteIdentifier("Team Members"))
+	assert.Equal(t, `"a""; DROP TABLE users; --"`, quoteIdentifier(`a"; DROP TABLE users; --`))
+}
@@ -0,0 +1,193 @@
+package jobs
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/billing"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/webhooks"
+	"gorm.io/gorm"
+)
+
+// Anonymise accounts whose deletion grace period has passed
+func PurgeDeletedAccounts() error {
+	var users []models.User
+	err := database.DB.Db.
+		Where("deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= ?", time.Now()).
+		Find(&users).Error
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+			return PurgeAccount(tx, user)
+		}); err != nil {
+			return fmt.Errorf("purging user %s: %w", user.UserID, err)
+		}
+
+		log.Printf("Purged account %s", user.UserID)
+	}
+
+	return nil
+}
+
+// Remove a user's personal data. Records that are kept for the
+// organisations they belonged to are stripped of anything identifying.
+func PurgeAccount(tx *gorm.DB, user models.User) error {
+	if err := handOverOrganisations(tx, user.UserID); err != nil {
+		return err
+	}
+
+	// Transfers the user was part of can no longer be answered
+	err := tx.Model(&models.OwnershipTransfer{}).
+		Where("(from_user_id = ? OR to_user_id = ?) AND status = ?", user.UserID, user.UserID, models.TransferPending).
+		Updates(map[string]interface{}{"status": models.TransferCancelled, "responded_at": time.Now()}).Error
+	if err != nil {
+		return err
+	}
+
+	if err := removeMemberships(tx, user.UserID); err != nil {
+		return err
+	}
+
+	if err := tx.Where("user_id = ?", user.UserID).Delete(&models.TeamMember{}).Error; err != nil {
+		return err
+	}
+
+	// Credentials and pending changes are deleted outright
+	for _, model := range []interface{}{&models.ApiKey{}, &models.RecoveryCode{}, &models.EmailChange{}} {
+		if err := tx.Unscoped().Where("user_id = ?", user.UserID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+
+	// Emails to the user are only kept for delivery
+	if err := tx.Where("recipient = ?", user.Email).Delete(&models.OutboxEmail{}).Error; err != nil {
+		return err
+	}
+
+	// Audit events are append only and are kept, but the personal data
+	// recorded with them is held apart and is erased here. What is left
+	// refers to the user by id, which leads to the anonymised row below.
+	if err := audit.EraseUser(tx, user.UserID); err != nil {
+		return err
+	}
+
+	// Sessions are kept for their timestamps only
+	err = tx.Model(&models.Session{}).Where("user_id = ?", user.UserID).Updates(map[string]interface{}{
+		"ip":         "",
+		"user_agent": "",
+		"revoked_at": gorm.Expr("COALESCE(revoked_at, ?)", time.Now()),
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	err = tx.Model(&user).Updates(map[string]interface{}{
+		"first_name":            "Deleted",
+		"last_name":             "User",
+		"email":                 fmt.Sprintf("deleted-%s@%s", user.UserID, models.AnonymisedEmailDomain),
+		"phone":                 "",
+		"password":              "",
+		"two_factor_secret":     "",
+		"two_factor_enabled":    false,
+		"deletion_scheduled_at": nil,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Delete(&user).Error
+}
+
+// Hand the organisations a user belongs to over to the other members
+// before the user is removed from them
+func handOverOrganisations(tx *gorm.DB, userId uuid.UUID) error {
+	var memberships []models.Membership
+	if err := tx.Where("user_user_id = ?", userId).Find(&memberships).Error; err != nil {
+		return err
+	}
+
+	for _, membership := range memberships {
+		var others []models.Membership
+		err := tx.Where("organisation_id = ? AND user_user_id <> ?", membership.OrganisationID, userId).
+			Order("created_at").
+			Find(&others).Error
+		if err != nil {
+			return err
+		}
+
+		// Organisations only the user belonged to go with them
+		if len(others) == 0 {
+			if err := tx.Where("id = ?", membership.OrganisationID).Delete(&models.Organisation{}).Error; err != nil {
+				return err
+			}
+			if err := billing.Suspend(tx, membership.OrganisationID, time.Now()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Don't leave an organisation without an admin or an owner.
+		// Ownership goes to the earliest admin, or the earliest member.
+		heir := others[0]
+		for _, other := range others {
+			if other.Role == models.RoleAdmin {
+				heir = other
+				break
+			}
+		}
+
+		if membership.Role == models.RoleAdmin && heir.Role != models.RoleAdmin {
+			err := tx.Model(&models.Membership{}).
+				Where("organisation_id = ? AND user_user_id = ?", membership.OrganisationID, heir.UserUserID).
+				Update("role", models.RoleAdmin).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		err = tx.Unscoped().Model(&models.Organisation{}).
+			Where("id = ? AND owner_id = ?", membership.OrganisationID, userId).
+			Update("owner_id", heir.UserUserID).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Remove a user from their organisations, freeing the seats they took
+func removeMemberships(tx *gorm.DB, userId uuid.UUID) error {
+	var memberships []models.Membership
+	if err := tx.Where("user_user_id = ?", userId).Find(&memberships).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Where("user_user_id = ?", userId).Delete(&models.Membership{}).Error; err != nil {
+		return err
+	}
+
+	for _, membership := range memberships {
+		if err := billing.SyncOrgSeats(tx, membership.OrganisationID, time.Now()); err != nil {
+			return err
+		}
+
+		err := webhooks.Emit(tx, membership.OrganisationID, webhooks.MemberRemoved, map[string]interface{}{
+			"userId": userId,
+			"role":   membership.Role,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
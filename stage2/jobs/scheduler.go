@@ -0,0 +1,79 @@
+// Package jobs runs periodic background work inside the server process.
+// Work that follows from a request goes through the outbox instead.
+package jobs
+
+import (
+	"log"
+	"time"
+
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/billing"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/outbox"
+	"gorm.io/gorm"
+)
+
+// How long finished outbox jobs are kept
+const outboxRetention = 7 * 24 * time.Hour
+
+// Start the periodic jobs. Call once the database is connected.
+func Start() {
+	Every("purge deleted accounts", time.Hour, PurgeDeletedAccounts)
+	Every("empty trash", 24*time.Hour, EmptyTrash)
+	Every("bill subscriptions", time.Hour, func() error {
+		now := time.Now()
+		if err := billing.Renew(database.DB.Db, now); err != nil {
+			return err
+		}
+		return billing.IssueInvoices(database.DB.Db, now)
+	})
+	Every("purge login challenges", time.Hour, func() error {
+		return database.DB.Db.Where("expires_at < ?", time.Now()).Delete(&models.MfaChallenge{}).Error
+	})
+	Every("prune outbox", 24*time.Hour, func() error {
+		return outbox.Prune(database.DB.Db, time.Now().Add(-outboxRetention))
+	})
+
+	if audit.SigningEnabled() {
+		Every("audit checkpoints", time.Hour, func() error {
+			return audit.WriteCheckpoints(database.DB.Db)
+		})
+	} else {
+		log.Println("AUDIT_SIGNING_KEY is not set, audit checkpoints are disabled")
+	}
+}
+
+// Run fn every interval in the background for the life of the process.
+// Every server runs the scheduler, so each run takes an advisory lock
+// named after the job and is skipped while another server holds it.
+func Every(name string, interval time.Duration, fn func() error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := runExclusive(database.DB.Db, name, fn); err != nil {
+				log.Printf("Job %q failed: %v", name, err)
+			}
+		}
+	}()
+}
+
+// Run fn while holding the job's advisory lock, or not at all if it is
+// held elsewhere. The lock belongs to a database session, so it is taken
+// and released on one connection.
+func runExclusive(db *gorm.DB, name string, fn func() error) error {
+	return db.Connection(func(conn *gorm.DB) error {
+		var locked bool
+		if err := conn.Raw("SELECT pg_try_advisory_lock(hashtext(?))", "job:"+name).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(hashtext(?))", "job:"+name)
+
+		return fn()
+	})
+}
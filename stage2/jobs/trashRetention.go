@@ -0,0 +1,145 @@
+package jobs
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+	"gorm.io/gorm"
+)
+
+const defaultTrashRetentionDays = 90
+
+// Hard delete users and organisations that have been in the trash for
+// longer than the retention period. One that can't be deleted is logged
+// and left for the next run.
+func EmptyTrash() error {
+	cutoff := time.Now().AddDate(0, 0, -trashRetentionDays())
+
+	var orgs []models.Organisation
+	err := database.DB.Db.Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at <= ?", cutoff).
+		Find(&orgs).Error
+	if err != nil {
+		return err
+	}
+
+	for _, org := range orgs {
+		if err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+			return DestroyOrganisation(tx, org)
+		}); err != nil {
+			log.Printf("Error destroying organisation %s: %v", org.ID, err)
+			continue
+		}
+
+		log.Printf("Destroyed organisation %s", org.ID)
+	}
+
+	var users []models.User
+	err = database.DB.Db.Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at <= ?", cutoff).
+		Find(&users).Error
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+			return DestroyUser(tx, user)
+		}); err != nil {
+			log.Printf("Error destroying user %s: %v", user.UserID, err)
+			continue
+		}
+
+		log.Printf("Destroyed user %s", user.UserID)
+	}
+
+	return nil
+}
+
+// Permanently delete an organisation and everything that belongs to it.
+// Audit events are append only and are kept, and the subscription, its
+// charges and invoices are kept as financial records.
+func DestroyOrganisation(tx *gorm.DB, org models.Organisation) error {
+	teams := tx.Unscoped().Model(&models.Team{}).Select("team_id").Where("organisation_id = ?", org.ID)
+	if err := tx.Where("team_id IN (?)", teams).Delete(&models.TeamMember{}).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Where("organisation_id = ?", org.ID).Delete(&models.Membership{}).Error; err != nil {
+		return err
+	}
+
+	deliveries := tx.Model(&models.WebhookDelivery{}).Select("delivery_id").Where("organisation_id = ?", org.ID)
+	if err := tx.Where("delivery_id IN (?)", deliveries).Delete(&models.WebhookAttempt{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("organisation_id = ?", org.ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+		return err
+	}
+
+	for _, model := range []interface{}{&models.Team{}, &models.OrgRole{}, &models.OwnershipTransfer{}, &models.ApiKey{}, &models.EmailTemplate{}, &models.WebhookEndpoint{}} {
+		if err := tx.Unscoped().Where("organisation_id = ?", org.ID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+
+	// The subscription is only soft deleted so its charges still lead to it
+	if err := tx.Where("organisation_id = ?", org.ID).Delete(&models.Subscription{}).Error; err != nil {
+		return err
+	}
+
+	return tx.Unscoped().Delete(&org).Error
+}
+
+// Permanently delete a user and everything that belongs to them.
+// Audit events are append only and are kept, without the user's
+// personal data.
+func DestroyUser(tx *gorm.DB, user models.User) error {
+	if err := handOverOrganisations(tx, user.UserID); err != nil {
+		return err
+	}
+
+	if err := removeMemberships(tx, user.UserID); err != nil {
+		return err
+	}
+
+	if err := tx.Where("user_id = ?", user.UserID).Delete(&models.TeamMember{}).Error; err != nil {
+		return err
+	}
+
+	err := tx.Unscoped().
+		Where("from_user_id = ? OR to_user_id = ?", user.UserID, user.UserID).
+		Delete(&models.OwnershipTransfer{}).Error
+	if err != nil {
+		return err
+	}
+
+	for _, model := range []interface{}{&models.Session{}, &models.ApiKey{}, &models.RecoveryCode{}, &models.EmailChange{}} {
+		if err := tx.Unscoped().Where("user_id = ?", user.UserID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Where("recipient = ?", user.Email).Delete(&models.OutboxEmail{}).Error; err != nil {
+		return err
+	}
+
+	if err := audit.EraseUser(tx, user.UserID); err != nil {
+		return err
+	}
+
+	return tx.Unscoped().Where("user_id = ?", user.UserID).Delete(&models.User{}).Error
+}
+
+func trashRetentionDays() int {
+	days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil || days < 0 {
+		return defaultTrashRetentionDays
+	}
+	return days
+}
@@ -0,0 +1,35 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+)
+
+func encodeHeader(value string) string {
+	return mime.QEncoding.Encode("utf-8", value)
+}
+
+func writeQuotedPrintable(w io.Writer, content string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func messageIdDomain(from string) string {
+	if address, err := mail.ParseAddress(from); err == nil {
+		if at := strings.LastIndex(address.Address, "@"); at >= 0 {
+			return address.Address[at+1:]
+		}
+	}
+	return "localhost"
+}
+
+func tlsConfig(host string) *tls.Config {
+	return &tls.Config{ServerName: host}
+}
@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// File writes each message to an .eml file in a directory, where it can
+// be opened with any mail client
+type File struct {
+	Dir string
+}
+
+func NewFile(dir string) *File {
+	return &File{Dir: dir}
+}
+
+func (f *File) Send(ctx context.Context, msg Message) error {
+	raw, err := Encode(msg)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(f.Dir, 0o755); err != nil {
+		return err
+	}
+
+	id := msg.ID
+	if id == "" {
+		id = uuid.NewString()
+	}
+	name := time.Now().UTC().Format("20060102T150405") + "-" + id + ".eml"
+
+	return os.WriteFile(filepath.Join(f.Dir, name), raw, 0o644)
+}
@@ -0,0 +1,128 @@
+// Package mailer sends email. Messages are rendered from templates,
+// queued in the outbox inside the caller's transaction and delivered in
+// the background by whichever Mailer backend is configured.
+//
+// Environment:
+//
+//	MAIL_DRIVER    smtp, file or memory (default smtp when SMTP_HOST is
+//	               set, otherwise memory)
+//	MAIL_FROM      sender address (default no-reply@localhost)
+//	SMTP_HOST      SMTP server host
+//	SMTP_PORT      SMTP server port (default 587)
+//	SMTP_USERNAME  SMTP login, if the server needs one
+//	SMTP_PASSWORD  SMTP password
+//	MAIL_FILE_DIR  directory the file driver writes .eml files to
+//	               (default ./mail)
+package mailer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/mryan-3/hng11/stage2/config"
+)
+
+// Message is a rendered email
+type Message struct {
+	ID      string `json:"id"`
+	To      string `json:"to"`
+	From    string `json:"from"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html,omitempty"`
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+var ErrNoRecipient = errors.New("email has no recipient")
+
+var (
+	mu      sync.RWMutex
+	current Mailer
+)
+
+// Use sets the mailer the outbox delivers with
+func Use(m Mailer) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = m
+}
+
+// Current is the mailer the outbox delivers with. Until one is configured
+// messages are captured in memory.
+func Current() Mailer {
+	mu.RLock()
+	m := current
+	mu.RUnlock()
+
+	if m != nil {
+		return m
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if current == nil {
+		current = NewMemory()
+	}
+	return current
+}
+
+// Captured is the in-memory mailer when that is the one in use, so dev
+// tools can show what would have been sent
+func Captured() (*Memory, bool) {
+	m, ok := Current().(*Memory)
+	return m, ok
+}
+
+var ErrNoMailer = errors.New("staging and production need MAIL_DRIVER=smtp and SMTP_HOST set")
+
+// Configure picks the mailer from the environment. Outside development
+// and tests the mailer has to deliver mail, so only smtp is allowed.
+func Configure() error {
+	driver := strings.ToLower(os.Getenv("MAIL_DRIVER"))
+	if driver == "" {
+		driver = "memory"
+		if os.Getenv("SMTP_HOST") != "" {
+			driver = "smtp"
+		}
+	}
+
+	if config.Env().IsDeployed() && driver != "smtp" {
+		return ErrNoMailer
+	}
+
+	switch driver {
+	case "smtp":
+		smtp, err := SMTPFromEnv()
+		if err != nil {
+			return err
+		}
+		Use(smtp)
+	case "file":
+		dir := os.Getenv("MAIL_FILE_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		Use(NewFile(dir))
+	case "memory":
+		Use(NewMemory())
+	default:
+		return errors.New("MAIL_DRIVER must be smtp, file or memory")
+	}
+
+	return nil
+}
+
+// Sender address for outgoing email
+func DefaultFrom() string {
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		return from
+	}
+	return "no-reply@localhost"
+}
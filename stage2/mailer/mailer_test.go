@@ -0,0 +1,237 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuiltInTemplatesRender(t *testing.T) {
+	tmpl, err := LookupTemplate("ownership_transfer")
+	if err != nil {
+		t.Fatalf("Failed to find template: %v", err)
+	}
+
+	msg, err := tmpl.Render(map[string]interface{}{
+		"firstName":        "Ada",
+		"organisationName": "Acme <Ltd>",
+		"link":             "https://example.com/transfer",
+	})
+	if err != nil {
+		t.Fatalf("Failed to render template: %v", err)
+	}
+
+	assert.Equal(t, "You've been offered ownership of Acme <Ltd>", msg.Subject)
+	assert.Contains(t, msg.Text, "Hi Ada,")
+	assert.Contains(t, msg.Text, "https://example.com/transfer")
+	assert.Contains(t, msg.HTML, "Acme &lt;Ltd&gt;")
+}
+
+func TestSystemTemplatesRenderTheirExamples(t *testing.T) {
+	templates := SystemTemplates()
+	assert.NotEmpty(t, templates)
+
+	for _, tmpl := range templates {
+		assert.NotEmpty(t, tmpl.Variables, tmpl.ID)
+
+		_, err := tmpl.Render(tmpl.SampleData())
+		assert.NoError(t, err, tmpl.ID)
+	}
+}
+
+func TestTemplateRejectsMissingVariables(t *testing.T) {
+	tmpl, _ := LookupTemplate("email_change")
+
+	_, err := tmpl.Render(map[string]interface{}{"firstName": "Ada"})
+	assert.Error(t, err)
+}
+
+func TestNewTemplateValidates(t *testing.T) {
+	name := []Variable{{Name: "name", Example: "Ada"}}
+
+	testCases := []struct {
+		name    string
+		subject string
+		body    string
+		html    string
+		vars    []Variable
+		part    string
+	}{
+		{"Subject doesn't parse", "{{.name}", "Hi", "", name, "subject"},
+		{"Body uses an undeclared variable", "Hello", "Hi {{.other}}", "", name, "body"},
+		{"HTML doesn't parse", "Hello", "Hi", "<p>{{if}}</p>", name, "html"},
+		{"Variable name is invalid", "Hello", "Hi", "", []Variable{{Name: "first-name"}}, "variables"},
+		{"Variable is declared twice", "Hello", "Hi", "", append(name, name...), "variables"},
+		{"Body loops", "Hello", "{{range .name}}Hi{{end}}", "", name, "body"},
+		{"Body loops inside a branch", "Hello", "{{if .name}}{{else}}{{range .name}}Hi{{end}}{{end}}", "", name, "body"},
+		{"Subject defines a template", `{{define "x"}}Hi{{end}}Hello`, "Hi", "", name, "subject"},
+		{"HTML calls a template", "Hello", "Hi", `<p>{{template "test" .}}</p>`, name, "html"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewTemplate("test", tc.subject, tc.body, tc.html, tc.vars)
+
+			var compileErr *CompileError
+			if assert.ErrorAs(t, err, &compileErr) {
+				assert.Equal(t, tc.part, compileErr.Part)
+			}
+		})
+	}
+
+	tmpl, err := NewTemplate("test", "Hello\n{{.name}}", "Hi {{.name}}", "", name)
+	if assert.NoError(t, err) {
+		msg, _ := tmpl.Render(map[string]interface{}{"name": "Bob"})
+		assert.Equal(t, "Hello Bob", msg.Subject)
+	}
+}
+
+func TestRenderIsSizeLimited(t *testing.T) {
+	tmpl, err := NewTemplate("test", "Hello", "{{.name}}{{.name}}", "", []Variable{{Name: "name", Example: "Ada"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = tmpl.Render(map[string]interface{}{"name": strings.Repeat("a", maxRenderedSize/2+1)})
+	assert.ErrorIs(t, err, ErrRenderTooLarge)
+}
+
+func TestLookupUnknownTemplate(t *testing.T) {
+	_, err := LookupTemplate("nope")
+	assert.ErrorIs(t, err, ErrUnknownTemplate)
+}
+
+func TestRetryDelayBacksOff(t *testing.T) {
+	assert.Equal(t, time.Minute, retryDelay(1))
+	assert.Equal(t, 2*time.Minute, retryDelay(2))
+	assert.Equal(t, 64*time.Minute, retryDelay(7))
+	assert.Equal(t, 6*time.Hour, retryDelay(20))
+}
+
+func TestSMTPDeliversToSink(t *testing.T) {
+	sink, err := ListenSink("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Failed to start sink: %v", err)
+	}
+	defer sink.Close()
+
+	smtp := &SMTP{
+		Host: "127.0.0.1",
+		Port: sink.Addr().(*net.TCPAddr).Port,
+	}
+
+	sent := Message{
+		ID:      "b1b1a4b6-6d0f-4a55-9b8f-2f7f2d9e0c11",
+		To:      "ada@example.com",
+		From:    "no-reply@example.com",
+		Subject: "Café opening",
+		Text:    "Hello\n.\nA line that starts with a dot and is longer than seventy six characters so it wraps\n",
+		HTML:    "<p>Hello</p>",
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := smtp.Send(ctx, sent); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	received, ok := sink.Find(sent.ID)
+	if assert.True(t, ok, "sink has message") {
+		assert.Equal(t, sent.To, received.To)
+		assert.Equal(t, sent.Subject, received.Subject)
+		assert.Equal(t, strings.ReplaceAll(sent.Text, "\n", "\r\n"), received.Text)
+		assert.Equal(t, sent.HTML, received.HTML)
+	}
+}
+
+func TestSMTPFromEnv(t *testing.T) {
+	t.Setenv("SMTP_HOST", "")
+	_, err := SMTPFromEnv()
+	assert.Error(t, err)
+
+	t.Setenv("SMTP_HOST", "mail.example.com")
+	t.Setenv("SMTP_PORT", "2525")
+	smtp, err := SMTPFromEnv()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "mail.example.com", smtp.Host)
+		assert.Equal(t, 2525, smtp.Port)
+	}
+}
+
+func TestConfigureNeedsSMTPWhenDeployed(t *testing.T) {
+	for _, env := range []string{"staging", "prod"} {
+		t.Setenv("APP_ENV", env)
+
+		t.Setenv("MAIL_DRIVER", "")
+		t.Setenv("SMTP_HOST", "")
+		assert.ErrorIs(t, Configure(), ErrNoMailer, env)
+
+		t.Setenv("MAIL_DRIVER", "memory")
+		assert.ErrorIs(t, Configure(), ErrNoMailer, env)
+	}
+
+	t.Setenv("APP_ENV", "dev")
+	t.Setenv("MAIL_DRIVER", "")
+	assert.NoError(t, Configure())
+	_, captured := Captured()
+	assert.True(t, captured)
+}
+
+func TestFileWritesEml(t *testing.T) {
+	dir := t.TempDir()
+
+	err := NewFile(dir).Send(context.Background(), Message{
+		ID:      "file-test",
+		To:      "ada@example.com",
+		From:    "no-reply@example.com",
+		Subject: "Hello",
+		Text:    "Hi\n",
+	})
+	if err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*-file-test.eml"))
+	if assert.Len(t, files, 1) {
+		raw, _ := os.ReadFile(files[0])
+		assert.Contains(t, string(raw), "To: ada@example.com\r\n")
+		assert.Contains(t, string(raw), "Subject: Hello\r\n")
+	}
+}
+
+func TestMemoryCapturesMessages(t *testing.T) {
+	memory := NewMemory()
+
+	assert.ErrorIs(t, memory.Send(context.Background(), Message{}), ErrNoRecipient)
+
+	memory.Send(context.Background(), Message{ID: "1", To: "ada@example.com"})
+	memory.Send(context.Background(), Message{ID: "2", To: "bob@example.com"})
+
+	assert.Len(t, memory.Messages(), 2)
+	msg, ok := memory.Find("2")
+	assert.True(t, ok)
+	assert.Equal(t, "bob@example.com", msg.To)
+
+	memory.Clear()
+	assert.Empty(t, memory.Messages())
+}
+
+func TestMemoryKeepsTheLatestMessages(t *testing.T) {
+	memory := NewMemory()
+
+	for i := 0; i < memoryCapacity+10; i++ {
+		memory.Send(context.Background(), Message{ID: strconv.Itoa(i), To: "ada@example.com"})
+	}
+
+	messages := memory.Messages()
+	assert.Len(t, messages, memoryCapacity)
+	assert.Equal(t, "10", messages[0].ID)
+}
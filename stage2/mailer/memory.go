@@ -0,0 +1,62 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory keeps sent messages in memory instead of delivering them. It is
+// the default in development, where the captured messages can be read
+// back through the dev email API.
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// Messages the in-memory mailer keeps. Older ones are dropped.
+const memoryCapacity = 500
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Send(ctx context.Context, msg Message) error {
+	if msg.To == "" {
+		return ErrNoRecipient
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	if len(m.messages) > memoryCapacity {
+		m.messages = append([]Message(nil), m.messages[len(m.messages)-memoryCapacity:]...)
+	}
+	return nil
+}
+
+// Messages captured so far, oldest first
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// Find a captured message by id
+func (m *Memory) Find(id string) (Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, msg := range m.messages {
+		if msg.ID == id {
+			return msg, true
+		}
+	}
+	return Message{}, false
+}
+
+// Forget every captured message
+func (m *Memory) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = nil
+}
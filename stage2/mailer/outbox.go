@@ -0,0 +1,169 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/outbox"
+	"gorm.io/gorm"
+)
+
+const (
+	// Emails still failing after this many attempts are given up on
+	MaxAttempts = 8
+	sendTimeout = 30 * time.Second
+)
+
+// JobSend is the outbox job that sends a queued email
+const JobSend = "email.send"
+
+// SendJob is the payload of a JobSend job
+type SendJob struct {
+	EmailID uuid.UUID `json:"emailId"`
+}
+
+func init() {
+	outbox.Register(JobSend, outbox.Handler{
+		Run:         sendJob,
+		MaxAttempts: MaxAttempts,
+		Backoff:     retryDelay,
+	})
+}
+
+// Queue an email in the outbox. Pass the transaction making the change
+// the email is about so it is only sent if that change commits. The
+// email is sent by the outbox workers.
+func Enqueue(tx *gorm.DB, msg Message, templateId string) (models.OutboxEmail, error) {
+	if msg.To == "" {
+		return models.OutboxEmail{}, ErrNoRecipient
+	}
+	if msg.From == "" {
+		msg.From = DefaultFrom()
+	}
+
+	email := models.OutboxEmail{
+		EmailID:       uuid.New(),
+		TemplateID:    templateId,
+		Recipient:     msg.To,
+		Sender:        msg.From,
+		Subject:       msg.Subject,
+		TextBody:      msg.Text,
+		HTMLBody:      msg.HTML,
+		Status:        models.EmailPending,
+		NextAttemptAt: time.Now(),
+	}
+
+	if err := tx.Create(&email).Error; err != nil {
+		return email, err
+	}
+
+	_, err := outbox.Enqueue(tx, JobSend, SendJob{EmailID: email.EmailID})
+	return email, err
+}
+
+// Render a template for one recipient and queue it. Emails sent on
+// behalf of an organisation use its version of the template if it has one.
+func EnqueueTemplate(tx *gorm.DB, orgId *uuid.UUID, to string, templateId string, data map[string]interface{}) error {
+	tmpl, err := ResolveTemplate(tx, orgId, templateId)
+	if err != nil {
+		return err
+	}
+
+	msg, err := tmpl.Render(data)
+	if err != nil {
+		return err
+	}
+	msg.To = to
+
+	_, err = Enqueue(tx, msg, templateId)
+	return err
+}
+
+// Send a queued email. Failed sends are retried by the outbox with
+// backoff; after MaxAttempts the email is marked failed.
+func sendJob(ctx context.Context, db *gorm.DB, job models.OutboxJob) error {
+	var payload SendJob
+	if err := outbox.Decode(job, &payload); err != nil {
+		return err
+	}
+
+	var email models.OutboxEmail
+	err := db.Where("email_id = ?", payload.EmailID).First(&email).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if email.Status != models.EmailPending {
+		return nil
+	}
+
+	return deliver(ctx, db, Current(), email)
+}
+
+// Send an email and record how it went. Returns the send error if the
+// email will be tried again.
+func deliver(ctx context.Context, db *gorm.DB, m Mailer, email models.OutboxEmail) error {
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	sendErr := m.Send(ctx, messageOf(email))
+	now := time.Now()
+
+	if sendErr == nil {
+		return db.Model(&email).Updates(map[string]interface{}{
+			"status":     models.EmailSent,
+			"attempts":   email.Attempts + 1,
+			"sent_at":    now,
+			"last_error": "",
+		}).Error
+	}
+
+	attempts := email.Attempts + 1
+	updates := map[string]interface{}{
+		"attempts":        attempts,
+		"last_error":      sendErr.Error(),
+		"next_attempt_at": now.Add(retryDelay(attempts)),
+	}
+	if attempts >= MaxAttempts {
+		updates["status"] = models.EmailFailed
+		log.Printf("Giving up on email %s to %s: %v", email.EmailID, email.Recipient, sendErr)
+	}
+
+	if err := db.Model(&email).Updates(updates).Error; err != nil {
+		return err
+	}
+	if attempts >= MaxAttempts {
+		return nil
+	}
+	return sendErr
+}
+
+// Wait a minute after the first failure, doubling up to six hours
+func retryDelay(attempts int) time.Duration {
+	delay := time.Minute
+	for i := 1; i < attempts && delay < 6*time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > 6*time.Hour {
+		delay = 6 * time.Hour
+	}
+	return delay
+}
+
+func messageOf(email models.OutboxEmail) Message {
+	return Message{
+		ID:      email.EmailID.String(),
+		To:      email.Recipient,
+		From:    email.Sender,
+		Subject: email.Subject,
+		Text:    email.TextBody,
+		HTML:    email.HTMLBody,
+	}
+}
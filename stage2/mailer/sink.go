@@ -0,0 +1,179 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+)
+
+// Sink is a minimal SMTP server that accepts every message and keeps it
+// in memory. Point SMTP_HOST at one to try the SMTP driver locally
+// without a real mail server.
+type Sink struct {
+	*Memory
+	listener net.Listener
+	received func(Message)
+}
+
+// Listen for SMTP connections on addr, e.g. "localhost:2525". received,
+// if given, is called with each message as it arrives.
+func ListenSink(addr string, received func(Message)) (*Sink, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &Sink{Memory: NewMemory(), listener: listener, received: received}
+	go sink.serve()
+	return sink, nil
+}
+
+// Address the sink is listening on
+func (s *Sink) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *Sink) Close() error {
+	return s.listener.Close()
+}
+
+func (s *Sink) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Sink) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		io.WriteString(conn, line+"\r\n")
+	}
+
+	reply("220 localhost ESMTP sink")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+
+		switch {
+		case strings.HasPrefix(command, "EHLO"):
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case strings.HasPrefix(command, "HELO"),
+			strings.HasPrefix(command, "MAIL FROM"),
+			strings.HasPrefix(command, "RCPT TO"),
+			strings.HasPrefix(command, "RSET"),
+			strings.HasPrefix(command, "NOOP"):
+			reply("250 OK")
+		case strings.HasPrefix(command, "AUTH"):
+			reply("235 Authenticated")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			raw, err := readData(r)
+			if err != nil {
+				return
+			}
+			if msg, err := parseMessage(raw); err == nil {
+				s.Send(context.Background(), msg)
+				if s.received != nil {
+					s.received(msg)
+				}
+			}
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// Read a DATA section up to the lone "." line, undoing dot stuffing
+func readData(r *bufio.Reader) (string, error) {
+	var data strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if strings.TrimRight(line, "\r\n") == "." {
+			return data.String(), nil
+		}
+		data.WriteString(strings.TrimPrefix(line, "."))
+	}
+}
+
+// Turn a raw RFC 5322 message back into a Message
+func parseMessage(raw string) (Message, error) {
+	parsed, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		return Message{}, err
+	}
+
+	decoder := new(mime.WordDecoder)
+	subject, err := decoder.DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		subject = parsed.Header.Get("Subject")
+	}
+
+	msg := Message{
+		To:      parsed.Header.Get("To"),
+		From:    parsed.Header.Get("From"),
+		Subject: subject,
+	}
+	if id := parsed.Header.Get("Message-ID"); id != "" {
+		id = strings.Trim(id, "<>")
+		msg.ID, _, _ = strings.Cut(id, "@")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		msg.Text, err = readPart(parsed.Body, parsed.Header.Get("Content-Transfer-Encoding"))
+		return msg, err
+	}
+
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+	for {
+		part, err := parts.NextRawPart()
+		if err == io.EOF {
+			return msg, nil
+		}
+		if err != nil {
+			return msg, err
+		}
+
+		content, err := readPart(part, part.Header.Get("Content-Transfer-Encoding"))
+		if err != nil {
+			return msg, err
+		}
+
+		if strings.HasPrefix(part.Header.Get("Content-Type"), "text/html") {
+			msg.HTML = content
+		} else {
+			msg.Text = content
+		}
+	}
+}
+
+func readPart(r io.Reader, encoding string) (string, error) {
+	if strings.EqualFold(encoding, "quoted-printable") {
+		r = quotedprintable.NewReader(r)
+	}
+	content, err := io.ReadAll(r)
+	return string(content), err
+}
@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/models"
+	"gorm.io/gorm"
+)
+
+// Compile an organisation's stored template. Overrides take the
+// variables of the system template they replace.
+func OrgTemplate(stored models.EmailTemplate) (*Template, error) {
+	var variables []Variable
+
+	if system, err := LookupTemplate(stored.TemplateID); err == nil {
+		variables = system.Variables
+	} else if stored.Variables != "" {
+		if err := json.Unmarshal([]byte(stored.Variables), &variables); err != nil {
+			return nil, &CompileError{"variables", err}
+		}
+	}
+
+	return NewTemplate(stored.TemplateID, stored.Subject, stored.Body, stored.HTML, variables)
+}
+
+// Find the template an organisation sends for an id: its own if it has
+// one, otherwise the system template. Without an organisation only
+// system templates are used.
+func ResolveTemplate(db *gorm.DB, orgId *uuid.UUID, id string) (*Template, error) {
+	if orgId != nil {
+		var stored models.EmailTemplate
+		err := db.Where("organisation_id = ? AND template_id = ?", *orgId, id).First(&stored).Error
+
+		if err == nil {
+			return OrgTemplate(stored)
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	return LookupTemplate(id)
+}
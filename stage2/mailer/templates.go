@@ -0,0 +1,308 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"text/template/parse"
+	"time"
+)
+
+// The system templates. Each lives in templates/<template_id>/ with a
+// subject.txt, a body.txt, an optional body.html and a variables.json
+// declaring the variables the template can use.
+//
+//go:embed templates
+var templateFiles embed.FS
+
+var (
+	ErrUnknownTemplate = errors.New("unknown email template")
+	ErrRenderTooLarge  = errors.New("the rendered email is too large")
+	ErrRenderTimeout   = errors.New("rendering the email took too long")
+)
+
+// Limits on rendering a template. Organisations write their own
+// templates, so one can't be allowed to make huge emails or tie up the
+// process rendering them.
+const (
+	maxRenderedSize = 256 << 10
+	renderTimeout   = 2 * time.Second
+)
+
+// Variable is one piece of data a template can be rendered with. Every
+// declared variable must be given when rendering.
+type Variable struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Example     string `json:"example"`
+}
+
+// Template is a compiled email template
+type Template struct {
+	ID        string
+	Subject   string
+	Body      string
+	HTML      string
+	Variables []Variable
+
+	subject *texttemplate.Template
+	body    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// CompileError says which part of a template failed to compile
+type CompileError struct {
+	Part string // subject, body, html or variables
+	Err  error
+}
+
+func (e *CompileError) Error() string {
+	return e.Part + ": " + e.Err.Error()
+}
+
+func (e *CompileError) Unwrap() error {
+	return e.Err
+}
+
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Compile a template from its sources. The template is also rendered
+// with its variables' examples, so a template that uses a variable it
+// doesn't declare fails here rather than when an email is sent.
+func NewTemplate(id string, subject string, body string, html string, variables []Variable) (*Template, error) {
+	tmpl := &Template{ID: id, Subject: subject, Body: body, HTML: html, Variables: variables}
+
+	seen := map[string]bool{}
+	for _, variable := range variables {
+		if !variableName.MatchString(variable.Name) {
+			return nil, &CompileError{"variables", fmt.Errorf("%q is not a valid variable name", variable.Name)}
+		}
+		if seen[variable.Name] {
+			return nil, &CompileError{"variables", fmt.Errorf("%q is declared twice", variable.Name)}
+		}
+		seen[variable.Name] = true
+	}
+
+	var err error
+	if tmpl.subject, err = texttemplate.New(id).Option("missingkey=error").Parse(subject); err != nil {
+		return nil, &CompileError{"subject", err}
+	}
+	if err := checkActions(tmpl.subject.Tree, len(tmpl.subject.Templates())); err != nil {
+		return nil, &CompileError{"subject", err}
+	}
+	if tmpl.body, err = texttemplate.New(id).Option("missingkey=error").Parse(body); err != nil {
+		return nil, &CompileError{"body", err}
+	}
+	if err := checkActions(tmpl.body.Tree, len(tmpl.body.Templates())); err != nil {
+		return nil, &CompileError{"body", err}
+	}
+	if strings.TrimSpace(html) != "" {
+		if tmpl.html, err = htmltemplate.New(id).Option("missingkey=error").Parse(html); err != nil {
+			return nil, &CompileError{"html", err}
+		}
+		if err := checkActions(tmpl.html.Tree, len(tmpl.html.Templates())); err != nil {
+			return nil, &CompileError{"html", err}
+		}
+	}
+
+	if _, err := tmpl.Render(tmpl.SampleData()); err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+// Check a parsed template only fills in variables and chooses between
+// parts. Loops and other templates are refused, so how long a template
+// takes to render is bounded by its size.
+func checkActions(tree *parse.Tree, templates int) error {
+	if templates > 1 {
+		return errors.New("{{define}} and {{block}} aren't allowed")
+	}
+	if tree == nil {
+		return nil
+	}
+	return checkNode(tree.Root)
+}
+
+func checkNode(node parse.Node) error {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return nil
+		}
+		for _, child := range node.Nodes {
+			if err := checkNode(child); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return checkBranch(node.BranchNode)
+	case *parse.WithNode:
+		return checkBranch(node.BranchNode)
+	case *parse.RangeNode:
+		return errors.New("{{range}} isn't allowed")
+	case *parse.TemplateNode:
+		return errors.New("{{template}} isn't allowed")
+	}
+	return nil
+}
+
+func checkBranch(branch parse.BranchNode) error {
+	if err := checkNode(branch.List); err != nil {
+		return err
+	}
+	return checkNode(branch.ElseList)
+}
+
+// A buffer that refuses writes once it holds too much or its deadline
+// has passed. A template executing into it stops at the failed write.
+type limitedBuffer struct {
+	bytes.Buffer
+	deadline time.Time
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if time.Now().After(b.deadline) {
+		return 0, ErrRenderTimeout
+	}
+	if b.Len()+len(p) > maxRenderedSize {
+		return 0, ErrRenderTooLarge
+	}
+	return b.Buffer.Write(p)
+}
+
+// Example data for every declared variable
+func (t *Template) SampleData() map[string]interface{} {
+	data := map[string]interface{}{}
+	for _, variable := range t.Variables {
+		data[variable.Name] = variable.Example
+	}
+	return data
+}
+
+// Render a message from the template. To and From are left for the
+// caller to fill in.
+func (t *Template) Render(data map[string]interface{}) (Message, error) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+
+	deadline := time.Now().Add(renderTimeout)
+	subject := &limitedBuffer{deadline: deadline}
+	body := &limitedBuffer{deadline: deadline}
+	html := &limitedBuffer{deadline: deadline}
+
+	if err := t.subject.Execute(subject, data); err != nil {
+		return Message{}, &CompileError{"subject", err}
+	}
+	if err := t.body.Execute(body, data); err != nil {
+		return Message{}, &CompileError{"body", err}
+	}
+	if t.html != nil {
+		if err := t.html.Execute(html, data); err != nil {
+			return Message{}, &CompileError{"html", err}
+		}
+	}
+
+	return Message{
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Text:    strings.TrimSpace(body.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
+
+var systemTemplates = mustLoadTemplates()
+
+func mustLoadTemplates() map[string]*Template {
+	loaded, err := loadTemplates(templateFiles)
+	if err != nil {
+		panic(err)
+	}
+	return loaded
+}
+
+func loadTemplates(files fs.FS) (map[string]*Template, error) {
+	dirs, err := fs.ReadDir(files, "templates")
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := map[string]*Template{}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		id := dir.Name()
+
+		read := func(name string) (string, error) {
+			content, err := fs.ReadFile(files, path.Join("templates", id, name))
+			return string(content), err
+		}
+
+		subject, err := read("subject.txt")
+		if err != nil {
+			return nil, err
+		}
+		body, err := read("body.txt")
+		if err != nil {
+			return nil, err
+		}
+		html, err := read("body.html")
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		var variables []Variable
+		raw, err := read("variables.json")
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if raw != "" {
+			if err := json.Unmarshal([]byte(raw), &variables); err != nil {
+				return nil, fmt.Errorf("%s: variables.json: %w", id, err)
+			}
+		}
+
+		tmpl, err := NewTemplate(id, subject, body, html, variables)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", id, err)
+		}
+		loaded[id] = tmpl
+	}
+
+	return loaded, nil
+}
+
+// Look up a system template
+func LookupTemplate(id string) (*Template, error) {
+	tmpl, ok := systemTemplates[id]
+	if !ok {
+		return nil, ErrUnknownTemplate
+	}
+	return tmpl, nil
+}
+
+// The system templates, by id
+func SystemTemplates() []*Template {
+	ids := make([]string, 0, len(systemTemplates))
+	for id := range systemTemplates {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	list := make([]*Template, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, systemTemplates[id])
+	}
+	return list
+}
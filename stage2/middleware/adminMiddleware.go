@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+)
+
+// Allow only platform admins. Must run after UserAuth.
+func PlatformAdmin(c *fiber.Ctx) error {
+	var user models.User
+	if err := database.DB.Db.Where("user_id = ?", c.Locals("userId")).First(&user).Error; err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "Unauthorized",
+		})
+	}
+
+	if !user.IsPlatformAdmin() {
+		return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusForbidden,
+			"message":    "Only platform admins can do this",
+		})
+	}
+
+	return c.Next()
+}
@@ -1,7 +1,10 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/mryan-3/hng11/stage2/database"
@@ -9,12 +12,25 @@ import (
 	"github.com/mryan-3/hng11/stage2/utils"
 )
 
-// Allow only authenticated user
+// How the request was authenticated, stored in c.Locals("authMethod")
+const (
+	AuthMethodCookie = "cookie"
+	AuthMethodBearer = "bearer"
+	AuthMethodApiKey = "apiKey"
+)
+
+// Allow only authenticated user. API keys are not accepted here,
+// routes open to machine clients use ApiAuth instead.
 func UserAuth(c *fiber.Ctx) error {
-	// Get jwt from cookie
-	userJwt := c.Cookies("user")
+	// Prefer a bearer token, fall back to the jwt cookie
+	token, method := bearerToken(c), AuthMethodBearer
+	if token == "" {
+		// A cookie that doesn't decrypt is treated as missing
+		token, _ = utils.DecryptCookie(c.Cookies("user"))
+		method = AuthMethodCookie
+	}
 
-	if userJwt == "" {
+	if token == "" {
 		return c.Status(http.StatusUnauthorized).JSON(&fiber.Map{
 			"status":  "error",
 			"message": "Unauthorized",
@@ -22,9 +38,11 @@ func UserAuth(c *fiber.Ctx) error {
 	}
 
 	// Verify jwt
-	userId, isValid, err := utils.VerifyJwtToken(userJwt)
+	userId, isValid, err := utils.VerifyJwtToken(token)
 
-	if err != nil || !isValid {
+	// Tokens issued for a specific purpose (e.g. an MFA challenge)
+	// can't be used to access the API
+	if err != nil || !isValid || userId["purpose"] != nil {
 		return c.Status(http.StatusUnauthorized).JSON(&fiber.Map{
 			"status":  "error",
 			"message": "Unauthorized",
@@ -42,10 +60,128 @@ func UserAuth(c *fiber.Ctx) error {
 
 	}
 
+	// The token's session must still be live
+	var session models.Session
+	sessionId, _ := userId["sid"].(string)
+	result = database.DB.Db.First(&session, "session_id = ? AND user_id = ?", sessionId, user.UserID)
+
+	if sessionId == "" || result.Error != nil || !session.Active() {
+		return c.Status(http.StatusUnauthorized).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "Unauthorized",
+		})
+	}
+
+	// Record activity at most once a minute to keep writes down
+	now := time.Now()
+	if session.LastSeenAt.Before(now.Add(-time.Minute)) {
+		database.DB.Db.Model(&session).Updates(map[string]interface{}{
+			"last_seen_at": now,
+			"ip":           c.IP(),
+		})
+	}
+
 	// Set user id in context
 	c.Locals("userId", user.UserID.String())
+	c.Locals("sessionId", session.SessionID.String())
+	c.Locals("authMethod", method)
+
+	return CSRFProtection(c)
+}
+
+// Allow an authenticated user or a machine client with an API key
+// carrying the given scope. Organisation keys are further limited to
+// routes for their own organisation.
+func ApiAuth(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := bearerToken(c)
+		if !utils.IsApiKey(key) {
+			return UserAuth(c)
+		}
+
+		apiKey, user, ok := findApiKey(key)
+		if !ok {
+			return c.Status(http.StatusUnauthorized).JSON(&fiber.Map{
+				"status":  "error",
+				"message": "Unauthorized",
+			})
+		}
+
+		if !apiKey.HasScope(scope) {
+			return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+				"status":     "error",
+				"statusCode": http.StatusForbidden,
+				"message":    "API key is missing the " + scope + " scope",
+			})
+		}
+
+		if apiKey.OrganisationID != nil && c.Params("orgId") != apiKey.OrganisationID.String() {
+			return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+				"status":     "error",
+				"statusCode": http.StatusForbidden,
+				"message":    "API key is restricted to another organisation",
+			})
+		}
+
+		// Record usage at most once a minute to keep writes down
+		now := time.Now()
+		database.DB.Db.Model(&models.ApiKey{}).
+			Where("key_id = ? AND (last_used_at IS NULL OR last_used_at < ?)", apiKey.KeyID, now.Add(-time.Minute)).
+			Update("last_used_at", now)
 
-	return c.Next()
+		// The request acts as the user who created the key
+		c.Locals("userId", user.UserID.String())
+		c.Locals("authMethod", AuthMethodApiKey)
 
+		return c.Next()
+	}
+}
+
+// Look up a live API key and the user who owns it
+func findApiKey(key string) (models.ApiKey, models.User, bool) {
+	var apiKey models.ApiKey
+	var user models.User
+
+	prefix, ok := utils.ApiKeyPrefixOf(key)
+	if !ok {
+		return apiKey, user, false
+	}
+
+	if err := database.DB.Db.First(&apiKey, "prefix = ?", prefix).Error; err != nil {
+		return apiKey, user, false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(utils.HashApiKey(key))) != 1 || apiKey.Expired() {
+		return apiKey, user, false
+	}
+
+	if err := database.DB.Db.First(&user, "user_id=?", apiKey.UserID).Error; err != nil {
+		return apiKey, user, false
+	}
+
+	// Keys stop working while their creator's account is pending deletion
+	if user.DeletionScheduledAt != nil {
+		return apiKey, user, false
+	}
+
+	// Organisation keys stop working once their creator leaves the organisation
+	if apiKey.OrganisationID != nil {
+		var membership models.Membership
+		err := database.DB.Db.Where("organisation_id = ? AND user_user_id = ?", apiKey.OrganisationID, user.UserID).First(&membership).Error
+		if err != nil {
+			return apiKey, user, false
+		}
+	}
+
+	return apiKey, user, true
 }
 
+// Get the token from an "Authorization: Bearer <token>" header
+func bearerToken(c *fiber.Ctx) string {
+	header := c.Get(fiber.HeaderAuthorization)
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+
+	return ""
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mryan-3/hng11/stage2/utils"
+)
+
+// Header cookie authenticated clients send the CSRF token in
+const HeaderCSRFToken = "X-CSRF-Token"
+
+// Reject state changing requests authenticated by the auth cookie unless
+// they carry the session's CSRF token. Bearer tokens and API keys can't
+// be sent by a browser on its own, so those requests are let through.
+// UserAuth runs this after authenticating.
+func CSRFProtection(c *fiber.Ctx) error {
+	if c.Locals("authMethod") != AuthMethodCookie || isSafeMethod(c.Method()) {
+		return c.Next()
+	}
+
+	sessionId, _ := c.Locals("sessionId").(string)
+
+	if !utils.ValidCsrfToken(sessionId, c.Get(HeaderCSRFToken)) {
+		return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusForbidden,
+			"message":    "Invalid CSRF token",
+		})
+	}
+
+	return c.Next()
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mryan-3/hng11/stage2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// App that fakes authentication, then applies CSRF protection
+func setupCsrfTestApp(authMethod string) *fiber.App {
+	app := fiber.New()
+
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("authMethod", authMethod)
+		c.Locals("sessionId", "test-session")
+		return c.Next()
+	}, CSRFProtection)
+
+	app.All("/organisations", func(c *fiber.Ctx) error {
+		return c.SendStatus(http.StatusOK)
+	})
+
+	return app
+}
+
+func TestCSRFProtection(t *testing.T) {
+	t.Setenv("JWT_SECRET", "your-secret-key")
+
+	validToken := utils.CsrfToken("test-session")
+	otherSessionToken := utils.CsrfToken("other-session")
+
+	testCases := []struct {
+		name       string
+		authMethod string
+		method     string
+		token      string
+		expected   int
+	}{
+		{"Cookie POST without token is rejected", AuthMethodCookie, http.MethodPost, "", http.StatusForbidden},
+		{"Cookie POST with wrong token is rejected", AuthMethodCookie, http.MethodPost, "not-a-token", http.StatusForbidden},
+		{"Cookie POST with another session's token is rejected", AuthMethodCookie, http.MethodPost, otherSessionToken, http.StatusForbidden},
+		{"Cookie DELETE without token is rejected", AuthMethodCookie, http.MethodDelete, "", http.StatusForbidden},
+		{"Cookie POST with valid token is allowed", AuthMethodCookie, http.MethodPost, validToken, http.StatusOK},
+		{"Cookie GET without token is allowed", AuthMethodCookie, http.MethodGet, "", http.StatusOK},
+		{"Bearer POST without token is allowed", AuthMethodBearer, http.MethodPost, "", http.StatusOK},
+		{"API key POST without token is allowed", AuthMethodApiKey, http.MethodPost, "", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app := setupCsrfTestApp(tc.authMethod)
+
+			req := httptest.NewRequest(tc.method, "/organisations", nil)
+			if tc.token != "" {
+				req.Header.Set(HeaderCSRFToken, tc.token)
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to perform request: %v", err)
+			}
+
+			assert.Equal(t, tc.expected, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/policy"
+)
+
+// Allow only members of the organisation in the :orgId param whose role
+// grants the permission. Must run after UserAuth or ApiAuth.
+func RequirePermission(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		permissions, ok, err := orgPermissions(c)
+		if !ok {
+			return err
+		}
+
+		if !permissions.Has(permission) {
+			return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+				"status":     "error",
+				"statusCode": http.StatusForbidden,
+				"message":    "You need the " + permission + " permission",
+			})
+		}
+
+		c.Locals("permissions", permissions)
+
+		return c.Next()
+	}
+}
+
+// Like RequirePermission, but members acting on themselves, named by the
+// :userId param, don't need the permission. Must run after UserAuth or
+// ApiAuth.
+func RequirePermissionOrSelf(permission string) fiber.Handler {
+	requirePermission := RequirePermission(permission)
+
+	return func(c *fiber.Ctx) error {
+		userId, _ := c.Locals("userId").(string)
+		if userId != "" && c.Params("userId") == userId {
+			return RequireMember(c)
+		}
+
+		return requirePermission(c)
+	}
+}
+
+// Allow any member of the organisation in the :orgId param, for routes
+// whose handlers check more than the member's role, e.g. team leads.
+// Must run after UserAuth or ApiAuth.
+func RequireMember(c *fiber.Ctx) error {
+	permissions, ok, err := orgPermissions(c)
+	if !ok {
+		return err
+	}
+
+	c.Locals("permissions", permissions)
+
+	return c.Next()
+}
+
+// The permissions the user holds in the :orgId organisation. If they
+// hold none, ok is false and the error response has been sent.
+// Organisations in the trash are not found.
+func orgPermissions(c *fiber.Ctx) (policy.Set, bool, error) {
+	userId, _ := c.Locals("userId").(string)
+
+	_, permissions, err := policy.OrgPermissions(database.DB.Db, c.Params("orgId"), userId)
+	if errors.Is(err, policy.ErrOrgNotFound) {
+		return nil, false, c.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusNotFound,
+			"message":    "Organisation not found",
+		})
+	}
+	if errors.Is(err, policy.ErrNotMember) {
+		return nil, false, c.Status(http.StatusForbidden).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusForbidden,
+			"message":    "You are not a member of this organisation",
+		})
+	}
+	if err != nil {
+		return nil, false, c.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "An error occurred while checking permissions",
+		})
+	}
+
+	return permissions, true, nil
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mryan-3/hng11/stage2/database"
+	"github.com/mryan-3/hng11/stage2/models"
+)
+
+// Enforce the two-factor policy of the organisation in the :orgId param.
+// Must run after UserAuth.
+func OrgTwoFactorPolicy(c *fiber.Ctx) error {
+	var org models.Organisation
+	if err := database.DB.Db.Select("id", "require_two_factor").Where("id = ?", c.Params("orgId")).First(&org).Error; err != nil {
+		// Let the handler respond with its own not found error
+		return c.Next()
+	}
+
+	if !org.RequireTwoFactor {
+		return c.Next()
+	}
+
+	var user models.User
+	if err := database.DB.Db.Select("user_id", "two_factor_enabled").Where("user_id = ?", c.Locals("userId")).First(&user).Error; err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(&fiber.Map{
+			"status":  "error",
+			"message": "Unauthorized",
+		})
+	}
+
+	if !user.TwoFactorEnabled {
+		return c.Status(http.StatusForbidden).JSON(&fiber.Map{
+			"status":     "error",
+			"statusCode": http.StatusForbidden,
+			"message":    "This organisation requires two-factor authentication",
+		})
+	}
+
+	return c.Next()
+}
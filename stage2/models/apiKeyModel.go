@@ -0,0 +1,48 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// API key scopes
+const (
+	ScopeOrganisationsRead  = "organisations:read"
+	ScopeOrganisationsWrite = "organisations:write"
+	ScopeUsersRead          = "users:read"
+)
+
+// ApiKey is a long lived credential for machine clients. Keys belong to
+// the user who created them and can optionally be restricted to one
+// organisation. Only a hash of the key is stored.
+type ApiKey struct {
+	Base
+	KeyID          uuid.UUID  `json:"keyId" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	Name           string     `json:"name" gorm:"type:varchar(255);not null"`
+	Prefix         string     `json:"prefix" gorm:"type:varchar(32);not null;uniqueIndex"`
+	KeyHash        string     `json:"-" gorm:"type:varchar(64);not null"`
+	Scopes         string     `json:"-" gorm:"type:text;not null"` // space separated
+	UserID         uuid.UUID  `json:"userId" gorm:"type:uuid;not null;index"`
+	OrganisationID *uuid.UUID `json:"orgId" gorm:"type:uuid;index"`
+	ExpiresAt      *time.Time `json:"expiresAt"`
+	LastUsedAt     *time.Time `json:"lastUsedAt"`
+}
+
+func (k ApiKey) ScopeList() []string {
+	return strings.Fields(k.Scopes)
+}
+
+func (k ApiKey) HasScope(scope string) bool {
+	for _, s := range k.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (k ApiKey) Expired() bool {
+	return k.ExpiresAt != nil && k.ExpiresAt.Before(time.Now())
+}
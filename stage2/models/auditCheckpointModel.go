@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// A signed statement of the head of an audit chain at a point in time.
+// Verifying against checkpoints catches events removed from the end of a
+// chain, which the hashes alone can't.
+type AuditCheckpoint struct {
+	ID        uint      `json:"-" gorm:"primarykey"`
+	Chain     string    `json:"chain" gorm:"type:varchar(64);not null;index"`
+	Sequence  int64     `json:"sequence" gorm:"not null"`
+	Hash      string    `json:"hash" gorm:"type:varchar(64);not null"`
+	Signature string    `json:"signature" gorm:"type:varchar(128);not null"`
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var ErrAuditEventImmutable = errors.New("audit events can't be changed or deleted")
+
+// A record of a security relevant change. Audit events are append only:
+// they are written in the same transaction as the change they describe
+// and are never updated or deleted.
+//
+// Each organisation's events form a hash chain, as do events that belong
+// to no organisation. An event's hash covers its contents and the hash of
+// the event before it, so editing or removing one breaks every later link.
+//
+// Personal data, such as the IP address a request came from, is kept
+// out of the event in its AuditEventDetail so it can be erased. The event
+// holds a salted digest of the detail in its place.
+type AuditEvent struct {
+	ID             uint       `json:"-" gorm:"primarykey"`
+	EventID        uuid.UUID  `json:"eventId" gorm:"type:uuid;default:gen_random_uuid();uniqueIndex"`
+	OrganisationID *uuid.UUID `json:"orgId" gorm:"type:uuid;index"`
+	ActorID        *uuid.UUID `json:"actorId" gorm:"type:uuid;index"`
+	Action         string     `json:"action" gorm:"type:varchar(100);not null;index"`
+	TargetType     string     `json:"targetType" gorm:"type:varchar(50)"`
+	TargetID       string     `json:"targetId" gorm:"type:varchar(255)"`
+	Metadata       string     `json:"metadata" gorm:"type:jsonb;not null;default:'{}'"`
+	DetailsHash    string     `json:"-" gorm:"type:varchar(64);not null;default:''"`
+	CreatedAt      time.Time  `json:"createdAt" gorm:"index"`
+
+	Detail *AuditEventDetail `json:"-" gorm:"foreignKey:EventID;references:EventID"`
+
+	Chain    string `json:"chain" gorm:"type:varchar(64);not null;default:'';index:idx_audit_events_chain_sequence"`
+	Sequence int64  `json:"sequence" gorm:"not null;default:0;index:idx_audit_events_chain_sequence"`
+	PrevHash string `json:"prevHash" gorm:"type:varchar(64);not null;default:''"`
+	Hash     string `json:"hash" gorm:"type:varchar(64);not null;default:''"`
+	// Events hashed before personal data was kept apart. Their hash covers
+	// their IP address and user agent rather than DetailsHash.
+	LegacyHash bool `json:"-" gorm:"not null;default:false"`
+}
+
+func (AuditEvent) BeforeUpdate(*gorm.DB) error {
+	return ErrAuditEventImmutable
+}
+
+func (AuditEvent) BeforeDelete(*gorm.DB) error {
+	return ErrAuditEventImmutable
+}
+
+// AuditEventDetail is the personal data of an audit event. Unlike the
+// event it can be deleted, which is how a user's data is erased from the
+// log when their account is purged.
+type AuditEventDetail struct {
+	EventID   uuid.UUID `gorm:"type:uuid;primaryKey"`
+	IP        string    `gorm:"type:varchar(64)"`
+	UserAgent string    `gorm:"type:varchar(512)"`
+	Personal  string    `gorm:"type:jsonb;not null;default:'{}'"`
+	// Salts the digest kept on the event, so once the detail is gone the
+	// digest can't be used to guess what it was
+	Salt      string `gorm:"type:varchar(64);not null"`
+	CreatedAt time.Time
+}
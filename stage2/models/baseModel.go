@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Base holds the timestamps and soft delete column for models keyed by a
+// UUID. Unlike gorm.Model it brings no id of its own, so the embedding
+// model's UUID is its only primary key.
+type Base struct {
+	CreatedAt time.Time      `json:"createdAt"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm/schema"
+)
+
+func parseSchema(t *testing.T, model interface{}) *schema.Schema {
+	s, err := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+	return s
+}
+
+func TestModelsHaveASingleUuidPrimaryKey(t *testing.T) {
+	user := parseSchema(t, &User{})
+	if assert.Len(t, user.PrimaryFields, 1) {
+		assert.Equal(t, "user_id", user.PrimaryFields[0].DBName)
+	}
+	assert.Nil(t, user.LookUpField("id"))
+
+	org := parseSchema(t, &Organisation{})
+	if assert.Len(t, org.PrimaryFields, 1) {
+		assert.Equal(t, "id", org.PrimaryFields[0].DBName)
+		assert.Equal(t, "uuid", string(org.PrimaryFields[0].DataType))
+	}
+
+	keys := map[string]interface{}{
+		"key_id":          &ApiKey{},
+		"session_id":      &Session{},
+		"transfer_id":     &OwnershipTransfer{},
+		"team_id":         &Team{},
+		"role_id":         &OrgRole{},
+		"payment_id":      &Payment{},
+		"refund_id":       &PaymentRefund{},
+		"subscription_id": &Subscription{},
+		"invoice_id":      &Invoice{},
+		"endpoint_id":     &WebhookEndpoint{},
+		"delivery_id":     &WebhookDelivery{},
+		"email_id":        &OutboxEmail{},
+		"job_id":          &OutboxJob{},
+		"challenge_id":    &MfaChallenge{},
+	}
+	for key, model := range keys {
+		s := parseSchema(t, model)
+		if assert.Len(t, s.PrimaryFields, 1, s.Table) {
+			assert.Equal(t, key, s.PrimaryFields[0].DBName, s.Table)
+		}
+		assert.Nil(t, s.LookUpField("id"), s.Table)
+	}
+}
+
+func TestForeignKeysAreUuids(t *testing.T) {
+	columns := map[string]interface{}{
+		"payment_id":      &PaymentRefund{},
+		"subscription_id": &SubscriptionCharge{},
+		"invoice_id":      &InvoiceLine{},
+		"endpoint_id":     &WebhookDelivery{},
+		"delivery_id":     &WebhookAttempt{},
+	}
+	for column, model := range columns {
+		s := parseSchema(t, model)
+		assert.Equal(t, "uuid", string(s.LookUpField(column).DataType), s.Table)
+	}
+
+	assert.Equal(t, "uuid", string(parseSchema(t, &SubscriptionCharge{}).LookUpField("invoice_id").DataType))
+}
+
+func TestMembershipMatchesTheJoinTable(t *testing.T) {
+	joinTable := parseSchema(t, &User{}).Relationships.Relations["Organisations"].JoinTable
+	membership := parseSchema(t, &Membership{})
+
+	joinColumns := []string{}
+	for _, field := range joinTable.PrimaryFields {
+		joinColumns = append(joinColumns, field.DBName)
+	}
+
+	membershipColumns := []string{}
+	for _, field := range membership.PrimaryFields {
+		membershipColumns = append(membershipColumns, field.DBName)
+	}
+
+	assert.Equal(t, []string{"user_user_id", "organisation_id"}, joinColumns)
+	assert.ElementsMatch(t, joinColumns, membershipColumns)
+}
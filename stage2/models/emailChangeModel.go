@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// EmailChange is a pending change of a user's email address, applied
+// once the token sent to the new address is confirmed
+type EmailChange struct {
+	gorm.Model
+	UserID      uuid.UUID `gorm:"type:uuid;not null;index"`
+	NewEmail    string    `gorm:"not null"`
+	TokenHash   string    `gorm:"type:varchar(64);not null;uniqueIndex"`
+	ExpiresAt   time.Time `gorm:"not null"`
+	ConfirmedAt *time.Time
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// An organisation's own email template. One with the id of a system
+// template overrides it for the organisation's emails; any other id is a
+// template of the organisation's own.
+type EmailTemplate struct {
+	gorm.Model
+	OrganisationID uuid.UUID `json:"orgId" gorm:"type:uuid;not null;uniqueIndex:idx_email_templates_org_template"`
+	TemplateID     string    `json:"templateId" gorm:"type:varchar(100);not null;uniqueIndex:idx_email_templates_org_template"`
+	Subject        string    `json:"subject" gorm:"type:text;not null"`
+	Body           string    `json:"body" gorm:"type:text;not null"`
+	HTML           string    `json:"html" gorm:"type:text"`
+
+	// JSON array of the variables the template declares. Overrides of a
+	// system template use the system template's variables instead.
+	Variables string `json:"-" gorm:"type:jsonb;not null;default:'[]'"`
+}
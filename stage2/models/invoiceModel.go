@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Invoice line kinds
+const (
+	InvoiceLineItem = "item"
+	InvoiceLineTax  = "tax"
+)
+
+// Invoice bills an organisation for its subscription charges. Invoices
+// are numbered in sequence per organisation. Amounts are in the
+// currency's minor unit. The rendered PDF is kept with the invoice so
+// it can be downloaded again exactly as it was issued.
+type Invoice struct {
+	Base
+	InvoiceID      uuid.UUID     `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	OrganisationID uuid.UUID     `json:"orgId" gorm:"type:uuid;not null;uniqueIndex:idx_invoices_org_number"`
+	Number         int           `json:"number" gorm:"not null;uniqueIndex:idx_invoices_org_number"`
+	Currency       string        `json:"currency" gorm:"type:char(3);not null"`
+	Subtotal       int64         `json:"subtotal" gorm:"not null"`
+	Tax            int64         `json:"tax" gorm:"not null"`
+	Total          int64         `json:"total" gorm:"not null"`
+	PeriodStart    time.Time     `json:"periodStart" gorm:"not null"`
+	PeriodEnd      time.Time     `json:"periodEnd" gorm:"not null"`
+	IssuedAt       time.Time     `json:"issuedAt" gorm:"not null"`
+	PDF            []byte        `json:"-" gorm:"type:bytea"`
+	Lines          []InvoiceLine `json:"lines" gorm:"foreignKey:InvoiceID"`
+}
+
+// InvoiceLine is an item or a tax on an invoice
+type InvoiceLine struct {
+	ID          uint      `json:"-" gorm:"primaryKey"`
+	InvoiceID   uuid.UUID `json:"-" gorm:"type:uuid;not null;index"`
+	Position    int       `json:"-" gorm:"not null"`
+	Kind        string    `json:"kind" gorm:"type:varchar(10);not null"`
+	Description string    `json:"description" gorm:"type:varchar(255);not null"`
+	Quantity    int       `json:"quantity" gorm:"not null;default:0"`
+	Amount      int64     `json:"amount" gorm:"not null"`
+}
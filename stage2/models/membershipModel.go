@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Membership roles
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// Membership is the join row between a user and an organisation.
+// The key columns mirror the ones GORM generates for the
+// user_organizations many2many table from each side's primary key.
+type Membership struct {
+	UserUserID     uuid.UUID `gorm:"type:uuid;primaryKey"`
+	OrganisationID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Role           string    `json:"role" gorm:"type:varchar(50);not null;default:member"`
+	CreatedAt      time.Time `json:"joinedAt"`
+}
+
+func (Membership) TableName() string {
+	return "user_organizations"
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MfaChallenge is the second step of a two-factor login, waiting for a
+// code. It is used up by a correct code or too many wrong ones.
+type MfaChallenge struct {
+	ChallengeID uuid.UUID  `json:"-" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID      uuid.UUID  `json:"-" gorm:"type:uuid;not null;index"`
+	Failures    int        `json:"-" gorm:"not null;default:0"`
+	ExpiresAt   time.Time  `json:"-" gorm:"not null;index"`
+	ConsumedAt  *time.Time `json:"-"`
+	CreatedAt   time.Time  `json:"-"`
+}
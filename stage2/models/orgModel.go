@@ -2,14 +2,22 @@ package models
 
 import (
 	"github.com/google/uuid"
-	"gorm.io/gorm"
 )
 
 // Organisation models
 type Organisation struct {
-	gorm.Model
-	ID          uuid.UUID `json:"orgId" gorm:"type:uuid;default:gen_random_uuid();primary_key" validate:"unique"`
+	Base
+
+	ID          uuid.UUID `json:"orgId" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
 	Name        string    `json:"name" gorm:"type:varchar(255);not null" validate:"required"`
 	Description string    `json:"description" gorm:"type:varchar(255)"`
-	Users       []*User   `gorm:"many2many:user_organizations;"`
+
+	// OwnerID is the user id of the member who owns the organisation.
+	// Ownership only changes hands through an accepted transfer.
+	OwnerID *uuid.UUID `json:"ownerId" gorm:"type:uuid;index"`
+
+	// RequireTwoFactor forces every member to have 2FA enabled
+	RequireTwoFactor bool `json:"requireTwoFactor" gorm:"not null;default:false"`
+
+	Users []*User `gorm:"many2many:user_organizations;"`
 }
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// A role defined by an organisation in addition to the built in admin
+// and member roles. Memberships refer to it by name.
+type OrgRole struct {
+	Base
+	RoleID         uuid.UUID `json:"roleId" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	OrganisationID uuid.UUID `json:"orgId" gorm:"type:uuid;not null;uniqueIndex:idx_org_roles_org_name"`
+	Name           string    `json:"name" gorm:"type:varchar(50);not null;uniqueIndex:idx_org_roles_org_name"`
+	Description    string    `json:"description" gorm:"type:varchar(255)"`
+	Permissions    string    `json:"-" gorm:"type:text;not null"` // space separated
+}
+
+func (r OrgRole) PermissionList() []string {
+	return strings.Fields(r.Permissions)
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Outbox email statuses
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailFailed  = "failed"
+)
+
+// OutboxEmail is an email waiting to be sent, or the record of one that
+// was. Emails are written here in the same transaction as the change that
+// triggered them and delivered in the background with retries.
+type OutboxEmail struct {
+	EmailID       uuid.UUID  `json:"emailId" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	TemplateID    string     `json:"templateId" gorm:"type:varchar(100)"`
+	Recipient     string     `json:"to" gorm:"not null;index"`
+	Sender        string     `json:"from" gorm:"not null"`
+	Subject       string     `json:"subject" gorm:"not null"`
+	TextBody      string     `json:"text" gorm:"type:text"`
+	HTMLBody      string     `json:"html" gorm:"type:text"`
+	Status        string     `json:"status" gorm:"type:varchar(20);not null;default:pending;index:idx_outbox_emails_due,priority:1"`
+	Attempts      int        `json:"attempts" gorm:"not null;default:0"`
+	NextAttemptAt time.Time  `json:"nextAttemptAt" gorm:"not null;index:idx_outbox_emails_due,priority:2"`
+	LastError     string     `json:"lastError" gorm:"type:text"`
+	SentAt        *time.Time `json:"sentAt"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+}
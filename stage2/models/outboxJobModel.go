@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Outbox job statuses. Jobs still failing after their last retry are
+// dead.
+const (
+	JobPending = "pending"
+	JobDone    = "done"
+	JobDead    = "dead"
+)
+
+// OutboxJob is a side effect of a change, such as sending an email,
+// written in the same transaction as the change and run by the worker
+// pool once it commits. While a worker runs a job it is leased to that
+// worker until LockedUntil, and other workers leave it alone.
+type OutboxJob struct {
+	JobID       uuid.UUID  `json:"jobId" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	Kind        string     `json:"kind" gorm:"type:varchar(100);not null;index"`
+	Payload     string     `json:"payload" gorm:"type:jsonb;not null"`
+	Status      string     `json:"status" gorm:"type:varchar(20);not null;default:pending;index:idx_outbox_jobs_due,priority:1"`
+	Attempts    int        `json:"attempts" gorm:"not null;default:0"`
+	RunAt       time.Time  `json:"runAt" gorm:"not null;index:idx_outbox_jobs_due,priority:2"`
+	LastError   string     `json:"lastError" gorm:"type:text"`
+	LockedUntil *time.Time `json:"lockedUntil"`
+	CompletedAt *time.Time `json:"completedAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Ownership transfer statuses
+const (
+	TransferPending   = "pending"
+	TransferAccepted  = "accepted"
+	TransferDeclined  = "declined"
+	TransferCancelled = "cancelled"
+)
+
+// A request from an organisation's owner to hand it to another member.
+// Nothing changes until the recipient accepts.
+type OwnershipTransfer struct {
+	Base
+	TransferID     uuid.UUID  `json:"transferId" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	OrganisationID uuid.UUID  `json:"orgId" gorm:"type:uuid;not null;index"`
+	FromUserID     uuid.UUID  `json:"fromUserId" gorm:"type:uuid;not null"`
+	ToUserID       uuid.UUID  `json:"toUserId" gorm:"type:uuid;not null;index"`
+	Status         string     `json:"status" gorm:"type:varchar(20);not null;default:pending"`
+	ExpiresAt      time.Time  `json:"expiresAt" gorm:"not null"`
+	RespondedAt    *time.Time `json:"respondedAt"`
+}
+
+// Pending and not yet expired
+func (t OwnershipTransfer) Open() bool {
+	return t.Status == TransferPending && time.Now().Before(t.ExpiresAt)
+}
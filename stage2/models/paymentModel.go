@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Payment statuses
+const (
+	PaymentPending   = "pending"
+	PaymentSucceeded = "succeeded"
+	PaymentFailed    = "failed"
+	PaymentRefunded  = "refunded"
+)
+
+// A payment made by a user on behalf of an organisation. Amounts are
+// integers in the currency's minor unit, e.g. cents. Payments are
+// financial records and outlive the user and organisation they name.
+type Payment struct {
+	Base
+	PaymentID      uuid.UUID `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID         uuid.UUID `json:"-" gorm:"type:uuid;not null;index"`
+	OrganisationID uuid.UUID `json:"orgId" gorm:"type:uuid;not null;index"`
+	Provider       string    `json:"provider" gorm:"type:varchar(50);not null"`
+	ProviderRef    string    `json:"-" gorm:"type:varchar(255);index"`
+	Amount         int64     `json:"amount" gorm:"not null"`
+	RefundedAmount int64     `json:"refundedAmount" gorm:"not null;default:0"`
+	Currency       string    `json:"currency" gorm:"type:char(3);not null"`
+	Status         string    `json:"status" gorm:"type:varchar(20);not null;default:pending"`
+	Description    string    `json:"description" gorm:"type:varchar(255)"`
+}
+
+// Refund statuses
+const (
+	RefundPending   = "pending"
+	RefundSucceeded = "succeeded"
+	RefundFailed    = "failed"
+)
+
+// A refund of a payment. It is recorded as pending before the provider
+// is asked for it and settled after, so a refund the provider made is
+// never lost. The idempotency key is sent to the provider, which makes
+// asking again for the same refund safe.
+type PaymentRefund struct {
+	RefundID       uuid.UUID  `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	PaymentID      uuid.UUID  `json:"-" gorm:"type:uuid;not null;index"`
+	Amount         int64      `json:"amount" gorm:"not null"`
+	Currency       string     `json:"currency" gorm:"type:char(3);not null"`
+	IdempotencyKey string     `json:"-" gorm:"type:varchar(255);not null;uniqueIndex"`
+	Status         string     `json:"status" gorm:"type:varchar(20);not null;default:pending"`
+	ProviderRef    string     `json:"-" gorm:"type:varchar(255)"`
+	Error          string     `json:"error" gorm:"type:text"`
+	SettledAt      *time.Time `json:"settledAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
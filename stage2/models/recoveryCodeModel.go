@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RecoveryCode is a single-use two-factor backup code, stored hashed
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uuid.UUID  `gorm:"type:uuid;not null;index"`
+	CodeHash string     `json:"-" gorm:"not null"`
+	UsedAt   *time.Time `json:"usedAt"`
+}
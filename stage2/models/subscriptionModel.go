@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Subscription is an organisation's plan. Organisations are billed per
+// seat, one seat for each member, for each period of the subscription.
+// A subscription is suspended while its organisation is in the trash and
+// isn't billed until it is restored.
+type Subscription struct {
+	Base
+	SubscriptionID     uuid.UUID  `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	OrganisationID     uuid.UUID  `json:"orgId" gorm:"type:uuid;not null;uniqueIndex"`
+	PlanID             string     `json:"planId" gorm:"type:varchar(50);not null"`
+	Seats              int        `json:"seats" gorm:"not null;default:0"`
+	CurrentPeriodStart time.Time  `json:"currentPeriodStart" gorm:"not null"`
+	CurrentPeriodEnd   time.Time  `json:"currentPeriodEnd" gorm:"not null;index"`
+	SuspendedAt        *time.Time `json:"suspendedAt"`
+}
+
+// SubscriptionCharge is an amount owed, or credited when negative, on a
+// subscription: a period's seats, or the prorated part of a period for a
+// change of seats or plan partway through it. Amounts are in the plan
+// currency's minor unit.
+type SubscriptionCharge struct {
+	gorm.Model
+	SubscriptionID uuid.UUID `json:"-" gorm:"type:uuid;not null;index"`
+	OrganisationID uuid.UUID `json:"orgId" gorm:"type:uuid;not null;index"`
+	Description    string    `json:"description" gorm:"type:varchar(255);not null"`
+	Quantity       int       `json:"quantity" gorm:"not null"`
+	Amount         int64     `json:"amount" gorm:"not null"`
+	Currency       string    `json:"currency" gorm:"type:char(3);not null"`
+	PeriodStart    time.Time `json:"periodStart" gorm:"not null"`
+	PeriodEnd      time.Time `json:"periodEnd" gorm:"not null"`
+
+	// The invoice the charge was billed on, once it has been
+	InvoiceID *uuid.UUID `json:"-" gorm:"type:uuid;index"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Team roles. Leads manage their team and every team below it.
+const (
+	TeamRoleLead   = "lead"
+	TeamRoleMember = "member"
+)
+
+// A team inside an organisation, optionally nested under another team
+type Team struct {
+	Base
+	TeamID         uuid.UUID  `json:"teamId" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	OrganisationID uuid.UUID  `json:"orgId" gorm:"type:uuid;not null;index"`
+	ParentID       *uuid.UUID `json:"parentId" gorm:"type:uuid;index"`
+	Name           string     `json:"name" gorm:"type:varchar(255);not null"`
+	Description    string     `json:"description" gorm:"type:varchar(255)"`
+}
+
+// A member of a team. Team members must belong to the team's organisation.
+type TeamMember struct {
+	TeamID    uuid.UUID `json:"teamId" gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `json:"userId" gorm:"type:uuid;primaryKey;index"`
+	Role      string    `json:"role" gorm:"type:varchar(20);not null;default:member"`
+	CreatedAt time.Time `json:"joinedAt"`
+}
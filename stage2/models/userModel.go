@@ -1,19 +1,54 @@
 package models
 
 import (
+	"strings"
+	"time"
+
 	"github.com/google/uuid"
-	"gorm.io/gorm"
 )
 
 // User models
 type User struct {
-	gorm.Model
-
-	UserID        uuid.UUID       `json:"userId" gorm:"type:uuid;default:gen_random_uuid();primary_key" validate:"unique"`
-	FirstName     string          `json:"firstName" gorm:"type:varchar(255);not null" validate:"required"`
-	LastName      string          `json:"lastName" gorm:"type:varchar(255);not null" validate:"required"`
-	Email         string          `json:"email" gorm:"unique;not null" validate:"required,email"`
-	Password      string          `json:"-" gorm:"not null" validate:"required"` // "-" exclude field from json response
-	Phone         string          `json:"phone" gorm:"type:varchar(255)"`
+	Base
+
+	UserID    uuid.UUID `json:"userId" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	FirstName string    `json:"firstName" gorm:"type:varchar(255);not null" validate:"required"`
+	LastName  string    `json:"lastName" gorm:"type:varchar(255);not null" validate:"required"`
+	Email     string    `json:"email" gorm:"unique;not null" validate:"required,email"`
+	Password  string    `json:"-" gorm:"not null" validate:"required"` // "-" exclude field from json response
+	Phone     string    `json:"phone" gorm:"type:varchar(255)"`
+
+	// Platform admins bypass per-organisation visibility rules
+	PlatformRole string `json:"-" gorm:"type:varchar(50);not null;default:user"`
+
+	// Two-factor authentication
+	TwoFactorSecret  string `json:"-" gorm:"type:varchar(255)"`
+	TwoFactorEnabled bool   `json:"twoFactorEnabled" gorm:"not null;default:false"`
+	// The time step of the last TOTP code accepted, so it can't be used again
+	TwoFactorLastStep int64 `json:"-" gorm:"not null;default:0"`
+
+	// Set when the user asks to delete their account. The account is
+	// anonymised once this passes unless they log in again first.
+	DeletionScheduledAt *time.Time `json:"-" gorm:"index"`
+
 	Organisations []*Organisation `gorm:"many2many:user_organizations;"`
 }
+
+// Platform roles
+const (
+	PlatformRoleUser  = "user"
+	PlatformRoleAdmin = "admin"
+)
+
+func (u User) IsPlatformAdmin() bool {
+	return u.PlatformRole == PlatformRoleAdmin
+}
+
+// Purged accounts get an address on this domain in place of their own
+const AnonymisedEmailDomain = "deleted.invalid"
+
+// Whether the account's personal data has been purged. Anonymised
+// accounts can't be restored.
+func (u User) Anonymised() bool {
+	return strings.HasSuffix(u.Email, "@"+AnonymisedEmailDomain)
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook delivery statuses. Deliveries still failing after the last
+// retry are dead and are only sent again if someone asks.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// WebhookEndpoint is a URL an organisation has asked to be sent its
+// events. Each request is signed with the endpoint's secret.
+type WebhookEndpoint struct {
+	Base
+	EndpointID     uuid.UUID `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	OrganisationID uuid.UUID `json:"orgId" gorm:"type:uuid;not null;index"`
+	URL            string    `json:"url" gorm:"type:varchar(2048);not null"`
+	Description    string    `json:"description" gorm:"type:varchar(255)"`
+	Events         string    `json:"-" gorm:"type:text;not null"` // space separated, empty for every event
+	Secret         string    `json:"-" gorm:"type:varchar(100);not null"`
+	Active         bool      `json:"active" gorm:"not null;default:true"`
+}
+
+func (e WebhookEndpoint) EventList() []string {
+	return strings.Fields(e.Events)
+}
+
+// Whether the endpoint wants an event
+func (e WebhookEndpoint) Subscribed(event string) bool {
+	events := e.EventList()
+	if len(events) == 0 {
+		return true
+	}
+	for _, name := range events {
+		if name == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one event on its way to one endpoint. Deliveries are
+// written in the same transaction as the change the event is about and
+// sent in the background with retries.
+type WebhookDelivery struct {
+	DeliveryID     uuid.UUID  `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	EndpointID     uuid.UUID  `json:"-" gorm:"type:uuid;not null;index"`
+	OrganisationID uuid.UUID  `json:"orgId" gorm:"type:uuid;not null;index"`
+	EventID        uuid.UUID  `json:"eventId" gorm:"type:uuid;not null"`
+	Event          string     `json:"event" gorm:"type:varchar(100);not null"`
+	Payload        string     `json:"-" gorm:"type:jsonb;not null"`
+	Status         string     `json:"status" gorm:"type:varchar(20);not null;default:pending;index:idx_webhook_deliveries_due,priority:1"`
+	Attempts       int        `json:"attempts" gorm:"not null;default:0"`
+	NextAttemptAt  time.Time  `json:"nextAttemptAt" gorm:"not null;index:idx_webhook_deliveries_due,priority:2"`
+	LastStatusCode int        `json:"lastStatusCode"`
+	LastError      string     `json:"lastError" gorm:"type:text"`
+	DeliveredAt    *time.Time `json:"deliveredAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
+
+// WebhookAttempt is the log of one try at sending a delivery
+type WebhookAttempt struct {
+	ID          uint      `json:"-" gorm:"primaryKey"`
+	DeliveryID  uuid.UUID `json:"-" gorm:"type:uuid;not null;index"`
+	StatusCode  int       `json:"statusCode"`
+	Error       string    `json:"error" gorm:"type:text"`
+	Duration    int64     `json:"durationMs" gorm:"not null"`
+	AttemptedAt time.Time `json:"attemptedAt" gorm:"not null"`
+}
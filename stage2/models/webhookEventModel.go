@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Webhook event statuses
+const (
+	WebhookReceived  = "received"
+	WebhookProcessed = "processed"
+	WebhookIgnored   = "ignored"
+	WebhookFailed    = "failed"
+)
+
+// WebhookEvent is a webhook received from a payment provider. Events are
+// kept by the provider's event id, so a redelivered event is recognised
+// and not applied twice, and stored events can be replayed.
+type WebhookEvent struct {
+	ID          uint       `json:"-" gorm:"primaryKey"`
+	Provider    string     `json:"provider" gorm:"type:varchar(50);not null;uniqueIndex:idx_webhook_events_provider_event"`
+	EventID     string     `json:"eventId" gorm:"type:varchar(255);not null;uniqueIndex:idx_webhook_events_provider_event"`
+	Type        string     `json:"type" gorm:"type:varchar(100);not null"`
+	Payload     string     `json:"-" gorm:"type:jsonb;not null"`
+	Status      string     `json:"status" gorm:"type:varchar(20);not null;default:received;index"`
+	Error       string     `json:"error" gorm:"type:text"`
+	Attempts    int        `json:"attempts" gorm:"not null;default:0"`
+	ReceivedAt  time.Time  `json:"receivedAt" gorm:"not null"`
+	ProcessedAt *time.Time `json:"processedAt"`
+}
@@ -0,0 +1,118 @@
+// Package outbox runs side effects of changes in the background.
+//
+// A job is written in the same transaction as the change it belongs to,
+// so it exists exactly when the change commits:
+//
+//	database.DB.Db.Transaction(func(tx *gorm.DB) error {
+//		...
+//		_, err := outbox.Enqueue(tx, mailer.JobSend, mailer.SendJob{EmailID: email.EmailID})
+//		return err
+//	})
+//
+// A Pool of workers leases due jobs, so any number of processes can run
+// workers without running a job twice at once, and retries failed jobs
+// with backoff. A job whose worker dies is run again once its lease runs
+// out, so handlers must cope with a job running more than once.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mryan-3/hng11/stage2/models"
+	"gorm.io/gorm"
+)
+
+// DefaultMaxAttempts is how many times a job is tried if its handler
+// doesn't say
+const DefaultMaxAttempts = 8
+
+var ErrUnknownKind = errors.New("no handler for this kind of job")
+
+// Handler runs one kind of job. Run is called outside any transaction,
+// and what it writes with db commits as it goes, even when it returns
+// an error, so it can keep its own record of attempts. A job whose Run
+// returns an error is retried after Backoff until it has been tried
+// MaxAttempts times.
+type Handler struct {
+	Run         func(ctx context.Context, db *gorm.DB, job models.OutboxJob) error
+	MaxAttempts int
+	Backoff     func(attempts int) time.Duration
+}
+
+var (
+	handlersMu sync.RWMutex
+	handlers   = map[string]Handler{}
+)
+
+// Register the handler for a kind of job
+func Register(kind string, handler Handler) {
+	if handler.MaxAttempts == 0 {
+		handler.MaxAttempts = DefaultMaxAttempts
+	}
+	if handler.Backoff == nil {
+		handler.Backoff = Backoff
+	}
+
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers[kind] = handler
+}
+
+func lookup(kind string) (Handler, bool) {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+	handler, ok := handlers[kind]
+	return handler, ok
+}
+
+// Queue a job to run as soon as a worker is free. The payload is stored
+// as JSON.
+func Enqueue(tx *gorm.DB, kind string, payload interface{}) (models.OutboxJob, error) {
+	return EnqueueAt(tx, kind, payload, time.Now())
+}
+
+// Queue a job to run at a time
+func EnqueueAt(tx *gorm.DB, kind string, payload interface{}, runAt time.Time) (models.OutboxJob, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return models.OutboxJob{}, err
+	}
+
+	job := models.OutboxJob{
+		Kind:    kind,
+		Payload: string(body),
+		Status:  models.JobPending,
+		RunAt:   runAt,
+	}
+
+	return job, tx.Create(&job).Error
+}
+
+// Read a job's payload
+func Decode(job models.OutboxJob, payload interface{}) error {
+	return json.Unmarshal([]byte(job.Payload), payload)
+}
+
+// Wait ten seconds after the first failure, doubling up to an hour
+func Backoff(attempts int) time.Duration {
+	const first, longest = 10 * time.Second, time.Hour
+
+	delay := first
+	for i := 1; i < attempts && delay < longest; i++ {
+		delay *= 2
+	}
+	if delay > longest {
+		delay = longest
+	}
+	return delay
+}
+
+// Delete jobs that finished before a time. Dead jobs are kept so they
+// can be looked into.
+func Prune(db *gorm.DB, before time.Time) error {
+	return db.Where("status = ? AND completed_at < ?", models.JobDone, before).Delete(&models.OutboxJob{}).Error
+}
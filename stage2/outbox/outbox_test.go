@@ -0,0 +1,121 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Build queries without a database
+func dryRunDb(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to open dry run db: %v", err)
+	}
+	return db
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 10*time.Second, Backoff(1))
+	assert.Equal(t, 20*time.Second, Backoff(2))
+	assert.Equal(t, 80*time.Second, Backoff(4))
+	assert.Equal(t, time.Hour, Backoff(50))
+}
+
+func TestRegisterFillsInDefaults(t *testing.T) {
+	Register("test.defaults", Handler{Run: func(context.Context, *gorm.DB, models.OutboxJob) error { return nil }})
+
+	handler, ok := lookup("test.defaults")
+	assert.True(t, ok)
+	assert.Equal(t, DefaultMaxAttempts, handler.MaxAttempts)
+	assert.Equal(t, Backoff(3), handler.Backoff(3))
+}
+
+func TestEnqueueStoresPayloadAsJson(t *testing.T) {
+	type payload struct {
+		EmailID uuid.UUID `json:"emailId"`
+	}
+	emailId := uuid.New()
+
+	job, err := Enqueue(dryRunDb(t), "email.send", payload{EmailID: emailId})
+	assert.NoError(t, err)
+	assert.Equal(t, models.JobPending, job.Status)
+	assert.Equal(t, `{"emailId":"`+emailId.String()+`"}`, job.Payload)
+
+	var decoded payload
+	assert.NoError(t, Decode(job, &decoded))
+	assert.Equal(t, emailId, decoded.EmailID)
+}
+
+func TestDueSkipsLockedJobs(t *testing.T) {
+	now := time.Now()
+
+	var jobs []models.OutboxJob
+	stmt := due(dryRunDb(t), now).Limit(1).Find(&jobs).Statement
+
+	sql := stmt.SQL.String()
+	assert.Contains(t, sql, "status = $1 AND run_at <= $2 AND (locked_until IS NULL OR locked_until < $3)")
+	assert.Contains(t, sql, "ORDER BY run_at LIMIT $4 FOR UPDATE SKIP LOCKED")
+	assert.Equal(t, []interface{}{models.JobPending, now, now, 1}, stmt.Vars)
+}
+
+func TestLeaseOutlastsTimeout(t *testing.T) {
+	pool := NewPool(nil, 1)
+	assert.Greater(t, pool.lease(), pool.Timeout)
+}
+
+func TestOutcome(t *testing.T) {
+	now := time.Now()
+	handler := Handler{MaxAttempts: 3, Backoff: Backoff}
+	failed := errors.New("connection refused")
+
+	t.Run("done", func(t *testing.T) {
+		updates := outcome(models.OutboxJob{Attempts: 1}, handler, true, nil, now)
+		assert.Equal(t, models.JobDone, updates["status"])
+		assert.Equal(t, now, updates["completed_at"])
+		assert.Contains(t, updates, "locked_until")
+		assert.Nil(t, updates["locked_until"])
+	})
+
+	t.Run("retried with backoff", func(t *testing.T) {
+		updates := outcome(models.OutboxJob{Attempts: 2}, handler, true, failed, now)
+		assert.NotContains(t, updates, "status")
+		assert.NotContains(t, updates, "attempts")
+		assert.Equal(t, now.Add(Backoff(2)), updates["run_at"])
+		assert.Equal(t, failed.Error(), updates["last_error"])
+	})
+
+	t.Run("dead after the last attempt", func(t *testing.T) {
+		updates := outcome(models.OutboxJob{Attempts: 3}, handler, true, failed, now)
+		assert.Equal(t, models.JobDead, updates["status"])
+	})
+
+	t.Run("dead without a handler", func(t *testing.T) {
+		updates := outcome(models.OutboxJob{Kind: "unknown", Attempts: 1}, Handler{}, false, ErrUnknownKind, now)
+		assert.Equal(t, models.JobDead, updates["status"])
+		assert.Equal(t, ErrUnknownKind.Error(), updates["last_error"])
+	})
+}
+
+func TestRunSafelyRecoversPanics(t *testing.T) {
+	handler := Handler{Run: func(context.Context, *gorm.DB, models.OutboxJob) error {
+		panic("boom")
+	}}
+
+	err := runSafely(context.Background(), handler, nil, models.OutboxJob{})
+
+	var panicErr *PanicError
+	assert.ErrorAs(t, err, &panicErr)
+	assert.Equal(t, "job panicked: boom", err.Error())
+}
@@ -0,0 +1,213 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mryan-3/hng11/stage2/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Pool is a set of workers running outbox jobs
+type Pool struct {
+	db *gorm.DB
+
+	// Workers is how many jobs run at once
+	Workers int
+	// Poll is how long an idle worker waits before looking for jobs again
+	Poll time.Duration
+	// Timeout is how long one job can run
+	Timeout time.Duration
+}
+
+func NewPool(db *gorm.DB, workers int) *Pool {
+	return &Pool{
+		db:      db,
+		Workers: workers,
+		Poll:    time.Second,
+		Timeout: time.Minute,
+	}
+}
+
+// Run the workers until ctx is cancelled. Jobs already running when it
+// is are finished, and Run returns once they have.
+func (p *Pool) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < p.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Pool) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		ran, err := p.RunOne()
+		if err != nil {
+			log.Printf("Outbox worker failed: %v", err)
+		}
+
+		// Go straight on to the next job while there is work
+		if ran && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(p.Poll):
+		}
+	}
+}
+
+// Claim the next due job and run it. Returns whether there was a job.
+//
+// Claiming a job leases it to this worker in a short transaction of its
+// own, and the handler runs after that has committed, so no row lock or
+// connection is held while it talks to the outside world. Other workers
+// skip the job until its lease runs out; if this process dies first the
+// lease lapses and the job is run again.
+func (p *Pool) RunOne() (bool, error) {
+	job, ok, err := p.claim(time.Now())
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return true, p.run(job)
+}
+
+// How long a claimed job is held. It outlasts the job's own deadline so
+// a job that is still running is never handed to another worker.
+func (p *Pool) lease() time.Duration {
+	return p.Timeout + leaseGrace
+}
+
+// Time allowed on top of Timeout for a job's outcome to be saved
+const leaseGrace = 30 * time.Second
+
+// Lease the next due job to this worker, counting the attempt
+func (p *Pool) claim(now time.Time) (models.OutboxJob, bool, error) {
+	var job models.OutboxJob
+	found := false
+
+	err := p.db.Transaction(func(tx *gorm.DB) error {
+		var jobs []models.OutboxJob
+		err := due(tx, now).Limit(1).Find(&jobs).Error
+		if err != nil || len(jobs) == 0 {
+			return err
+		}
+
+		job = jobs[0]
+		job.Attempts++
+		lockedUntil := now.Add(p.lease())
+		job.LockedUntil = &lockedUntil
+		found = true
+
+		return tx.Model(&job).Updates(map[string]interface{}{
+			"attempts":     job.Attempts,
+			"locked_until": lockedUntil,
+		}).Error
+	})
+	if err != nil {
+		return models.OutboxJob{}, false, err
+	}
+
+	return job, found, nil
+}
+
+// Jobs due to run, oldest first. Jobs leased to a worker are left out
+// until the lease runs out, and the rows returned are locked so two
+// workers claiming at once don't both take the same one.
+func due(tx *gorm.DB, now time.Time) *gorm.DB {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ? AND run_at <= ? AND (locked_until IS NULL OR locked_until < ?)", models.JobPending, now, now).
+		Order("run_at")
+}
+
+// Run a claimed job and save how it went. The outcome is only saved if
+// the job hasn't since been claimed again, which happens when it ran
+// past its lease.
+func (p *Pool) run(job models.OutboxJob) error {
+	handler, ok := lookup(job.Kind)
+
+	// Jobs are run with their own deadline rather than the pool's
+	// context, so shutting down lets running jobs finish
+	runErr := ErrUnknownKind
+	if ok {
+		ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+		runErr = runSafely(ctx, handler, p.db, job)
+		cancel()
+	}
+
+	return p.db.Model(&job).
+		Where("attempts = ?", job.Attempts).
+		Updates(outcome(job, handler, ok, runErr, time.Now())).Error
+}
+
+// The changes to save to a job once it has run, releasing its lease.
+// The attempt was counted when the job was claimed. A job that failed is
+// run again after its handler's backoff, unless that was its last
+// attempt or it has no handler, in which case it is dead.
+func outcome(job models.OutboxJob, handler Handler, ok bool, runErr error, now time.Time) map[string]interface{} {
+	attempts := job.Attempts
+	updates := map[string]interface{}{"locked_until": nil}
+
+	switch {
+	case runErr == nil:
+		updates["status"] = models.JobDone
+		updates["completed_at"] = now
+		updates["last_error"] = ""
+	case !ok || attempts >= handler.MaxAttempts:
+		updates["status"] = models.JobDead
+		updates["completed_at"] = now
+		updates["last_error"] = runErr.Error()
+		log.Printf("Giving up on %s job %s: %v", job.Kind, job.JobID, runErr)
+	default:
+		updates["run_at"] = now.Add(handler.Backoff(attempts))
+		updates["last_error"] = runErr.Error()
+	}
+
+	return updates
+}
+
+// Run a handler, turning a panic into an error so one bad job doesn't
+// take the worker down
+func runSafely(ctx context.Context, handler Handler, db *gorm.DB, job models.OutboxJob) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = &PanicError{Value: recovered}
+		}
+	}()
+
+	return handler.Run(ctx, db, job)
+}
+
+// PanicError is a handler that panicked
+type PanicError struct {
+	Value interface{}
+}
+
+func (e *PanicError) Error() string {
+	return "job panicked: " + fmt.Sprint(e.Value)
+}
+
+// DefaultWorkers is how many workers run when OUTBOX_WORKERS isn't set
+const DefaultWorkers = 4
+
+// How many workers to run, from OUTBOX_WORKERS. 0 means this process
+// runs none, e.g. because cmd/worker does.
+func Workers() int {
+	workers, err := strconv.Atoi(os.Getenv("OUTBOX_WORKERS"))
+	if err != nil || workers < 0 {
+		return DefaultWorkers
+	}
+	return workers
+}
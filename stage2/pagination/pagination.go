@@ -0,0 +1,236 @@
+// Package pagination implements cursor based pagination, sorting and the
+// created_after filter shared by the list endpoints.
+//
+// Query params:
+//
+//	limit          page size, 1-100 (default 20)
+//	cursor         nextCursor from the previous page's meta
+//	sort           sort key, prefixed with "-" for descending (e.g. -created_at)
+//	created_after  only rows created after this RFC 3339 time or date
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// Sort is a column a list can be ordered by
+type Sort[T any] struct {
+	Column string
+	value  func(T) string
+	parse  func(string) (interface{}, error)
+}
+
+// Sort on a text column
+func StringSort[T any](column string, value func(T) string) Sort[T] {
+	return Sort[T]{
+		Column: column,
+		value:  value,
+		parse:  func(v string) (interface{}, error) { return v, nil },
+	}
+}
+
+// Sort on a timestamp column
+func TimeSort[T any](column string, value func(T) time.Time) Sort[T] {
+	return Sort[T]{
+		Column: column,
+		value:  func(item T) string { return value(item).Format(time.RFC3339Nano) },
+		parse: func(v string) (interface{}, error) {
+			return time.Parse(time.RFC3339Nano, v)
+		},
+	}
+}
+
+// Options describe how an endpoint's rows can be paginated
+type Options[T any] struct {
+	// Sorts by query param name
+	Sorts map[string]Sort[T]
+	// Default sort param, e.g. "-created_at"
+	DefaultSort string
+	// Unique column that breaks ties between equal sort values
+	TieBreaker Sort[T]
+	// Column created_after filters on
+	CreatedColumn string
+}
+
+// Page is a parsed page request
+type Page[T any] struct {
+	Limit        int
+	SortParam    string
+	Descending   bool
+	CreatedAfter *time.Time
+
+	sort    Sort[T]
+	tie     Sort[T]
+	created string
+	after   *cursor
+}
+
+// Meta describes a page in list responses
+type Meta struct {
+	Limit      int     `json:"limit"`
+	Sort       string  `json:"sort"`
+	HasMore    bool    `json:"hasMore"`
+	NextCursor *string `json:"nextCursor"`
+}
+
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	Tie   string `json:"t"`
+}
+
+// Read the pagination query params of a request
+func Parse[T any](c *fiber.Ctx, opts Options[T]) (*Page[T], error) {
+	page := &Page[T]{
+		Limit:   DefaultLimit,
+		tie:     opts.TieBreaker,
+		created: opts.CreatedColumn,
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > MaxLimit {
+			return nil, errors.New("limit must be a number from 1 to " + strconv.Itoa(MaxLimit))
+		}
+		page.Limit = n
+	}
+
+	page.SortParam = c.Query("sort", opts.DefaultSort)
+	page.Descending = strings.HasPrefix(page.SortParam, "-")
+
+	sortKey, ok := opts.Sorts[strings.TrimPrefix(page.SortParam, "-")]
+	if !ok {
+		keys := make([]string, 0, len(opts.Sorts))
+		for key := range opts.Sorts {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return nil, errors.New("sort must be one of: " + strings.Join(keys, ", "))
+	}
+	page.sort = sortKey
+
+	if createdAfter := c.Query("created_after"); createdAfter != "" && opts.CreatedColumn != "" {
+		t, err := parseTime(createdAfter)
+		if err != nil {
+			return nil, errors.New("created_after must be an RFC 3339 time or a date")
+		}
+		page.CreatedAfter = &t
+	}
+
+	if encoded := c.Query("cursor"); encoded != "" {
+		after, err := decodeCursor(encoded)
+
+		// A cursor only makes sense with the sort it was made for
+		if err != nil || after.Sort != page.SortParam {
+			return nil, errors.New("cursor is invalid")
+		}
+		page.after = after
+	}
+
+	return page, nil
+}
+
+// Scope applies the filter, cursor, order and limit to a query.
+// One extra row is fetched to tell whether there is another page.
+func (p *Page[T]) Scope(db *gorm.DB) *gorm.DB {
+	direction := "ASC"
+	comparison := ">"
+	if p.Descending {
+		direction = "DESC"
+		comparison = "<"
+	}
+
+	if p.CreatedAfter != nil {
+		db = db.Where(p.created+" > ?", *p.CreatedAfter)
+	}
+
+	if p.after != nil {
+		value, err := p.sort.parse(p.after.Value)
+		if err != nil {
+			db.AddError(err)
+			return db
+		}
+
+		db = db.Where(
+			"("+p.sort.Column+", "+p.tie.Column+") "+comparison+" (?, ?)",
+			value, p.after.Tie,
+		)
+	}
+
+	return db.
+		Order(p.sort.Column + " " + direction).
+		Order(p.tie.Column + " " + direction).
+		Limit(p.Limit + 1)
+}
+
+// Trim drops the extra row fetched by Scope and builds the page's meta
+func (p *Page[T]) Trim(items []T) ([]T, Meta) {
+	meta := Meta{Limit: p.Limit, Sort: p.SortParam}
+
+	if len(items) > p.Limit {
+		items = items[:p.Limit]
+		last := items[len(items)-1]
+
+		next := encodeCursor(cursor{
+			Sort:  p.SortParam,
+			Value: p.sort.value(last),
+			Tie:   p.tie.value(last),
+		})
+		meta.HasMore = true
+		meta.NextCursor = &next
+	}
+
+	return items, meta
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Escape a value for use in a LIKE pattern that matches it anywhere
+func Contains(value string) string {
+	return "%" + likeEscaper.Replace(value) + "%"
+}
+
+// Escape a value for use in a LIKE pattern that matches it at the start
+func HasPrefix(value string) string {
+	return likeEscaper.Replace(value) + "%"
+}
+
+func encodeCursor(c cursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(encoded string) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	var c cursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
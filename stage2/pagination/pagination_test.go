@@ -0,0 +1,130 @@
+package pagination
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type item struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+}
+
+var itemOptions = Options[item]{
+	Sorts: map[string]Sort[item]{
+		"created_at": TimeSort("items.created_at", func(i item) time.Time { return i.CreatedAt }),
+		"name":       StringSort("items.name", func(i item) string { return i.Name }),
+	},
+	DefaultSort:   "created_at",
+	TieBreaker:    StringSort("items.id", func(i item) string { return i.ID }),
+	CreatedColumn: "items.created_at",
+}
+
+// Parse the query string of a fake request
+func parse(t *testing.T, query string) (*Page[item], error) {
+	app := fiber.New()
+
+	var page *Page[item]
+	var err error
+	app.Get("/items", func(c *fiber.Ctx) error {
+		page, err = Parse(c, itemOptions)
+		return nil
+	})
+
+	if _, testErr := app.Test(httptest.NewRequest("GET", "/items?"+query, nil)); testErr != nil {
+		t.Fatalf("Failed to perform request: %v", testErr)
+	}
+
+	return page, err
+}
+
+// Build the SQL of a paged query without a database
+func pageSQL(t *testing.T, page *Page[item]) (string, []interface{}) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to open dry run db: %v", err)
+	}
+
+	var items []item
+	stmt := db.Table("items").Scopes(page.Scope).Find(&items).Statement
+
+	return stmt.SQL.String(), stmt.Vars
+}
+
+func TestParseDefaults(t *testing.T) {
+	page, err := parse(t, "")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultLimit, page.Limit)
+	assert.Equal(t, "created_at", page.SortParam)
+	assert.False(t, page.Descending)
+
+	sql, vars := pageSQL(t, page)
+	assert.Contains(t, sql, "ORDER BY items.created_at ASC,items.id ASC LIMIT $1")
+	assert.Equal(t, []interface{}{DefaultLimit + 1}, vars)
+}
+
+func TestParseRejectsInvalidParams(t *testing.T) {
+	for _, query := range []string{"limit=0", "limit=101", "limit=abc", "sort=password", "cursor=nope", "created_after=yesterday"} {
+		_, err := parse(t, query)
+		assert.Error(t, err, query)
+	}
+}
+
+func TestPagesFollowCursor(t *testing.T) {
+	page, err := parse(t, "limit=2&sort=-name&created_after=2024-01-01")
+	assert.NoError(t, err)
+
+	items := []item{{ID: "c", Name: "Zed"}, {ID: "b", Name: "Yan"}, {ID: "a", Name: "Xia"}}
+	trimmed, meta := page.Trim(items)
+
+	assert.Len(t, trimmed, 2)
+	assert.True(t, meta.HasMore)
+	assert.Equal(t, "-name", meta.Sort)
+
+	// The next page continues after the last row, in the same order
+	cursor := *meta.NextCursor
+	next, err := parse(t, "limit=2&sort=-name&cursor="+cursor)
+	assert.NoError(t, err)
+
+	sql, vars := pageSQL(t, next)
+	assert.Contains(t, sql, "(items.name, items.id) < ($1, $2)")
+	assert.Contains(t, sql, "ORDER BY items.name DESC,items.id DESC LIMIT $3")
+	assert.Equal(t, []interface{}{"Yan", "b", 3}, vars)
+
+	// The last page has no cursor
+	last, meta := next.Trim(items[2:])
+	assert.Len(t, last, 1)
+	assert.False(t, meta.HasMore)
+	assert.Nil(t, meta.NextCursor)
+
+	// A cursor can't be reused with a different sort
+	_, err = parse(t, "sort=name&cursor="+cursor)
+	assert.Error(t, err)
+}
+
+func TestCreatedAfterFilter(t *testing.T) {
+	page, err := parse(t, "created_after=2024-01-02T15:04:05Z")
+	assert.NoError(t, err)
+
+	sql, vars := pageSQL(t, page)
+	assert.Contains(t, sql, "items.created_at > $1")
+	assert.Equal(t, time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC), vars[0])
+}
+
+func TestContainsEscapesWildcards(t *testing.T) {
+	assert.Equal(t, `%50\%\_off%`, Contains("50%_off"))
+}
+
+func TestHasPrefixEscapesWildcards(t *testing.T) {
+	assert.Equal(t, `user\_%`, HasPrefix("user_"))
+}
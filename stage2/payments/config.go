@@ -0,0 +1,11 @@
+package payments
+
+import "github.com/mryan-3/hng11/stage2/config"
+
+// Register the providers for the environment. The fake provider is only
+// available outside production.
+func Configure() {
+	if config.Env() != config.Production {
+		Register(NewFake())
+	}
+}
@@ -0,0 +1,115 @@
+package payments
+
+import (
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Digits after the decimal point for currencies that don't use two.
+// Amounts are stored in the currency's minor unit, e.g. cents for USD and
+// yen for JPY.
+var minorUnitExceptions = map[string]int{
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0,
+	"KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0,
+	"XOF": 0, "XPF": 0,
+}
+
+// Currencies payments can be made in
+var supportedCurrencies = map[string]bool{
+	"USD": true, "EUR": true, "GBP": true, "NGN": true, "KES": true,
+	"GHS": true, "ZAR": true, "CAD": true, "AUD": true, "JPY": true,
+	"KWD": true,
+}
+
+var (
+	ErrUnsupportedCurrency = errors.New("currency is not supported")
+	ErrInvalidAmount       = errors.New("amount must be a positive number")
+	ErrTooPrecise          = errors.New("amount has more decimal places than the currency allows")
+)
+
+// Normalise a currency code and check payments can be made in it
+func ParseCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !supportedCurrencies[code] {
+		return "", ErrUnsupportedCurrency
+	}
+	return code, nil
+}
+
+// Number of decimal places in a currency's major unit
+func MinorUnits(currency string) int {
+	if digits, ok := minorUnitExceptions[currency]; ok {
+		return digits
+	}
+	return 2
+}
+
+// Convert a decimal amount in the major unit, e.g. "12.5" USD, to minor
+// units, 1250. The amount is parsed exactly, without going through a
+// float, and must not be more precise than the currency.
+func ToMinor(amount string, currency string) (int64, error) {
+	value, ok := new(big.Rat).SetString(strings.TrimSpace(amount))
+	if !ok || value.Sign() <= 0 {
+		return 0, ErrInvalidAmount
+	}
+
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(MinorUnits(currency))), nil)
+	value.Mul(value, new(big.Rat).SetInt(scale))
+
+	if !value.IsInt() {
+		return 0, ErrTooPrecise
+	}
+	if !value.Num().IsInt64() {
+		return 0, ErrInvalidAmount
+	}
+
+	return value.Num().Int64(), nil
+}
+
+// Format an amount in minor units as a decimal in the major unit, e.g.
+// 1250 USD as "12.50"
+func FormatMinor(minor int64, currency string) string {
+	digits := MinorUnits(currency)
+
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+
+	text := strconv.FormatInt(minor, 10)
+	if digits == 0 {
+		return sign + text
+	}
+
+	if len(text) <= digits {
+		text = strings.Repeat("0", digits-len(text)+1) + text
+	}
+
+	return sign + text[:len(text)-digits] + "." + text[len(text)-digits:]
+}
+
+// Format an amount in minor units for people to read, with the currency
+// code and the major unit grouped in thousands, e.g. 123450 USD as
+// "USD 1,234.50"
+func DisplayMinor(minor int64, currency string) string {
+	text := FormatMinor(minor, currency)
+
+	sign := ""
+	if strings.HasPrefix(text, "-") {
+		sign, text = "-", text[1:]
+	}
+
+	whole, fraction, hasFraction := strings.Cut(text, ".")
+	for i := len(whole) - 3; i > 0; i -= 3 {
+		whole = whole[:i] + "," + whole[i:]
+	}
+	if hasFraction {
+		whole += "." + fraction
+	}
+
+	return currency + " " + sign + whole
+}
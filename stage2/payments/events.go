@@ -0,0 +1,176 @@
+package payments
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/audit"
+	"github.com/mryan-3/hng11/stage2/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Event is the body of a payment webhook
+type Event struct {
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Data EventData `json:"data"`
+}
+
+// EventData names the payment an event is about, by our payment id or
+// failing that by the provider's reference for it
+type EventData struct {
+	ID        string `json:"id"`
+	Reference string `json:"reference"`
+	Provider  string `json:"provider"`
+	Status    string `json:"status"`
+}
+
+// Event types and the status each moves a payment to
+var eventStatuses = map[string]string{
+	"payment.succeeded": StatusSucceeded,
+	"payment.failed":    StatusFailed,
+	"payment.refunded":  StatusRefunded,
+}
+
+var (
+	ErrInvalidEvent    = errors.New("webhook event must have an id, a type and a provider")
+	errPaymentNotFound = errors.New("payment not found")
+)
+
+// Read a webhook body
+func ParseEvent(body []byte) (Event, error) {
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		return Event{}, ErrInvalidEvent
+	}
+	if event.ID == "" || event.Type == "" || event.Data.Provider == "" {
+		return Event{}, ErrInvalidEvent
+	}
+	return event, nil
+}
+
+// Store a received event. Events are keyed by provider and event id, so
+// a redelivered event isn't stored twice; created is false for those and
+// the event already stored is returned.
+func ReceiveEvent(db *gorm.DB, event Event, payload []byte) (stored models.WebhookEvent, created bool, err error) {
+	stored = models.WebhookEvent{
+		Provider:   event.Data.Provider,
+		EventID:    event.ID,
+		Type:       event.Type,
+		Payload:    string(payload),
+		Status:     models.WebhookReceived,
+		ReceivedAt: time.Now(),
+	}
+
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&stored)
+	if result.Error != nil {
+		return stored, false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return stored, true, nil
+	}
+
+	err = db.Where("provider = ? AND event_id = ?", event.Data.Provider, event.ID).First(&stored).Error
+	return stored, false, err
+}
+
+// Apply a stored event to its payment. Status changes go through the
+// state machine, and an event that repeats the payment's status changes
+// nothing, so processing an event again is safe. The outcome is recorded
+// on the event; only database errors are returned.
+func ProcessEvent(db *gorm.DB, stored models.WebhookEvent) (models.WebhookEvent, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Hold the event so it isn't processed twice at once
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&stored, stored.ID).Error; err != nil {
+			return err
+		}
+
+		status, outcome, err := applyEvent(tx, stored)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		stored.Status = status
+		stored.Error = ""
+		if outcome != nil {
+			stored.Error = outcome.Error()
+		}
+		stored.Attempts++
+		stored.ProcessedAt = &now
+
+		return tx.Model(&stored).Updates(map[string]interface{}{
+			"status":       stored.Status,
+			"error":        stored.Error,
+			"attempts":     stored.Attempts,
+			"processed_at": stored.ProcessedAt,
+		}).Error
+	})
+
+	return stored, err
+}
+
+// Work out what an event does to its payment and do it. Returns the
+// event's new status, why it wasn't processed if it wasn't, and any
+// database error.
+func applyEvent(tx *gorm.DB, stored models.WebhookEvent) (status string, outcome error, err error) {
+	var event Event
+	if err := json.Unmarshal([]byte(stored.Payload), &event); err != nil {
+		return models.WebhookFailed, ErrInvalidEvent, nil
+	}
+
+	status, known := eventStatuses[event.Type]
+	if !known {
+		return models.WebhookIgnored, errors.New("event type " + event.Type + " isn't handled"), nil
+	}
+
+	query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("provider = ?", stored.Provider)
+	if paymentId, err := uuid.Parse(event.Data.ID); err == nil {
+		query = query.Where("payment_id = ?", paymentId)
+	} else if event.Data.Reference != "" {
+		query = query.Where("provider_ref = ?", event.Data.Reference)
+	} else {
+		return models.WebhookFailed, errPaymentNotFound, nil
+	}
+
+	var payment models.Payment
+	err = query.First(&payment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.WebhookFailed, errPaymentNotFound, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := Transition(payment.Status, status); err != nil {
+		return models.WebhookIgnored, err, nil
+	}
+	if payment.Status == status {
+		return models.WebhookProcessed, nil, nil
+	}
+
+	updates := map[string]interface{}{"status": status}
+	if status == StatusRefunded {
+		updates["refunded_amount"] = payment.Amount
+	}
+	if err := tx.Model(&payment).Updates(updates).Error; err != nil {
+		return "", nil, err
+	}
+
+	err = audit.Record(tx, audit.Entry{Action: audit.PaymentStatusChanged}.
+		Org(payment.OrganisationID).
+		Target("payment", payment.PaymentID).
+		With(map[string]interface{}{
+			"from":    payment.Status,
+			"to":      status,
+			"eventId": stored.EventID,
+		}))
+	if err != nil {
+		return "", nil, err
+	}
+
+	return models.WebhookProcessed, nil, nil
+}
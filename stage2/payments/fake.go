@@ -0,0 +1,133 @@
+package payments
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+)
+
+// FakeProviderName is the name the fake provider registers under
+const FakeProviderName = "fake"
+
+// Fake is an in-memory provider for development and tests. It is
+// deterministic: references are derived from the payment id, and the
+// outcome from the amount's last two minor digits, like test card
+// numbers.
+//
+//	...02  declined, the payment fails
+//	...03  needs the customer to act, the payment stays pending
+//	other  succeeds straight away
+type Fake struct {
+	mu      sync.Mutex
+	intents map[string]*Intent
+	refunds map[string]Refund
+}
+
+func NewFake() *Fake {
+	return &Fake{intents: map[string]*Intent{}, refunds: map[string]Refund{}}
+}
+
+func (f *Fake) Name() string {
+	return FakeProviderName
+}
+
+func (f *Fake) CreateIntent(ctx context.Context, req IntentRequest) (Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	reference := "fake_pi_" + fakeHash(req.PaymentID)
+	if intent, ok := f.intents[reference]; ok {
+		return *intent, nil
+	}
+
+	intent := &Intent{
+		Reference: reference,
+		Amount:    req.Amount,
+		Currency:  req.Currency,
+		Status:    StatusSucceeded,
+	}
+
+	switch req.Amount % 100 {
+	case 2:
+		intent.Status = StatusFailed
+	case 3:
+		intent.Status = StatusPending
+		intent.ClientSecret = reference + "_secret_" + fakeHash("secret:"+req.PaymentID)
+	}
+
+	f.intents[reference] = intent
+	return *intent, nil
+}
+
+func (f *Fake) Fetch(ctx context.Context, reference string) (Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	intent, ok := f.intents[reference]
+	if !ok {
+		return Intent{}, ErrNotFound
+	}
+	return *intent, nil
+}
+
+func (f *Fake) Refund(ctx context.Context, req RefundRequest) (Refund, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if refund, ok := f.refunds[req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		return refund, nil
+	}
+
+	reference, amount := req.Reference, req.Amount
+	intent, ok := f.intents[reference]
+	if !ok {
+		return Refund{}, ErrNotFound
+	}
+	if intent.Status != StatusSucceeded {
+		return Refund{}, ErrNotRefundable
+	}
+	if amount <= 0 || amount > intent.Amount-intent.Refunded {
+		return Refund{}, ErrRefundTooLarge
+	}
+
+	intent.Refunded += amount
+	if intent.Refunded == intent.Amount {
+		intent.Status = StatusRefunded
+	}
+
+	refund := Refund{
+		Reference: "fake_re_" + fakeHash(reference+":"+strconv.FormatInt(intent.Refunded, 10)),
+		Amount:    amount,
+	}
+	if req.IdempotencyKey != "" {
+		f.refunds[req.IdempotencyKey] = refund
+	}
+	return refund, nil
+}
+
+// Settle a pending payment as the customer finishing or abandoning it
+// would
+func (f *Fake) Settle(reference string, succeeded bool) (Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	intent, ok := f.intents[reference]
+	if !ok {
+		return Intent{}, ErrNotFound
+	}
+
+	if intent.Status == StatusPending {
+		intent.Status = StatusFailed
+		if succeeded {
+			intent.Status = StatusSucceeded
+		}
+	}
+	return *intent, nil
+}
+
+func fakeHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:12])
+}
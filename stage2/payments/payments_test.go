@@ -0,0 +1,154 @@
+package payments
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToMinor(t *testing.T) {
+	testCases := []struct {
+		amount   string
+		currency string
+		expected int64
+		err      error
+	}{
+		{"12.5", "USD", 1250, nil},
+		{"12.50", "USD", 1250, nil},
+		{"0.01", "USD", 1, nil},
+		{"1e2", "USD", 10000, nil},
+		{"1500", "JPY", 1500, nil},
+		{"1.234", "KWD", 1234, nil},
+		{"12.345", "USD", 0, ErrTooPrecise},
+		{"1.5", "JPY", 0, ErrTooPrecise},
+		{"0", "USD", 0, ErrInvalidAmount},
+		{"-5", "USD", 0, ErrInvalidAmount},
+		{"abc", "USD", 0, ErrInvalidAmount},
+		{"100000000000000000000", "USD", 0, ErrInvalidAmount},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.amount+" "+tc.currency, func(t *testing.T) {
+			minor, err := ToMinor(tc.amount, tc.currency)
+
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.expected, minor)
+		})
+	}
+}
+
+func TestFormatMinor(t *testing.T) {
+	assert.Equal(t, "12.50", FormatMinor(1250, "USD"))
+	assert.Equal(t, "0.05", FormatMinor(5, "USD"))
+	assert.Equal(t, "0.00", FormatMinor(0, "EUR"))
+	assert.Equal(t, "-1.00", FormatMinor(-100, "GBP"))
+	assert.Equal(t, "1500", FormatMinor(1500, "JPY"))
+	assert.Equal(t, "1.234", FormatMinor(1234, "KWD"))
+}
+
+func TestDisplayMinor(t *testing.T) {
+	assert.Equal(t, "USD 1,234.50", DisplayMinor(123450, "USD"))
+	assert.Equal(t, "USD 0.05", DisplayMinor(5, "USD"))
+	assert.Equal(t, "USD -1,000,000.00", DisplayMinor(-100000000, "USD"))
+	assert.Equal(t, "JPY 150,000", DisplayMinor(150000, "JPY"))
+	assert.Equal(t, "KWD 1.234", DisplayMinor(1234, "KWD"))
+}
+
+func TestParseCurrency(t *testing.T) {
+	code, err := ParseCurrency(" usd ")
+	assert.NoError(t, err)
+	assert.Equal(t, "USD", code)
+
+	_, err = ParseCurrency("XYZ")
+	assert.ErrorIs(t, err, ErrUnsupportedCurrency)
+}
+
+func TestFakeIsDeterministic(t *testing.T) {
+	ctx := context.Background()
+	req := IntentRequest{PaymentID: "payment-1", Amount: 1000, Currency: "USD"}
+
+	first, err := NewFake().CreateIntent(ctx, req)
+	assert.NoError(t, err)
+
+	fake := NewFake()
+	second, _ := fake.CreateIntent(ctx, req)
+	again, _ := fake.CreateIntent(ctx, IntentRequest{PaymentID: "payment-1", Amount: 99902, Currency: "USD"})
+
+	assert.Equal(t, first, second)
+	assert.Equal(t, second, again, "creating an intent is idempotent")
+	assert.Equal(t, StatusSucceeded, first.Status)
+}
+
+func TestFakeOutcomesFollowTheAmount(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake()
+
+	declined, _ := fake.CreateIntent(ctx, IntentRequest{PaymentID: "declined", Amount: 1002})
+	assert.Equal(t, StatusFailed, declined.Status)
+
+	pending, _ := fake.CreateIntent(ctx, IntentRequest{PaymentID: "pending", Amount: 1003})
+	assert.Equal(t, StatusPending, pending.Status)
+	assert.NotEmpty(t, pending.ClientSecret)
+
+	settled, err := fake.Settle(pending.Reference, true)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusSucceeded, settled.Status)
+
+	fetched, _ := fake.Fetch(ctx, pending.Reference)
+	assert.Equal(t, StatusSucceeded, fetched.Status)
+
+	_, err = fake.Fetch(ctx, "fake_pi_missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFakeRefunds(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake()
+
+	intent, _ := fake.CreateIntent(ctx, IntentRequest{PaymentID: "refund", Amount: 1000})
+
+	refund, err := fake.Refund(ctx, RefundRequest{Reference: intent.Reference, Amount: 400})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(400), refund.Amount)
+
+	_, err = fake.Refund(ctx, RefundRequest{Reference: intent.Reference, Amount: 601})
+	assert.ErrorIs(t, err, ErrRefundTooLarge)
+
+	_, err = fake.Refund(ctx, RefundRequest{Reference: intent.Reference, Amount: 600})
+	assert.NoError(t, err)
+
+	fetched, _ := fake.Fetch(ctx, intent.Reference)
+	assert.Equal(t, StatusRefunded, fetched.Status)
+	assert.Equal(t, int64(1000), fetched.Refunded)
+
+	_, err = fake.Refund(ctx, RefundRequest{Reference: intent.Reference, Amount: 1})
+	assert.ErrorIs(t, err, ErrNotRefundable)
+}
+
+func TestFakeRefundsAreIdempotent(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake()
+
+	intent, _ := fake.CreateIntent(ctx, IntentRequest{PaymentID: "idempotent", Amount: 1000})
+	key := RefundKey(uuid.New(), 0, 400)
+
+	first, err := fake.Refund(ctx, RefundRequest{Reference: intent.Reference, Amount: 400, IdempotencyKey: key})
+	assert.NoError(t, err)
+
+	again, err := fake.Refund(ctx, RefundRequest{Reference: intent.Reference, Amount: 400, IdempotencyKey: key})
+	assert.NoError(t, err)
+	assert.Equal(t, first, again)
+
+	fetched, _ := fake.Fetch(ctx, intent.Reference)
+	assert.Equal(t, int64(400), fetched.Refunded)
+}
+
+func TestRefundKey(t *testing.T) {
+	paymentId := uuid.New()
+
+	assert.Equal(t, RefundKey(paymentId, 0, 400), RefundKey(paymentId, 0, 400))
+	assert.NotEqual(t, RefundKey(paymentId, 0, 400), RefundKey(paymentId, 400, 400))
+	assert.NotEqual(t, RefundKey(paymentId, 0, 400), RefundKey(uuid.New(), 0, 400))
+}
@@ -0,0 +1,118 @@
+// Package payments takes payments through pluggable providers. Amounts
+// are always integers in the currency's minor unit.
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Statuses of a payment at the provider
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusRefunded  = "refunded"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown payment provider")
+	ErrNotFound        = errors.New("payment not found at the provider")
+	ErrNotRefundable   = errors.New("payment can't be refunded")
+	ErrRefundTooLarge  = errors.New("refund is more than what is left of the payment")
+)
+
+// IntentRequest asks a provider to start taking a payment
+type IntentRequest struct {
+	// Our payment id. Providers use it to make creating an intent
+	// idempotent.
+	PaymentID   string
+	Amount      int64
+	Currency    string
+	Description string
+	CustomerID  string
+}
+
+// Intent is a payment as the provider sees it
+type Intent struct {
+	Reference string
+	Status    string
+	Amount    int64
+	Currency  string
+	Refunded  int64
+	// Passed to the client when the customer still has to act, e.g. to
+	// confirm a card
+	ClientSecret string
+}
+
+// RefundRequest asks a provider to return some of a payment
+type RefundRequest struct {
+	Reference string
+	Amount    int64
+	// Providers make a refund once per key and answer repeats with it
+	IdempotencyKey string
+}
+
+// Refund is money returned from a payment
+type Refund struct {
+	Reference string
+	Amount    int64
+}
+
+// Provider is a payment service
+type Provider interface {
+	Name() string
+	CreateIntent(ctx context.Context, req IntentRequest) (Intent, error)
+	Fetch(ctx context.Context, reference string) (Intent, error)
+	Refund(ctx context.Context, req RefundRequest) (Refund, error)
+}
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]Provider{}
+)
+
+// Register a provider under its name
+func Register(p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[p.Name()] = p
+}
+
+// Find a registered provider
+func Lookup(name string) (Provider, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	p, ok := providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p, nil
+}
+
+// Names of the registered providers
+func Providers() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The idempotency key of a refund: the payment, how much of it was
+// refunded before and the amount. A refund retried before the first
+// attempt was recorded gets the same key, so the provider doesn't pay it
+// out twice, while a later refund of the same amount gets a new one.
+func RefundKey(paymentId uuid.UUID, refundedBefore int64, amount int64) string {
+	return fmt.Sprintf("refund:%s:%d:%d", paymentId, refundedBefore, amount)
+}
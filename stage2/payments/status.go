@@ -0,0 +1,38 @@
+package payments
+
+import "fmt"
+
+// Moves a payment's status can make. A payment is pending until the
+// provider settles it, and only a successful payment can be refunded.
+// Failed and refunded payments are final.
+var transitions = map[string][]string{
+	StatusPending:   {StatusSucceeded, StatusFailed},
+	StatusSucceeded: {StatusRefunded},
+}
+
+// ErrInvalidTransition is returned for a status change the state machine
+// doesn't allow, e.g. a late "failed" event for a refunded payment
+type ErrInvalidTransition struct {
+	From string
+	To   string
+}
+
+func (e ErrInvalidTransition) Error() string {
+	return fmt.Sprintf("payment can't move from %s to %s", e.From, e.To)
+}
+
+// Check a payment can move from one status to another. Staying in the
+// same status is allowed, so events that repeat a status are no-ops.
+func Transition(from string, to string) error {
+	if from == to {
+		return nil
+	}
+
+	for _, next := range transitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+
+	return ErrInvalidTransition{From: from, To: to}
+}
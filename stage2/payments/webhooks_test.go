@@ -0,0 +1,65 @@
+package payments
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifySignature(t *testing.T) {
+	secret := []byte("whsec_test")
+	body := []byte(`{"id":"evt_1","type":"payment.succeeded","data":{"provider":"fake"}}`)
+	now := time.Unix(1700000000, 0)
+
+	testCases := []struct {
+		name   string
+		header string
+		err    error
+	}{
+		{"valid", Sign(secret, now, body), nil},
+		{"within tolerance", Sign(secret, now.Add(-4*time.Minute), body), nil},
+		{"too old", Sign(secret, now.Add(-6*time.Minute), body), ErrSignatureExpired},
+		{"too far ahead", Sign(secret, now.Add(6*time.Minute), body), ErrSignatureExpired},
+		{"wrong secret", Sign([]byte("other"), now, body), ErrSignatureMismatch},
+		{"rotated secret", Sign(secret, now, body) + ",v1=" + signature([]byte("old"), "1700000000", body), nil},
+		{"missing", "", ErrSignatureMissing},
+		{"no timestamp", "v1=abc", ErrSignatureMissing},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := VerifySignature(tc.header, body, secret, DefaultSignatureTolerance, now)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+
+	t.Run("tampered body", func(t *testing.T) {
+		err := VerifySignature(Sign(secret, now, body), []byte(`{"id":"evt_2"}`), secret, DefaultSignatureTolerance, now)
+		assert.ErrorIs(t, err, ErrSignatureMismatch)
+	})
+}
+
+func TestTransition(t *testing.T) {
+	assert.NoError(t, Transition(StatusPending, StatusSucceeded))
+	assert.NoError(t, Transition(StatusPending, StatusFailed))
+	assert.NoError(t, Transition(StatusSucceeded, StatusRefunded))
+	assert.NoError(t, Transition(StatusSucceeded, StatusSucceeded))
+
+	assert.ErrorAs(t, Transition(StatusFailed, StatusSucceeded), &ErrInvalidTransition{})
+	assert.ErrorAs(t, Transition(StatusRefunded, StatusFailed), &ErrInvalidTransition{})
+	assert.ErrorAs(t, Transition(StatusPending, StatusRefunded), &ErrInvalidTransition{})
+}
+
+func TestParseEvent(t *testing.T) {
+	event, err := ParseEvent([]byte(`{"id":"evt_1","type":"payment.failed","data":{"reference":"fake_pi_1","provider":"fake"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "evt_1", event.ID)
+	assert.Equal(t, "fake_pi_1", event.Data.Reference)
+
+	_, err = ParseEvent([]byte(`{"type":"payment.failed","data":{"provider":"fake"}}`))
+	assert.ErrorIs(t, err, ErrInvalidEvent)
+
+	_, err = ParseEvent([]byte(`not json`))
+	assert.ErrorIs(t, err, ErrInvalidEvent)
+}
@@ -0,0 +1,50 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocumentStructure(t *testing.T) {
+	doc := New()
+	doc.AddPage().Text(50, 800, Helvetica, 12, "Invoice (draft)")
+	second := doc.AddPage()
+	second.TextRight(545, 800, 10, "1,234.50")
+	second.Line(50, 790, 545, 790, 0.5)
+
+	out := doc.Bytes()
+
+	assert.True(t, bytes.HasPrefix(out, []byte("%PDF-1.4\n")))
+	assert.True(t, bytes.HasSuffix(out, []byte("%%EOF\n")))
+	assert.Contains(t, string(out), "/Count 2")
+	assert.Contains(t, string(out), `(Invoice \(draft\)) Tj`)
+
+	// Every xref entry points at its object
+	startxref := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(out)
+	if assert.NotNil(t, startxref) {
+		xref, _ := strconv.Atoi(string(startxref[1]))
+		entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(out[xref:], -1)
+		assert.Len(t, entries, 2+len(fontNames)+2*2)
+
+		for i, entry := range entries {
+			offset, _ := strconv.Atoi(string(entry[1]))
+			assert.True(t, bytes.HasPrefix(out[offset:], []byte(fmt.Sprintf("%d 0 obj", i+1))), "object %d", i+1)
+		}
+	}
+
+	assert.Equal(t, out, doc.Bytes(), "rendering is deterministic")
+}
+
+func TestEscape(t *testing.T) {
+	assert.Equal(t, `a\\b \(c\)`, escape(`a\b (c)`))
+	assert.Equal(t, "caf\xe9 \x80 ?", escape("café € ☃"))
+}
+
+func TestEmptyDocumentHasAPage(t *testing.T) {
+	assert.Contains(t, string(New().Bytes()), "/Count 1")
+}
@@ -0,0 +1,213 @@
+package policy
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/models"
+	"gorm.io/gorm"
+)
+
+// Permissions within an organisation
+const (
+	OrgRead        = "org:read"
+	OrgUpdate      = "org:update"
+	MemberRead     = "member:read"
+	MemberInvite   = "member:invite"
+	MemberUpdate   = "member:update"
+	MemberRemove   = "member:remove"
+	TeamRead       = "team:read"
+	TeamManage     = "team:manage"
+	RoleManage     = "role:manage"
+	ApiKeyManage   = "apikey:manage"
+	AuditRead      = "audit:read"
+	TemplateManage = "template:manage"
+	PaymentCreate  = "payment:create"
+	PaymentManage  = "payment:manage"
+	BillingManage  = "billing:manage"
+	InvoiceRead    = "invoice:read"
+	WebhookManage  = "webhook:manage"
+)
+
+// Every permission, in the order they're documented
+var AllPermissions = []string{
+	OrgRead,
+	OrgUpdate,
+	MemberRead,
+	MemberInvite,
+	MemberUpdate,
+	MemberRemove,
+	TeamRead,
+	TeamManage,
+	RoleManage,
+	ApiKeyManage,
+	AuditRead,
+	TemplateManage,
+	PaymentCreate,
+	PaymentManage,
+	BillingManage,
+	InvoiceRead,
+	WebhookManage,
+}
+
+// Permissions of the built in roles. Organisations can't change these.
+var builtInRoles = map[string][]string{
+	models.RoleAdmin:  AllPermissions,
+	models.RoleMember: {OrgRead, MemberRead, TeamRead},
+}
+
+var (
+	ErrNotMember = errors.New("not a member of the organisation")
+	// The organisation doesn't exist or is in the trash
+	ErrOrgNotFound = errors.New("organisation not found")
+	// Giving members roles takes role:manage
+	ErrCannotAssignRoles = errors.New("you need the " + RoleManage + " permission to change members' roles")
+	// Nobody can give or take away a role with permissions they don't hold
+	ErrRoleExceedsOwn = errors.New("you can't change a member to or from a role with permissions you don't hold")
+	// Nor define or change a role that has them
+	ErrDefinitionExceedsOwn = errors.New("you can't define or change a role with permissions you don't hold")
+)
+
+// A set of permissions
+type Set map[string]bool
+
+func NewSet(permissions ...string) Set {
+	set := Set{}
+	for _, permission := range permissions {
+		set[permission] = true
+	}
+	return set
+}
+
+func (s Set) Has(permission string) bool {
+	return s[permission]
+}
+
+// Whether the set holds every permission in other
+func (s Set) Covers(other Set) bool {
+	for permission := range other {
+		if !s[permission] {
+			return false
+		}
+	}
+	return true
+}
+
+// The permissions in the set, sorted
+func (s Set) List() []string {
+	list := make([]string, 0, len(s))
+	for permission := range s {
+		list = append(list, permission)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func IsPermission(permission string) bool {
+	for _, p := range AllPermissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func IsBuiltInRole(role string) bool {
+	_, ok := BuiltInRole(role)
+	return ok
+}
+
+// The permissions of a built in role
+func BuiltInRole(role string) ([]string, bool) {
+	permissions, ok := builtInRoles[role]
+	return permissions, ok
+}
+
+// The permissions a user holds in an organisation and the name of the
+// role that grants them. Returns ErrNotMember for non members, and
+// ErrOrgNotFound for organisations that don't exist or are in the trash,
+// which nobody holds any permissions in.
+func OrgPermissions(db *gorm.DB, orgId string, userId string) (string, Set, error) {
+	if _, err := uuid.Parse(orgId); err != nil {
+		return "", nil, ErrOrgNotFound
+	}
+
+	var membership models.Membership
+	err := db.Joins("JOIN organisations ON organisations.id = user_organizations.organisation_id AND organisations.deleted_at IS NULL").
+		Where("user_organizations.organisation_id = ? AND user_organizations.user_user_id = ?", orgId, userId).
+		First(&membership).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil, missingMembership(db, orgId)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	// A custom role that has since been deleted grants nothing
+	permissions, _, err := RolePermissions(db, orgId, membership.Role)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return membership.Role, permissions, nil
+}
+
+// Why a user has no membership of an organisation
+func missingMembership(db *gorm.DB, orgId string) error {
+	var orgs int64
+	if err := db.Model(&models.Organisation{}).Where("id = ?", orgId).Count(&orgs).Error; err != nil {
+		return err
+	}
+	if orgs == 0 {
+		return ErrOrgNotFound
+	}
+	return ErrNotMember
+}
+
+// The permissions a role grants in an organisation. ok is false for
+// custom roles the organisation doesn't have.
+func RolePermissions(db *gorm.DB, orgId string, role string) (Set, bool, error) {
+	if permissions, ok := builtInRoles[role]; ok {
+		return NewSet(permissions...), true, nil
+	}
+
+	var orgRole models.OrgRole
+	err := db.Where("organisation_id = ? AND name = ?", orgId, role).First(&orgRole).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Set{}, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return NewSet(orgRole.PermissionList()...), true, nil
+}
+
+// Check a caller holding some permissions can move a member from one
+// role to another. It takes role:manage, and the caller must hold every
+// permission of both roles, so nobody can grant more than they have,
+// themselves included.
+func CanAssignRole(caller Set, from Set, to Set) error {
+	if !caller.Has(RoleManage) {
+		return ErrCannotAssignRoles
+	}
+	if !caller.Covers(from) || !caller.Covers(to) {
+		return ErrRoleExceedsOwn
+	}
+	return nil
+}
+
+// Check a caller may give a custom role the permissions to, when it has
+// the permissions from now. from is empty for a new role. Like assigning
+// roles, this needs role:manage and stays within the caller's own
+// permissions, so nobody can make a role more powerful than themselves.
+func CanDefineRole(caller Set, from Set, to Set) error {
+	if !caller.Has(RoleManage) {
+		return ErrCannotAssignRoles
+	}
+	if !caller.Covers(from) || !caller.Covers(to) {
+		return ErrDefinitionExceedsOwn
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuiltInRoles(t *testing.T) {
+	admin, ok := BuiltInRole(models.RoleAdmin)
+	assert.True(t, ok)
+	assert.ElementsMatch(t, AllPermissions, admin)
+
+	member, ok := BuiltInRole(models.RoleMember)
+	assert.True(t, ok)
+	assert.Contains(t, member, OrgRead)
+	assert.NotContains(t, member, MemberInvite)
+
+	assert.False(t, IsBuiltInRole("billing"))
+}
+
+func TestSet(t *testing.T) {
+	set := NewSet(TeamRead, OrgRead, TeamRead)
+
+	assert.True(t, set.Has(OrgRead))
+	assert.False(t, set.Has(OrgUpdate))
+	assert.Equal(t, []string{OrgRead, TeamRead}, set.List())
+	assert.Empty(t, Set{}.List())
+}
+
+func TestCovers(t *testing.T) {
+	set := NewSet(OrgRead, MemberRead, TeamRead)
+
+	assert.True(t, set.Covers(NewSet(OrgRead, TeamRead)))
+	assert.True(t, set.Covers(Set{}))
+	assert.False(t, set.Covers(NewSet(OrgRead, MemberInvite)))
+}
+
+func TestCanAssignRoleNeedsRoleManage(t *testing.T) {
+	member, _ := BuiltInRole(models.RoleMember)
+	admin, _ := BuiltInRole(models.RoleAdmin)
+
+	// member:update alone doesn't let anyone hand out roles, even to
+	// themselves
+	caller := NewSet(OrgRead, MemberRead, TeamRead, MemberUpdate)
+	assert.ErrorIs(t, CanAssignRole(caller, NewSet(member...), NewSet(admin...)), ErrCannotAssignRoles)
+	assert.ErrorIs(t, CanAssignRole(caller, NewSet(member...), NewSet(OrgRead)), ErrCannotAssignRoles)
+
+	assert.NoError(t, CanAssignRole(NewSet(admin...), NewSet(member...), NewSet(admin...)))
+}
+
+func TestCanAssignRoleOnlyWithinOwnPermissions(t *testing.T) {
+	member, _ := BuiltInRole(models.RoleMember)
+	admin, _ := BuiltInRole(models.RoleAdmin)
+	caller := NewSet(append(member, RoleManage, MemberUpdate)...)
+
+	// Granting a role with permissions the caller lacks
+	assert.ErrorIs(t, CanAssignRole(caller, NewSet(member...), NewSet(admin...)), ErrRoleExceedsOwn)
+	assert.ErrorIs(t, CanAssignRole(caller, NewSet(member...), NewSet(OrgRead, BillingManage)), ErrRoleExceedsOwn)
+
+	// Taking one away from someone who holds more
+	assert.ErrorIs(t, CanAssignRole(caller, NewSet(admin...), NewSet(member...)), ErrRoleExceedsOwn)
+
+	assert.NoError(t, CanAssignRole(caller, NewSet(member...), NewSet(OrgRead, MemberUpdate)))
+}
+
+func TestIsPermission(t *testing.T) {
+	for _, permission := range AllPermissions {
+		assert.True(t, IsPermission(permission), permission)
+	}
+
+	assert.False(t, IsPermission("org:*"))
+	assert.False(t, IsPermission(""))
+}
+
+func TestOrgPermissionsRejectsInvalidOrgId(t *testing.T) {
+	_, _, err := OrgPermissions(dryRunDb(t), "not-a-uuid", "user")
+
+	assert.ErrorIs(t, err, ErrOrgNotFound)
+}
+
+func TestCanDefineRoleOnlyWithinOwnPermissions(t *testing.T) {
+	caller := NewSet(OrgRead, MemberRead, RoleManage)
+
+	assert.NoError(t, CanDefineRole(caller, Set{}, NewSet(OrgRead, MemberRead)))
+	assert.ErrorIs(t, CanDefineRole(caller, Set{}, NewSet(OrgRead, BillingManage)), ErrDefinitionExceedsOwn)
+
+	// Nor can a role the caller doesn't fully hold be changed, even to
+	// take permissions away
+	assert.ErrorIs(t, CanDefineRole(caller, NewSet(OrgRead, WebhookManage), NewSet(OrgRead)), ErrDefinitionExceedsOwn)
+
+	assert.ErrorIs(t, CanDefineRole(NewSet(OrgRead), Set{}, NewSet(OrgRead)), ErrCannotAssignRoles)
+}
@@ -0,0 +1,28 @@
+package policy
+
+import (
+	"github.com/mryan-3/hng11/stage2/models"
+	"gorm.io/gorm"
+)
+
+// VisibleUsers limits a users query to the users the viewer may see:
+// themselves and anyone sharing an organisation with them. Platform
+// admins see everyone.
+//
+//	database.DB.Db.Scopes(policy.VisibleUsers(viewer)).Find(&users)
+func VisibleUsers(viewer models.User) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if viewer.IsPlatformAdmin() {
+			return db
+		}
+
+		return db.Where(
+			`users.user_id = ? OR users.user_id IN (
+				SELECT member.user_user_id FROM user_organizations AS member
+				JOIN user_organizations AS viewer ON viewer.organisation_id = member.organisation_id
+				WHERE viewer.user_user_id = ?
+			)`,
+			viewer.UserID, viewer.UserID,
+		)
+	}
+}
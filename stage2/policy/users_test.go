@@ -0,0 +1,45 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Build queries without a database
+func dryRunDb(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to open dry run db: %v", err)
+	}
+	return db
+}
+
+func TestVisibleUsersLimitsToSharedOrganisations(t *testing.T) {
+	viewer := models.User{UserID: uuid.New(), PlatformRole: models.PlatformRoleUser}
+
+	var users []models.User
+	stmt := dryRunDb(t).Scopes(VisibleUsers(viewer)).Find(&users).Statement
+
+	sql := stmt.SQL.String()
+	assert.Contains(t, sql, "users.user_id = $1 OR users.user_id IN")
+	assert.Contains(t, sql, "viewer.user_user_id = $2")
+	assert.Equal(t, []interface{}{viewer.UserID, viewer.UserID}, stmt.Vars)
+}
+
+func TestVisibleUsersPlatformAdminSeesEveryone(t *testing.T) {
+	admin := models.User{UserID: uuid.New(), PlatformRole: models.PlatformRoleAdmin}
+
+	var users []models.User
+	stmt := dryRunDb(t).Scopes(VisibleUsers(admin)).Find(&users).Statement
+
+	assert.NotContains(t, stmt.SQL.String(), "user_organizations")
+	assert.Empty(t, stmt.Vars)
+}
@@ -2,9 +2,12 @@ package routes
 
 import (
 	"github.com/gofiber/fiber/v2"
+	"github.com/mryan-3/hng11/stage2/config"
 	userControllers "github.com/mryan-3/hng11/stage2/controller"
 	organisationControllers "github.com/mryan-3/hng11/stage2/controller"
 	"github.com/mryan-3/hng11/stage2/middleware"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/policy"
 )
 
 
@@ -14,15 +17,127 @@ func SetUpRoutes(app *fiber.App) {
 
     app.Post("/auth/register", userControllers.CreateUser)
     app.Post("/auth/login", userControllers.LoginUser)
+    app.Post("/auth/login/2fa", userControllers.VerifyTwoFactorLogin)
+    app.Post("/auth/confirm-email", userControllers.ConfirmEmailChange)
+
+    // Two-factor authentication routes
+    app.Post("/auth/2fa/setup", middleware.UserAuth, userControllers.SetupTwoFactor)
+    app.Post("/auth/2fa/enable", middleware.UserAuth, userControllers.EnableTwoFactor)
+    app.Post("/auth/2fa/disable", middleware.UserAuth, userControllers.DisableTwoFactor)
+    app.Post("/auth/2fa/recovery-codes", middleware.UserAuth, userControllers.RegenerateRecoveryCodes)
 
     // User organisation routes
-    api.Get("/organisations", middleware.UserAuth, organisationControllers.GetUserOrganisations)
-    api.Get("/users", userControllers.GetUsers)
-    api.Get("/organisations/:orgId", middleware.UserAuth, organisationControllers.GetSingleOrganisation)
-    api.Post("/organisations", middleware.UserAuth, organisationControllers.CreateOrganisation)
-    api.Post("/organisations/:orgId/users", middleware.UserAuth, organisationControllers.AddUserToOrganisation)
+    api.Get("/organisations", middleware.ApiAuth(models.ScopeOrganisationsRead), organisationControllers.GetUserOrganisations)
+    api.Get("/users", middleware.ApiAuth(models.ScopeUsersRead), userControllers.GetUsers)
+    api.Get("/organisations/:orgId", middleware.ApiAuth(models.ScopeOrganisationsRead), middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.OrgRead), organisationControllers.GetSingleOrganisation)
+    api.Post("/organisations", middleware.ApiAuth(models.ScopeOrganisationsWrite), organisationControllers.CreateOrganisation)
+    api.Put("/organisations/:orgId", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.OrgUpdate), organisationControllers.UpdateOrganisation)
+    // Only the owner can delete an organisation, which the handler checks
+    api.Delete("/organisations/:orgId", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.OrgUpdate), organisationControllers.DeleteOrganisation)
+    api.Get("/organisations/:orgId/users", middleware.ApiAuth(models.ScopeOrganisationsRead), middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.MemberRead), organisationControllers.GetOrganisationMembers)
+    api.Post("/organisations/:orgId/users", middleware.ApiAuth(models.ScopeOrganisationsWrite), middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.MemberInvite), organisationControllers.AddUserToOrganisation)
+    api.Put("/organisations/:orgId/users/:userId", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.MemberUpdate), organisationControllers.UpdateMembership)
+    api.Delete("/organisations/:orgId/users/:userId", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermissionOrSelf(policy.MemberRemove), organisationControllers.RemoveMember)
+    api.Put("/organisations/:orgId/two-factor", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.OrgUpdate), organisationControllers.UpdateOrganisationTwoFactorPolicy)
+
+    // Role and permission routes
+    api.Get("/organisations/:orgId/permissions/me", middleware.ApiAuth(models.ScopeOrganisationsRead), organisationControllers.GetMyPermissions)
+    api.Get("/organisations/:orgId/roles", middleware.ApiAuth(models.ScopeOrganisationsRead), middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.OrgRead), organisationControllers.GetOrgRoles)
+    api.Post("/organisations/:orgId/roles", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.RoleManage), organisationControllers.CreateOrgRole)
+    api.Put("/organisations/:orgId/roles/:roleId", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.RoleManage), organisationControllers.UpdateOrgRole)
+    api.Delete("/organisations/:orgId/roles/:roleId", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.RoleManage), organisationControllers.DeleteOrgRole)
+
+    // Audit log routes
+    api.Get("/organisations/:orgId/audit-events", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.AuditRead), organisationControllers.GetAuditEvents)
+    api.Get("/organisations/:orgId/audit-events/export", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.AuditRead), organisationControllers.ExportAuditEvents)
+    api.Get("/organisations/:orgId/audit-events/verify", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.AuditRead), organisationControllers.VerifyAuditEvents)
+
+    // Team routes
+    api.Post("/organisations/:orgId/teams", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequireMember, organisationControllers.CreateTeam)
+    api.Get("/organisations/:orgId/teams", middleware.ApiAuth(models.ScopeOrganisationsRead), middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.TeamRead), organisationControllers.GetTeams)
+    api.Get("/organisations/:orgId/teams/:teamId", middleware.ApiAuth(models.ScopeOrganisationsRead), middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.TeamRead), organisationControllers.GetTeam)
+    api.Put("/organisations/:orgId/teams/:teamId", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequireMember, organisationControllers.UpdateTeam)
+    api.Delete("/organisations/:orgId/teams/:teamId", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequireMember, organisationControllers.DeleteTeam)
+    api.Put("/organisations/:orgId/teams/:teamId/members/:userId", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequireMember, organisationControllers.SetTeamMember)
+    api.Delete("/organisations/:orgId/teams/:teamId/members/:userId", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequireMember, organisationControllers.RemoveTeamMember)
+
+    // Ownership transfer routes
+    // Only the owner can transfer ownership, which the handler checks
+    api.Post("/organisations/:orgId/ownership-transfers", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.OrgUpdate), organisationControllers.CreateOwnershipTransfer)
+    api.Post("/ownership-transfers/:transferId/accept", middleware.UserAuth, organisationControllers.AcceptOwnershipTransfer)
+    api.Post("/ownership-transfers/:transferId/decline", middleware.UserAuth, organisationControllers.DeclineOwnershipTransfer)
+    api.Delete("/ownership-transfers/:transferId", middleware.UserAuth, organisationControllers.CancelOwnershipTransfer)
+
+    // API key routes. Keys belong to users, or to the organisation named in
+    // the body or query, so apikey:manage is checked in the handlers.
+    api.Post("/api-keys", middleware.UserAuth, userControllers.CreateApiKey)
+    api.Get("/api-keys", middleware.UserAuth, userControllers.GetApiKeys)
+    api.Get("/api-keys/:keyId", middleware.UserAuth, userControllers.GetApiKey)
+    api.Put("/api-keys/:keyId", middleware.UserAuth, userControllers.UpdateApiKey)
+    api.Delete("/api-keys/:keyId", middleware.UserAuth, userControllers.DeleteApiKey)
+
+    // Session routes
+    api.Get("/sessions", middleware.UserAuth, userControllers.GetSessions)
+    api.Delete("/sessions/:id", middleware.UserAuth, userControllers.RevokeSession)
+    api.Get("/csrf-token", middleware.UserAuth, userControllers.GetCsrfToken)
+
+    // Account routes
+    api.Get("/me/export", middleware.UserAuth, userControllers.ExportAccount)
+    api.Delete("/me", middleware.UserAuth, userControllers.DeleteAccount)
+
+    // Payment routes
+    api.Post("/payments/create", middleware.UserAuth, userControllers.CreatePayment)
+    api.Post("/payments/webhook", userControllers.PaymentWebhook)
+    api.Get("/payments/:paymentId", middleware.UserAuth, userControllers.GetPayment)
+    api.Post("/payments/:paymentId/refund", middleware.UserAuth, userControllers.RefundPayment)
+    api.Get("/organisations/:orgId/payments", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.PaymentManage), organisationControllers.GetOrgPayments)
+
+    // Billing routes
+    api.Get("/plans", middleware.UserAuth, userControllers.GetPlans)
+    api.Get("/organisations/:orgId/subscription", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.OrgRead), organisationControllers.GetSubscription)
+    api.Put("/organisations/:orgId/subscription", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.BillingManage), organisationControllers.ChangeSubscription)
+    api.Get("/organisations/:orgId/invoices", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.InvoiceRead), organisationControllers.GetOrgInvoices)
+    api.Get("/organisations/:orgId/invoices/:invoiceId", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.InvoiceRead), organisationControllers.GetOrgInvoice)
+    api.Get("/organisations/:orgId/invoices/:invoiceId/download", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.InvoiceRead), organisationControllers.DownloadInvoice)
+
+    // Webhook routes
+    api.Get("/organisations/:orgId/webhooks", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.WebhookManage), organisationControllers.GetOrgWebhooks)
+    api.Post("/organisations/:orgId/webhooks", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.WebhookManage), organisationControllers.CreateOrgWebhook)
+    api.Get("/organisations/:orgId/webhooks/:webhookId", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.WebhookManage), organisationControllers.GetOrgWebhook)
+    api.Put("/organisations/:orgId/webhooks/:webhookId", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.WebhookManage), organisationControllers.UpdateOrgWebhook)
+    api.Delete("/organisations/:orgId/webhooks/:webhookId", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.WebhookManage), organisationControllers.DeleteOrgWebhook)
+    api.Post("/organisations/:orgId/webhooks/:webhookId/rotate-secret", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.WebhookManage), organisationControllers.RotateOrgWebhookSecret)
+    api.Get("/organisations/:orgId/webhooks/:webhookId/deliveries", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.WebhookManage), organisationControllers.GetOrgWebhookDeliveries)
+    api.Get("/organisations/:orgId/webhooks/:webhookId/deliveries/:deliveryId", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.WebhookManage), organisationControllers.GetOrgWebhookDelivery)
+    api.Post("/organisations/:orgId/webhooks/:webhookId/deliveries/:deliveryId/retry", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.WebhookManage), organisationControllers.RetryOrgWebhookDelivery)
+
+    // Platform admin routes
+    admin := api.Group("/admin", middleware.UserAuth, middleware.PlatformAdmin)
+    admin.Delete("/users/:id", userControllers.DeleteUser)
+    admin.Get("/trash/users", userControllers.GetTrashedUsers)
+    admin.Get("/trash/organisations", organisationControllers.GetTrashedOrganisations)
+    admin.Post("/trash/users/:id/restore", userControllers.RestoreUser)
+    admin.Post("/trash/organisations/:orgId/restore", organisationControllers.RestoreOrganisation)
+
+    // Email routes
+    api.Post("/emails/send", middleware.UserAuth, middleware.PlatformAdmin, userControllers.SendEmail)
+    api.Get("/email-templates", middleware.UserAuth, organisationControllers.GetSystemEmailTemplates)
+    api.Get("/organisations/:orgId/email-templates", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.OrgRead), organisationControllers.GetOrgEmailTemplates)
+    api.Post("/organisations/:orgId/email-templates", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.TemplateManage), organisationControllers.CreateOrgEmailTemplate)
+    api.Get("/organisations/:orgId/email-templates/:templateId", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.OrgRead), organisationControllers.GetOrgEmailTemplate)
+    api.Put("/organisations/:orgId/email-templates/:templateId", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.TemplateManage), organisationControllers.UpdateOrgEmailTemplate)
+    api.Delete("/organisations/:orgId/email-templates/:templateId", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.TemplateManage), organisationControllers.DeleteOrgEmailTemplate)
+    api.Post("/organisations/:orgId/email-templates/:templateId/preview", middleware.UserAuth, middleware.OrgTwoFactorPolicy, middleware.RequirePermission(policy.TemplateManage), organisationControllers.PreviewOrgEmailTemplate)
+
+    // Captured emails can be read back by platform admins in development only
+    if config.Env() == config.Development {
+        api.Get("/dev/emails", middleware.UserAuth, middleware.PlatformAdmin, userControllers.GetCapturedEmails)
+        api.Get("/dev/emails/:emailId", middleware.UserAuth, middleware.PlatformAdmin, userControllers.GetCapturedEmail)
+        api.Delete("/dev/emails", middleware.UserAuth, middleware.PlatformAdmin, userControllers.ClearCapturedEmails)
+    }
 
 	// User routes
-    user.Get("/:id", middleware.UserAuth, userControllers.GetUser)
+    user.Get("/:id", middleware.ApiAuth(models.ScopeUsersRead), userControllers.GetUser)
+    user.Put("/:id", middleware.UserAuth, userControllers.UpdateUser)
 
 }
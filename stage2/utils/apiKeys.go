@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+)
+
+// Every API key starts with this so it can be told apart from a JWT
+const ApiKeyPrefix = "hng_"
+
+var apiKeyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Generates a new API key of the form hng_<id>_<secret>. The returned
+// prefix (hng_<id>) is safe to display and is used to look the key up.
+func GenerateApiKey() (key string, prefix string, err error) {
+	raw := make([]byte, 30)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+
+	encoded := strings.ToLower(apiKeyEncoding.EncodeToString(raw))
+	prefix = ApiKeyPrefix + encoded[:8]
+	key = prefix + "_" + encoded[8:]
+
+	return key, prefix, nil
+}
+
+// Returns the visible prefix of an API key
+func ApiKeyPrefixOf(key string) (string, bool) {
+	if !IsApiKey(key) {
+		return "", false
+	}
+
+	prefix, _, found := strings.Cut(key[len(ApiKeyPrefix):], "_")
+	if !found || len(prefix) != 8 {
+		return "", false
+	}
+
+	return ApiKeyPrefix + prefix, true
+}
+
+func IsApiKey(token string) bool {
+	return strings.HasPrefix(token, ApiKeyPrefix)
+}
+
+func HashApiKey(key string) string {
+	return HashToken(key)
+}
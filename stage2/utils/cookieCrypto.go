@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+)
+
+// Cookie values are sealed with AES-256-GCM, which both encrypts and
+// authenticates them. The format is <keyId>.<base64(nonce|ciphertext)>
+// where keyId identifies which configured key sealed the value.
+//
+// ENCRYPT_COOKIE_KEY is the current key. Keys being rotated out are
+// listed comma separated in ENCRYPT_COOKIE_PREVIOUS_KEYS so cookies
+// sealed with them can still be opened until they expire.
+
+var ErrInvalidCookie = errors.New("invalid cookie")
+
+type cookieKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// Seals a cookie value with the current key
+func EncryptCookie(value string) (string, error) {
+	keys, err := cookieKeys()
+	if err != nil {
+		return "", err
+	}
+	key := keys[0]
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := key.aead.Seal(nonce, nonce, []byte(value), []byte(key.id))
+
+	return key.id + "." + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Opens a cookie value sealed with the current or a previous key
+func DecryptCookie(value string) (string, error) {
+	keyId, encoded, found := strings.Cut(value, ".")
+	if !found {
+		return "", ErrInvalidCookie
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+
+	keys, err := cookieKeys()
+	if err != nil {
+		return "", err
+	}
+
+	for _, key := range keys {
+		if key.id != keyId {
+			continue
+		}
+
+		nonceSize := key.aead.NonceSize()
+		if len(sealed) < nonceSize {
+			return "", ErrInvalidCookie
+		}
+
+		plain, err := key.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(key.id))
+		if err != nil {
+			return "", ErrInvalidCookie
+		}
+
+		return string(plain), nil
+	}
+
+	return "", ErrInvalidCookie
+}
+
+// Build the key ring, current key first
+func cookieKeys() ([]cookieKey, error) {
+	secrets := []string{os.Getenv("ENCRYPT_COOKIE_KEY")}
+	if secrets[0] == "" {
+		return nil, errors.New("ENCRYPT_COOKIE_KEY is not set")
+	}
+
+	for _, previous := range strings.Split(os.Getenv("ENCRYPT_COOKIE_PREVIOUS_KEYS"), ",") {
+		if previous = strings.TrimSpace(previous); previous != "" {
+			secrets = append(secrets, previous)
+		}
+	}
+
+	keys := make([]cookieKey, 0, len(secrets))
+	for _, secret := range secrets {
+		// Derive a 256 bit key so any length of secret can be configured
+		derived := sha256.Sum256([]byte(secret))
+
+		block, err := aes.NewCipher(derived[:])
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		id := sha256.Sum256(derived[:])
+		keys = append(keys, cookieKey{id: hex.EncodeToString(id[:4]), aead: aead})
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+)
+
+// CSRF tokens are an HMAC of the login session id, so they stay valid
+// for the life of the session and need no storage of their own
+func CsrfToken(sessionId string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+	mac.Write([]byte("csrf:" + sessionId))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Checks a CSRF token against the session it should belong to
+func ValidCsrfToken(sessionId string, token string) bool {
+	if sessionId == "" || token == "" {
+		return false
+	}
+
+	return hmac.Equal([]byte(CsrfToken(sessionId)), []byte(token))
+}
@@ -8,17 +8,21 @@ import (
     "github.com/golang-jwt/jwt/v5"
 )
 
-// Signs a JWT Token
-func SignJwtToken(text string) (jwtToken string, err error) {
+// Purpose claim carried by MFA challenge tokens
+const MfaChallengePurpose = "mfa_challenge"
+
+// How long access tokens and their sessions last
+const JwtTokenLifetime = time.Hour * 24 * 7
+
+// Signs a JWT Token bound to a login session
+func SignSessionJwtToken(userId string, sessionId string, expiresAt time.Time) (string, error) {
     token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-        "user_id": text,
-        "exp":     time.Now().Add(time.Hour * 24 * 7).Unix(),
+        "user_id": userId,
+        "sid":     sessionId,
+        "exp":     expiresAt.Unix(),
     })
 
-    // Sign and get the complete encoded token as a string using the secret
-    tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
-
-    return tokenString, err
+    return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
 }
 
 // Verifys a JWT Token
@@ -40,3 +44,41 @@ func VerifyJwtToken(tokenString string) (jwt.MapClaims, bool, error) {
     return claims, isValid, err
 }
 
+
+// How long the second step of a two-factor login can take
+const MfaChallengeLifetime = time.Minute * 5
+
+// Signs a short-lived token proving the password step of a
+// two-factor login succeeded. It names the challenge stored for that
+// login and is not accepted as an access token.
+func SignMfaChallengeToken(userId string, challengeId string, expiresAt time.Time) (string, error) {
+    token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+        "user_id": userId,
+        "cid":     challengeId,
+        "purpose": MfaChallengePurpose,
+        "exp":     expiresAt.Unix(),
+    })
+
+    return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+}
+
+// Verifys an MFA challenge token and returns the user id and challenge
+// id it was issued for
+func VerifyMfaChallengeToken(tokenString string) (string, string, error) {
+    claims, isValid, err := VerifyJwtToken(tokenString)
+    if err != nil {
+        return "", "", err
+    }
+
+    if !isValid || claims["purpose"] != MfaChallengePurpose {
+        return "", "", fmt.Errorf("Invalid MFA challenge token")
+    }
+
+    userId, ok := claims["user_id"].(string)
+    challengeId, hasChallenge := claims["cid"].(string)
+    if !ok || !hasChallenge {
+        return "", "", fmt.Errorf("Invalid MFA challenge token")
+    }
+
+    return userId, challengeId, nil
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateApiKey(t *testing.T) {
+	key, prefix, err := GenerateApiKey()
+	assert.NoError(t, err)
+	assert.True(t, IsApiKey(key))
+	assert.True(t, strings.HasPrefix(key, prefix+"_"))
+
+	parsed, ok := ApiKeyPrefixOf(key)
+	assert.True(t, ok)
+	assert.Equal(t, prefix, parsed)
+
+	other, _, _ := GenerateApiKey()
+	assert.NotEqual(t, key, other)
+	assert.NotEqual(t, HashApiKey(key), HashApiKey(other))
+	assert.Len(t, HashApiKey(key), 64)
+}
+
+func TestApiKeyPrefixOfInvalid(t *testing.T) {
+	_, ok := ApiKeyPrefixOf("eyJhbGciOiJIUzI1NiJ9.payload.signature")
+	assert.False(t, ok)
+
+	_, ok = ApiKeyPrefixOf("hng_short_secret")
+	assert.False(t, ok)
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptCookieRoundTrip(t *testing.T) {
+	t.Setenv("ENCRYPT_COOKIE_KEY", "current-key")
+
+	sealed, err := EncryptCookie("my.jwt.token")
+	assert.NoError(t, err)
+	assert.NotContains(t, sealed, "my.jwt.token")
+
+	plain, err := DecryptCookie(sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "my.jwt.token", plain)
+}
+
+func TestDecryptCookieAfterKeyRotation(t *testing.T) {
+	t.Setenv("ENCRYPT_COOKIE_KEY", "old-key")
+	sealed, err := EncryptCookie("my.jwt.token")
+	assert.NoError(t, err)
+
+	// Rotate: the old key is kept for decryption only
+	t.Setenv("ENCRYPT_COOKIE_KEY", "new-key")
+	t.Setenv("ENCRYPT_COOKIE_PREVIOUS_KEYS", "older-key, old-key")
+
+	plain, err := DecryptCookie(sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "my.jwt.token", plain)
+
+	// Once the old key is dropped the cookie is rejected
+	t.Setenv("ENCRYPT_COOKIE_PREVIOUS_KEYS", "")
+	_, err = DecryptCookie(sealed)
+	assert.ErrorIs(t, err, ErrInvalidCookie)
+}
+
+func TestDecryptCookieRejectsTampering(t *testing.T) {
+	t.Setenv("ENCRYPT_COOKIE_KEY", "current-key")
+
+	sealed, err := EncryptCookie("my.jwt.token")
+	assert.NoError(t, err)
+
+	// Flip a character in the middle of the ciphertext
+	tampered := []byte(sealed)
+	middle := len(tampered) / 2
+	if tampered[middle] == 'A' {
+		tampered[middle] = 'B'
+	} else {
+		tampered[middle] = 'A'
+	}
+
+	_, err = DecryptCookie(string(tampered))
+	assert.ErrorIs(t, err, ErrInvalidCookie)
+
+	// A raw JWT from before encryption is not accepted
+	_, err = DecryptCookie("eyJhbGciOiJIUzI1NiJ9.payload.signature")
+	assert.ErrorIs(t, err, ErrInvalidCookie)
+}
+
+func TestEncryptCookieRequiresKey(t *testing.T) {
+	t.Setenv("ENCRYPT_COOKIE_KEY", "")
+
+	_, err := EncryptCookie("my.jwt.token")
+	assert.Error(t, err)
+}
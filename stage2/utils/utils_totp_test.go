@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateTotpEnrolment(t *testing.T) {
+	enrolment, err := GenerateTotpEnrolment("jill@example.com")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, enrolment.Secret)
+	assert.True(t, strings.HasPrefix(enrolment.OtpAuthURI, "otpauth://totp/"))
+	assert.Contains(t, enrolment.OtpAuthURI, enrolment.Secret)
+	assert.True(t, strings.HasPrefix(enrolment.QRCode, "data:image/png;base64,"))
+
+	// A code generated from the secret should validate
+	code, err := totp.GenerateCode(enrolment.Secret, time.Now())
+	assert.NoError(t, err)
+	assert.True(t, ValidateTotpCode(code, enrolment.Secret))
+	assert.False(t, ValidateTotpCode("000000", ""))
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	assert.NoError(t, err)
+	assert.Len(t, codes, 10)
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		assert.Len(t, code, 11)
+		assert.Equal(t, "-", string(code[5]))
+		assert.False(t, seen[code])
+		seen[code] = true
+	}
+}
+
+func TestMfaChallengeToken(t *testing.T) {
+	t.Setenv("JWT_SECRET", "your-secret-key")
+
+	token, err := SignMfaChallengeToken("testUserID", "testChallengeID", time.Now().Add(MfaChallengeLifetime))
+	assert.NoError(t, err)
+
+	userId, challengeId, err := VerifyMfaChallengeToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "testUserID", userId)
+	assert.Equal(t, "testChallengeID", challengeId)
+
+	// Regular access tokens are not challenge tokens
+	accessToken, _ := SignSessionJwtToken("testUserID", "testSessionID", time.Now().Add(time.Hour))
+	_, _, err = VerifyMfaChallengeToken(accessToken)
+	assert.Error(t, err)
+}
+
+func TestTotpStep(t *testing.T) {
+	enrolment, err := GenerateTotpEnrolment("jill@example.com")
+	assert.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	code, err := totp.GenerateCode(enrolment.Secret, now)
+	assert.NoError(t, err)
+
+	step, ok := TotpStep(code, enrolment.Secret, now)
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix()/30, step)
+
+	// Still accepted in the next step, for the same step
+	step, ok = TotpStep(code, enrolment.Secret, now.Add(30*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix()/30, step)
+
+	_, ok = TotpStep(code, enrolment.Secret, now.Add(2*time.Minute))
+	assert.False(t, ok)
+}
@@ -2,6 +2,8 @@ package validation
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -24,10 +26,18 @@ func ValidateStruct(data interface{}) []ValidationError {
             switch err.Tag() {
             case "required":
                 element.Message = fmt.Sprintf("%s is required", err.Field())
+            case "required_without":
+                element.Message = fmt.Sprintf("%s is required when %s is not given", err.Field(), err.Param())
             case "email":
                 element.Message = fmt.Sprintf("%s must be a valid email", err.Field())
             case "unique":
                 element.Message = fmt.Sprintf("%s must be unique", err.Field())
+            case "min":
+                element.Message = strings.TrimSpace(fmt.Sprintf("%s must be at least %s %s", err.Field(), err.Param(), unit(err)))
+            case "max":
+                element.Message = strings.TrimSpace(fmt.Sprintf("%s must be at most %s %s", err.Field(), err.Param(), unit(err)))
+            case "oneof":
+                element.Message = fmt.Sprintf("%s must be one of: %s", err.Field(), err.Param())
             default:
                 element.Message = fmt.Sprintf("%s is not valid", err.Field())
             }
@@ -36,3 +46,15 @@ func ValidateStruct(data interface{}) []ValidationError {
     }
     return errors
 }
+
+// What min and max count for a field
+func unit(err validator.FieldError) string {
+    switch err.Kind() {
+    case reflect.Slice, reflect.Array, reflect.Map:
+        return "items"
+    case reflect.String:
+        return "characters"
+    default:
+        return ""
+    }
+}
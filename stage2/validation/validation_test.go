@@ -0,0 +1,39 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateStructMessages(t *testing.T) {
+	type ReqBody struct {
+		Name   string   `validate:"min=2,max=5"`
+		Role   string   `validate:"oneof=admin member"`
+		Scopes []string `validate:"min=1"`
+		Body   string   `validate:"required_without=Name"`
+	}
+
+	errors := ValidateStruct(ReqBody{Role: "owner"})
+
+	messages := map[string]string{}
+	for _, err := range errors {
+		messages[err.Field] = err.Message
+	}
+
+	assert.Equal(t, "Body is required when Name is not given", messages["Body"])
+	assert.Equal(t, "Role must be one of: admin member", messages["Role"])
+	assert.Equal(t, "Scopes must be at least 1 items", messages["Scopes"])
+}
+
+func TestValidateStructLengthMessages(t *testing.T) {
+	type ReqBody struct {
+		Name string `validate:"min=2,max=5"`
+	}
+
+	errors := ValidateStruct(ReqBody{Name: "a"})
+
+	if assert.Len(t, errors, 1) {
+		assert.Equal(t, "Name must be at least 2 characters", errors[0].Message)
+	}
+}
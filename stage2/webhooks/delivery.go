@@ -0,0 +1,253 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/outbox"
+	"github.com/mryan-3/hng11/stage2/payments"
+	"gorm.io/gorm"
+)
+
+const (
+	// Deliveries still failing after this many attempts are dead
+	MaxAttempts    = 10
+	requestTimeout = 10 * time.Second
+	// How much of a failed request's error is kept in the log
+	errorLimit = 255
+)
+
+// JobDeliver is the outbox job that sends a delivery
+const JobDeliver = "webhook.deliver"
+
+// DeliverJob is the payload of a JobDeliver job
+type DeliverJob struct {
+	DeliveryID uuid.UUID `json:"deliveryId"`
+}
+
+func init() {
+	outbox.Register(JobDeliver, outbox.Handler{
+		Run:         deliverJob,
+		MaxAttempts: MaxAttempts,
+		Backoff:     RetryDelay,
+	})
+}
+
+// Headers sent with every request. The body is signed the same way
+// payment provider webhooks are, in payments.HeaderWebhookSignature.
+const (
+	HeaderEvent    = "X-Webhook-Event"
+	HeaderDelivery = "X-Webhook-Delivery"
+)
+
+// Send a delivery to its endpoint. Failed requests are retried by the
+// outbox with backoff until MaxAttempts, after which the delivery is
+// dead. Deliveries to endpoints that are switched off wait until they
+// are switched back on.
+func deliverJob(_ context.Context, db *gorm.DB, job models.OutboxJob) error {
+	var payload DeliverJob
+	if err := outbox.Decode(job, &payload); err != nil {
+		return err
+	}
+
+	var delivery models.WebhookDelivery
+	err := db.Where("delivery_id = ?", payload.DeliveryID).First(&delivery).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if delivery.Status != models.DeliveryPending {
+		return nil
+	}
+
+	var endpoint models.WebhookEndpoint
+	err = db.First(&endpoint, "endpoint_id = ?", delivery.EndpointID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !endpoint.Active {
+		return nil
+	}
+
+	return deliver(db, Client, endpoint, delivery)
+}
+
+// Send a delivery and log the attempt. The request is made outside any
+// transaction and the attempt is saved afterwards. Returns the send
+// error if the delivery will be tried again.
+func deliver(db *gorm.DB, client *http.Client, endpoint models.WebhookEndpoint, delivery models.WebhookDelivery) error {
+	started := time.Now()
+	statusCode, sendErr := send(client, endpoint, delivery, started)
+	now := time.Now()
+
+	attempt := models.WebhookAttempt{
+		DeliveryID:  delivery.DeliveryID,
+		StatusCode:  statusCode,
+		Duration:    now.Sub(started).Milliseconds(),
+		AttemptedAt: started,
+	}
+	if sendErr != nil {
+		attempt.Error = shortError(sendErr)
+	}
+
+	attempts := delivery.Attempts + 1
+	updates := map[string]interface{}{
+		"attempts":         attempts,
+		"last_status_code": statusCode,
+		"last_error":       attempt.Error,
+	}
+
+	if sendErr == nil {
+		updates["status"] = models.DeliveryDelivered
+		updates["delivered_at"] = now
+	} else {
+		updates["next_attempt_at"] = now.Add(RetryDelay(attempts))
+		if attempts >= MaxAttempts {
+			updates["status"] = models.DeliveryDead
+			log.Printf("Webhook delivery %s to %s is dead: %v", delivery.DeliveryID, endpoint.URL, sendErr)
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&attempt).Error; err != nil {
+			return err
+		}
+		return tx.Model(&delivery).Updates(updates).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if attempts >= MaxAttempts {
+		return nil
+	}
+	return sendErr
+}
+
+// POST a delivery to its endpoint. Anything but a 2xx response is a
+// failure. The response body is discarded, so endpoints can't be used
+// to read from wherever they point.
+func send(client *http.Client, endpoint models.WebhookEndpoint, delivery models.WebhookDelivery, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	body := []byte(delivery.Payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "HNG11-Webhooks/1.0")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.DeliveryID.String())
+	req.Header.Set(payments.HeaderWebhookSignature, payments.Sign([]byte(endpoint.Secret), now, body))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	// Read a little so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, &StatusError{Code: res.StatusCode}
+	}
+
+	return res.StatusCode, nil
+}
+
+// A failed request's error, cut down to fit the log
+func shortError(err error) string {
+	if errors.Is(err, ErrBlockedAddress) {
+		return ErrBlockedAddress.Error()
+	}
+
+	message := err.Error()
+	if len(message) > errorLimit {
+		message = message[:errorLimit]
+	}
+	return message
+}
+
+// StatusError is an endpoint answering with something other than 2xx
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return "endpoint responded with " + strconv.Itoa(e.Code)
+}
+
+// Wait 30 seconds after the first failure, doubling up to twelve hours
+func RetryDelay(attempts int) time.Duration {
+	const first, longest = 30 * time.Second, 12 * time.Hour
+
+	delay := first
+	for i := 1; i < attempts && delay < longest; i++ {
+		delay *= 2
+	}
+	if delay > longest {
+		delay = longest
+	}
+	return delay
+}
+
+// Send a delivery again, e.g. a dead one once its endpoint is fixed. It
+// gets a fresh set of attempts.
+func Redeliver(db *gorm.DB, delivery *models.WebhookDelivery) error {
+	delivery.Status = models.DeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(delivery).Updates(map[string]interface{}{
+			"status":          delivery.Status,
+			"attempts":        delivery.Attempts,
+			"next_attempt_at": delivery.NextAttemptAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		_, err = outbox.Enqueue(tx, JobDeliver, DeliverJob{DeliveryID: delivery.DeliveryID})
+		return err
+	})
+}
+
+// Queue the deliveries left waiting while an endpoint was switched off,
+// once it is switched back on
+func Resume(tx *gorm.DB, endpoint models.WebhookEndpoint) error {
+	var deliveries []models.WebhookDelivery
+	err := tx.Where("endpoint_id = ? AND status = ?", endpoint.EndpointID, models.DeliveryPending).
+		Order("created_at, delivery_id").
+		Find(&deliveries).Error
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range deliveries {
+		if _, err := outbox.Enqueue(tx, JobDeliver, DeliverJob{DeliveryID: delivery.DeliveryID}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
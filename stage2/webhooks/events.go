@@ -0,0 +1,108 @@
+// Package webhooks sends organisation events to the endpoints their
+// admins register. Events are queued with the change they describe and
+// delivered by the outbox workers, signed, with retries.
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/outbox"
+	"gorm.io/gorm"
+)
+
+// Events endpoints can subscribe to
+const (
+	MemberAdded         = "member.added"
+	MemberUpdated       = "member.updated"
+	MemberRemoved       = "member.removed"
+	OrganisationUpdated = "organisation.updated"
+)
+
+// Every event, in the order they're documented
+var Events = []string{
+	MemberAdded,
+	MemberUpdated,
+	MemberRemoved,
+	OrganisationUpdated,
+}
+
+func IsEvent(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Payload is the body of every webhook request
+type Payload struct {
+	ID        uuid.UUID              `json:"id"`
+	Type      string                 `json:"type"`
+	OrgID     uuid.UUID              `json:"orgId"`
+	CreatedAt time.Time              `json:"createdAt"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// Queue an event for each of the organisation's active endpoints that
+// want it. Pass the transaction making the change the event is about so
+// it is only sent if that change commits.
+func Emit(tx *gorm.DB, orgId uuid.UUID, event string, data map[string]interface{}) error {
+	var endpoints []models.WebhookEndpoint
+	if err := tx.Where("organisation_id = ? AND active", orgId).Find(&endpoints).Error; err != nil {
+		return err
+	}
+
+	payload := Payload{
+		ID:        uuid.New(),
+		Type:      event,
+		OrgID:     orgId,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	for _, endpoint := range endpoints {
+		if !endpoint.Subscribed(event) {
+			continue
+		}
+
+		delivery := models.WebhookDelivery{
+			DeliveryID:     uuid.New(),
+			EndpointID:     endpoint.EndpointID,
+			OrganisationID: orgId,
+			EventID:        payload.ID,
+			Event:          event,
+			Payload:        string(body),
+			Status:         models.DeliveryPending,
+			NextAttemptAt:  payload.CreatedAt,
+		}
+		if err := tx.Create(&delivery).Error; err != nil {
+			return err
+		}
+
+		if _, err := outbox.Enqueue(tx, JobDeliver, DeliverJob{DeliveryID: delivery.DeliveryID}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Generate a secret for signing an endpoint's requests
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
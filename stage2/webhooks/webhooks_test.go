@@ -0,0 +1,133 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mryan-3/hng11/stage2/models"
+	"github.com/mryan-3/hng11/stage2/payments"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, 30*time.Second, RetryDelay(1))
+	assert.Equal(t, time.Minute, RetryDelay(2))
+	assert.Equal(t, 4*time.Minute, RetryDelay(4))
+	assert.Equal(t, 12*time.Hour, RetryDelay(MaxAttempts+20))
+}
+
+func TestSubscribed(t *testing.T) {
+	all := models.WebhookEndpoint{}
+	assert.True(t, all.Subscribed(MemberAdded))
+
+	some := models.WebhookEndpoint{Events: MemberAdded + " " + MemberRemoved}
+	assert.True(t, some.Subscribed(MemberRemoved))
+	assert.False(t, some.Subscribed(OrganisationUpdated))
+}
+
+func TestSendSignsTheBody(t *testing.T) {
+	endpoint := models.WebhookEndpoint{Secret: "whsec_test"}
+	delivery := models.WebhookDelivery{
+		DeliveryID: uuid.New(),
+		Event:      MemberAdded,
+		Payload:    `{"type":"member.added"}`,
+	}
+
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		body, _ := io.ReadAll(r.Body)
+
+		err := payments.VerifySignature(r.Header.Get(payments.HeaderWebhookSignature), body, []byte("whsec_test"), payments.DefaultSignatureTolerance, time.Now())
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("internal"))
+	}))
+	defer server.Close()
+
+	endpoint.URL = server.URL
+	status, err := send(server.Client(), endpoint, delivery, time.Now())
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, MemberAdded, received.Get(HeaderEvent))
+	assert.Equal(t, delivery.DeliveryID.String(), received.Get(HeaderDelivery))
+}
+
+func TestSendFailsOnNon2xx(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	endpoint := models.WebhookEndpoint{URL: server.URL, Secret: "whsec_test"}
+	status, err := send(server.Client(), endpoint, models.WebhookDelivery{Payload: "{}"}, time.Now())
+
+	assert.Equal(t, http.StatusBadGateway, status)
+	assert.ErrorAs(t, err, new(*StatusError))
+}
+
+func TestIsBlocked(t *testing.T) {
+	for _, addr := range []string{
+		"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254",
+		"100.64.0.1", "0.0.0.0", "::1", "fd00::1", "fe80::1", "::ffff:127.0.0.1",
+	} {
+		assert.True(t, IsBlocked(net.ParseIP(addr)), addr)
+	}
+
+	for _, addr := range []string{"93.184.216.34", "2606:2800:220:1::1"} {
+		assert.False(t, IsBlocked(net.ParseIP(addr)), addr)
+	}
+}
+
+func TestCheckURLRefusesInternalHosts(t *testing.T) {
+	ctx := context.Background()
+
+	assert.ErrorIs(t, CheckURL(ctx, "http://169.254.169.254/latest/meta-data"), ErrBlockedAddress)
+	assert.ErrorIs(t, CheckURL(ctx, "http://localhost:5432"), ErrBlockedAddress)
+	assert.ErrorIs(t, CheckURL(ctx, "https://[::1]/hook"), ErrBlockedAddress)
+	assert.NoError(t, CheckURL(ctx, "https://93.184.216.34/hook"))
+}
+
+func TestClientRefusesInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	defer server.Close()
+
+	endpoint := models.WebhookEndpoint{URL: server.URL, Secret: "whsec_test"}
+	status, err := send(Client, endpoint, models.WebhookDelivery{Payload: "{}"}, time.Now())
+
+	assert.Equal(t, 0, status)
+	assert.ErrorIs(t, err, ErrBlockedAddress)
+}
+
+func TestSendDoesNotFollowRedirects(t *testing.T) {
+	followed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/elsewhere" {
+			followed = true
+			return
+		}
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	}))
+	defer server.Close()
+
+	client := server.Client()
+	client.CheckRedirect = Client.CheckRedirect
+
+	endpoint := models.WebhookEndpoint{URL: server.URL, Secret: "whsec_test"}
+	status, err := send(client, endpoint, models.WebhookDelivery{Payload: "{}"}, time.Now())
+
+	assert.False(t, followed)
+	assert.Equal(t, http.StatusFound, status)
+	assert.ErrorAs(t, err, new(*StatusError))
+}